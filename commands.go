@@ -0,0 +1,138 @@
+package main
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/reconcile"
+	"dbutil/src/tax"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = "Usage: dbutil [migrate <name> | reconcile [--freeze] | corporate-action --id <id> --symbol <symbol> (--split <to:from> | --dividend <amount> --record-date <date>) | tax-report --email <email> [--year <year>] [--csv]]"
+
+// runCommand runs one of the administrative subcommands instead of the server.
+func runCommand(store db.Store, args []string) {
+	switch args[0] {
+	case "migrate":
+		migrate(store, args[1:])
+	case "reconcile":
+		reconcileAccounts(store, args[1:])
+	case "corporate-action":
+		applyCorporateAction(store, args[1:])
+	case "tax-report":
+		taxReport(store, args[1:])
+	default:
+		log.Fatal(usage)
+	}
+}
+
+func migrate(store db.Store, args []string) {
+	if len(args) != 1 {
+		log.Fatal("Usage: dbutil migrate <" + strings.Join(db.MigrationNames(), "|") + ">")
+	}
+
+	count, err := db.RunMigration(store, args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger.Info(fmt.Sprintf("Migration %s updated %d documents", args[0], count))
+}
+
+// reconcileAccounts prints the reconciliation report as JSON and exits with
+// status 1 when an account failed.
+func reconcileAccounts(store db.Store, args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	freeze := flags.Bool("freeze", false, "freeze accounts that fail reconciliation")
+	_ = flags.Parse(args)
+
+	report, err := reconcile.NewReconciler(store, *freeze).Run()
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+// applyCorporateAction applies a split or cash dividend and prints what it
+// did as JSON.
+func applyCorporateAction(store db.Store, args []string) {
+	flags := flag.NewFlagSet("corporate-action", flag.ExitOnError)
+	id := flags.String("id", "", "unique id of the action, which keeps it from being applied twice")
+	symbol := flags.String("symbol", "", "symbol the action applies to")
+	split := flags.String("split", "", "split ratio, such as 2:1")
+	dividend := flags.String("dividend", "", "cash dividend per share, such as 0.24")
+	currency := flags.String("currency", "", "currency of the dividend")
+	recordDate := flags.String("record-date", "", "day whose holders the dividend pays, such as 2006-01-02")
+	_ = flags.Parse(args)
+
+	action := models.CorporateAction{ID: *id, Symbol: *symbol}
+	var err error
+	switch {
+	case *split != "" && *dividend == "":
+		action.Type = models.CorporateSplit
+		action.SplitTo, action.SplitFrom, err = models.ParseSplitRatio(*split)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case *dividend != "" && *split == "":
+		action.Type = models.CorporateDividend
+		amount, err := models.ParseMoney(*dividend, *currency)
+		if err != nil {
+			log.Fatal(err)
+		}
+		action.AmountPerShare = &amount
+		date, err := models.ParseRecordDate(*recordDate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		action.RecordDate = &date
+	default:
+		log.Fatal(usage)
+	}
+
+	action, err = store.ApplyCorporateAction(action)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(action)
+}
+
+// taxReport prints the tax report of a user as JSON, or as CSV with --csv.
+func taxReport(store db.Store, args []string) {
+	flags := flag.NewFlagSet("tax-report", flag.ExitOnError)
+	email := flags.String("email", "", "user to report on")
+	year := flags.Int("year", time.Now().UTC().Year(), "calendar year of the sales")
+	asCSV := flags.Bool("csv", false, "write CSV instead of JSON")
+	_ = flags.Parse(args)
+	if *email == "" {
+		log.Fatal(usage)
+	}
+
+	report, err := tax.NewReporter(store).Report(*email, *year)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *asCSV {
+		err = report.WriteCSV(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+}
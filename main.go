@@ -1,32 +1,139 @@
 package main
 
 import (
+	"dbutil/src/alerts"
+	"dbutil/src/auth"
+	"dbutil/src/config"
 	db "dbutil/src/database"
 	"dbutil/src/handlers"
 	logger "dbutil/src/logging"
+	"dbutil/src/mail"
+	"dbutil/src/models"
+	"dbutil/src/orders"
+	"dbutil/src/portfolio"
+	"dbutil/src/quotes"
+	"dbutil/src/reconcile"
+	"dbutil/src/tax"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	client, err := db.ConnectToDB()
+	appConfig := config.GetConfig()
+	provider, err := quotes.NewProvider(appConfig.Quotes)
 	if err != nil {
-		logger.Error(err)
+		log.Fatal(err)
+	}
+	store, err := db.NewStore(appConfig, provider)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 {
+		runCommand(store, os.Args[1:])
+		return
+	}
+
+	mailer, err := mail.NewMailer(appConfig.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	confirmer := auth.NewConfirmer(store, mailer, appConfig.EmailConfirmation)
+	cleanupInterval := time.Duration(appConfig.EmailConfirmation.CleanupIntervalMinutes) * time.Minute
+	if cleanupInterval <= 0 {
+		cleanupInterval = time.Hour
+	}
+	go confirmer.RunCleanup(cleanupInterval)
+
+	sessions := auth.NewSessions(store, appConfig.Auth)
+
+	clock := models.SystemClock{}
+	idempotencyTTL := time.Duration(appConfig.Idempotency.TTLHours) * time.Hour
+	if idempotencyTTL <= 0 {
+		idempotencyTTL = 24 * time.Hour
 	}
-	logger.Info(client)
-	logger.Info("Connected to mongodb...")
+
+	if appConfig.Reconciliation.IntervalMinutes > 0 {
+		reconciler := reconcile.NewReconciler(store, appConfig.Reconciliation.FreezeAccounts)
+		go reconciler.RunEvery(time.Duration(appConfig.Reconciliation.IntervalMinutes) * time.Minute)
+	}
+
+	matcher := orders.NewMatcher(store, provider)
+	matchInterval := time.Duration(appConfig.Orders.MatchIntervalSeconds) * time.Second
+	if matchInterval <= 0 {
+		matchInterval = 5 * time.Second
+	}
+	go matcher.RunEvery(matchInterval)
+
+	notifier, err := alerts.NewNotifier(appConfig.Alerts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	valuer := portfolio.NewValuer(store, provider)
+	alertInterval := time.Duration(appConfig.Alerts.IntervalSeconds) * time.Second
+	if alertInterval <= 0 {
+		alertInterval = time.Minute
+	}
+	go alerts.NewWorker(store, provider, valuer, notifier).RunEvery(alertInterval)
 
 	router := mux.NewRouter().StrictSlash(true)
-	router.HandleFunc("/user/register", handlers.Register(client)).Methods("POST")
-	router.HandleFunc("/user/{email}", handlers.GetUser(client)).Methods("GET")
-	router.HandleFunc("/user/update/{email}/{status}", handlers.UpdateUserStatus(client)).Methods("PUT")
-	router.HandleFunc("/user/delete/{email}/{password}", handlers.DeleteUser(client)).Methods("DELETE")
-	router.HandleFunc("/user/authenticate/{email}/{password}", handlers.AuthenticateUser(client)).Methods("GET")
-	router.HandleFunc("/user/share/{email}/{transactiontype}", handlers.SaveShare(client)).Methods("PUT")
-	router.HandleFunc("/user/update/emailconfirmation/{email}", handlers.ConfirmEmail(client)).Methods("PUT")
-	router.HandleFunc("/user/update/addbalance/{email}/{amount}", handlers.AddToBalance(client)).Methods("PUT")
+	router.HandleFunc("/user/register", handlers.Register(store, confirmer)).Methods("POST")
+	router.HandleFunc("/user/authenticate", handlers.AuthenticateUser(store, sessions)).Methods("POST")
+	router.HandleFunc("/user/token/refresh", handlers.RefreshSession(sessions)).Methods("POST")
+	router.HandleFunc("/user/token/revoke", handlers.RevokeSession(sessions)).Methods("POST")
+	router.HandleFunc("/user", handlers.DeleteUser(store)).Methods("DELETE")
+	router.HandleFunc("/quote/{symbol}", handlers.GetQuote(provider)).Methods("GET")
+	router.HandleFunc("/user/update/emailconfirmation/{email}", handlers.ConfirmEmail(confirmer)).Methods("PUT")
+	router.HandleFunc("/user/update/emailconfirmation/{email}/resend", handlers.ResendConfirmation(confirmer)).Methods("PUT")
+	if appConfig.LegacyCredentialRoutes {
+		router.HandleFunc("/user/delete/{email}/{password}", handlers.Deprecated("/user", handlers.DeleteUser(store))).Methods("DELETE")
+		router.HandleFunc("/user/authenticate/{email}/{password}", handlers.Deprecated("/user/authenticate", handlers.AuthenticateUser(store, sessions))).Methods("GET")
+	}
+
+	// Everything below requires an access token and may only touch the
+	// caller's own account. The public routes above are matched first, which
+	// matters for /user/update/emailconfirmation/{email}.
+	protected := router.NewRoute().Subrouter()
+	protected.Use(auth.Middleware(sessions))
+	protected.HandleFunc("/user/{email}", handlers.GetUser(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/holdings", handlers.GetHoldings(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/portfolio", handlers.GetPortfolio(valuer)).Methods("GET")
+	protected.HandleFunc("/user/{email}/balance", handlers.GetBalance(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/withdrawals", handlers.Withdraw(store)).Methods("POST")
+	protected.HandleFunc("/user/{email}/holds", handlers.GetHolds(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/holds/{holdID}/cancel", handlers.CancelHold(store)).Methods("PUT")
+	protected.HandleFunc("/user/{email}/orders", handlers.Idempotent(store, clock, idempotencyTTL, handlers.PlaceOrder(store, matcher))).Methods("POST")
+	protected.HandleFunc("/user/{email}/orders", handlers.GetOrders(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/orders/{orderID}/cancel", handlers.CancelOrder(store)).Methods("PUT")
+	protected.HandleFunc("/user/{email}/ledger", handlers.GetLedger(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/ledger/verify", handlers.VerifyBalance(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/transactions", handlers.GetTransactions(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/tax-report", handlers.GetTaxReport(tax.NewReporter(store))).Methods("GET")
+	protected.HandleFunc("/user/{email}/watchlists", handlers.CreateWatchlist(store, provider)).Methods("POST")
+	protected.HandleFunc("/user/{email}/watchlists", handlers.GetWatchlists(store, provider)).Methods("GET")
+	protected.HandleFunc("/user/{email}/watchlists/{watchlistID}", handlers.GetWatchlist(store, provider)).Methods("GET")
+	protected.HandleFunc("/user/{email}/watchlists/{watchlistID}", handlers.UpdateWatchlist(store, provider)).Methods("PUT")
+	protected.HandleFunc("/user/{email}/watchlists/{watchlistID}", handlers.DeleteWatchlist(store)).Methods("DELETE")
+	protected.HandleFunc("/user/{email}/alerts", handlers.CreateAlert(store)).Methods("POST")
+	protected.HandleFunc("/user/{email}/alerts", handlers.GetAlerts(store)).Methods("GET")
+	protected.HandleFunc("/user/{email}/alerts/{alertID}/cancel", handlers.CancelAlert(store)).Methods("PUT")
+	protected.HandleFunc("/user/update/{email}/{status}", handlers.UpdateUserStatus(store)).Methods("PUT")
+	protected.HandleFunc("/user/share/{email}/{transactiontype}", handlers.Idempotent(store, clock, idempotencyTTL, handlers.SaveShare(store))).Methods("PUT")
+	protected.HandleFunc("/user/update/addbalance/{email}/{amount}", handlers.Idempotent(store, clock, idempotencyTTL, handlers.AddToBalance(store))).Methods("PUT")
+
+	// Administrative routes are limited to the users listed in admin.emails.
+	admin := protected.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.RequireAdmin(appConfig.Admin.Emails))
+	admin.HandleFunc("/corporate-actions", handlers.ApplyCorporateAction(store)).Methods("POST")
+	admin.HandleFunc("/corporate-actions", handlers.GetCorporateActions(store)).Methods("GET")
+	admin.HandleFunc("/users/{user}/status", handlers.ChangeAccountStatus(store)).Methods("PUT")
+	admin.HandleFunc("/users/{user}/status-history", handlers.GetStatusHistory(store)).Methods("GET")
+	admin.HandleFunc("/users/{user}/holds/{holdID}/settle", handlers.SettleHold(store)).Methods("PUT")
 
 	logger.Info("dbutil is running")
 	log.Fatal(http.ListenAndServe(":8080", router))
@@ -0,0 +1,122 @@
+package alerts
+
+import (
+	"bytes"
+	"dbutil/src/config"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Notifier tells a user that one of their alerts triggered.
+type Notifier interface {
+	Notify(alert models.Alert) error
+}
+
+// NewNotifier returns the Notifier selected by the "alerts" section of
+// config.json.
+func NewNotifier(alertsConfig config.AlertsConfig) (Notifier, error) {
+	switch alertsConfig.Notifier {
+	case "", "log":
+		return NewLogNotifier(), nil
+	case "webhook":
+		if alertsConfig.WebhookURL == "" {
+			return nil, fmt.Errorf("The webhook notifier needs alerts.webhookURL")
+		}
+		return NewWebhookNotifier(alertsConfig.WebhookURL), nil
+	case "memory":
+		return NewMemoryNotifier(), nil
+	}
+	return nil, fmt.Errorf("Unknown alerts notifier %q", alertsConfig.Notifier)
+}
+
+// LogNotifier writes triggered alerts to the log. It is meant for local runs.
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(alert models.Alert) error {
+	logger.Info("Alert " + alert.ID.Hex() + " of " + alert.Email + " triggered: " + describe(alert))
+	return nil
+}
+
+// WebhookNotifier posts every triggered alert as JSON to a URL. Responses
+// other than 2xx count as failures.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *WebhookNotifier) Notify(alert models.Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	response, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+// MemoryNotifier keeps triggered alerts in memory so tests can inspect them.
+type MemoryNotifier struct {
+	mu     sync.Mutex
+	alerts []models.Alert
+}
+
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+func (n *MemoryNotifier) Notify(alert models.Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+// Alerts returns a copy of every alert notified so far.
+func (n *MemoryNotifier) Alerts() []models.Alert {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	alerts := make([]models.Alert, len(n.alerts))
+	copy(alerts, n.alerts)
+	return alerts
+}
+
+// describe says in words what triggered alert.
+func describe(alert models.Alert) string {
+	value := ""
+	if alert.TriggeredValue != nil {
+		value = alert.TriggeredValue.String()
+	}
+	switch alert.Kind {
+	case models.AlertPriceAbove:
+		return alert.Symbol + " traded at " + value + ", at or above " + alert.Price.String()
+	case models.AlertPriceBelow:
+		return alert.Symbol + " traded at " + value + ", at or below " + alert.Price.String()
+	case models.AlertPortfolioDown:
+		return fmt.Sprintf("portfolio fell to %s, down at least %d bps today", value, alert.Bps)
+	case models.AlertPortfolioUp:
+		return fmt.Sprintf("portfolio rose to %s, up at least %d bps today", value, alert.Bps)
+	}
+	return string(alert.Kind)
+}
@@ -0,0 +1,172 @@
+package alerts
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/portfolio"
+	"dbutil/src/quotes"
+	"errors"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+const bpsPerUnit = 10000
+
+// Worker evaluates active alerts against the quotes and notifies their users
+// when one triggers.
+type Worker struct {
+	store    db.AlertStore
+	quotes   quotes.Provider
+	valuer   *portfolio.Valuer
+	notifier Notifier
+	// clock decides which day portfolio baselines belong to.
+	clock models.Clock
+}
+
+func NewWorker(store db.AlertStore, provider quotes.Provider, valuer *portfolio.Valuer, notifier Notifier) *Worker {
+	return &Worker{store: store, quotes: provider, valuer: valuer, notifier: notifier, clock: models.SystemClock{}}
+}
+
+// SetClock replaces the clock, which is the system clock by default. It
+// should be the clock of the store.
+func (w *Worker) SetClock(clock models.Clock) {
+	w.clock = clock
+}
+
+// Run evaluates every active alert once and returns how many of them
+// triggered.
+func (w *Worker) Run() (int, error) {
+	active, err := w.store.GetActiveAlerts()
+	if err != nil {
+		return 0, err
+	}
+	triggered := 0
+	for _, alert := range active {
+		result, err := w.Evaluate(alert)
+		if err != nil {
+			if !errors.Is(err, db.ErrAlertNotActive) {
+				logger.Error("Unable to evaluate alert " + alert.ID.Hex() + ": " + err.Error())
+			}
+			continue
+		}
+		if result.Status == models.AlertTriggered {
+			triggered++
+		}
+	}
+	return triggered, nil
+}
+
+// RunEvery evaluates the active alerts each interval. It never returns.
+func (w *Worker) RunEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		triggered, err := w.Run()
+		if err != nil {
+			logger.Error("Unable to evaluate alerts: " + err.Error())
+			continue
+		}
+		if triggered > 0 {
+			logger.Info("Triggered " + strconv.Itoa(triggered) + " alerts")
+		}
+	}
+}
+
+// Evaluate triggers an active alert whose threshold was reached and notifies
+// its user. The store only triggers an alert once, so a notification is sent
+// at most once; one that fails is logged and not retried. It returns the
+// alert as it is afterwards.
+func (w *Worker) Evaluate(alert models.Alert) (models.Alert, error) {
+	if alert.Status != models.AlertActive {
+		return alert, nil
+	}
+
+	var value models.Money
+	var reached bool
+	var err error
+	if alert.Kind.WatchesPortfolio() {
+		value, reached, err = w.portfolioMoved(alert)
+	} else {
+		value, reached, err = w.priceReached(alert)
+	}
+	if err != nil || !reached {
+		return alert, err
+	}
+
+	triggered, err := w.store.TriggerAlert(alert.ID.Hex(), value)
+	if err != nil {
+		return alert, err
+	}
+	err = w.notifier.Notify(triggered)
+	if err != nil {
+		logger.Error("Unable to notify " + triggered.Email + " of alert " + triggered.ID.Hex() + ": " + err.Error())
+	}
+	return triggered, nil
+}
+
+// priceReached reports whether the quote of the symbol of alert is at or
+// beyond its price.
+func (w *Worker) priceReached(alert models.Alert) (models.Money, bool, error) {
+	quote, err := w.quotes.Quote(alert.Symbol)
+	if err != nil {
+		return models.Money{}, false, err
+	}
+	price := quote.Price
+	if !price.SameCurrency(*alert.Price) {
+		return price, false, db.ErrCurrencyMismatch
+	}
+	if alert.Kind == models.AlertPriceAbove {
+		return price, price.Amount >= alert.Price.Amount, nil
+	}
+	return price, price.Amount <= alert.Price.Amount, nil
+}
+
+// portfolioMoved reports whether the market value of the positions of the
+// user in the currency of alert moved its basis points from the baseline.
+// The first evaluation of every UTC day, and any while the baseline is zero,
+// only records the value as the new baseline. Portfolios with positions that
+// have no quote are skipped, as their value is incomplete.
+func (w *Worker) portfolioMoved(alert models.Alert) (models.Money, bool, error) {
+	valuation, err := w.valuer.Value(alert.Email)
+	if err != nil {
+		return models.Money{}, false, err
+	}
+	value := models.NewMoney(0, alert.Currency)
+	for _, totals := range valuation.Totals {
+		if totals.Currency != alert.Currency {
+			continue
+		}
+		if totals.Unpriced > 0 {
+			return value, false, nil
+		}
+		value = totals.MarketValue
+	}
+
+	now := w.clock.Now().UTC()
+	if alert.Baseline == nil || !alert.Baseline.IsPositive() || alert.BaselineAt == nil || !sameDay(*alert.BaselineAt, now) {
+		return value, false, w.store.SetAlertBaseline(alert.ID.Hex(), value, now)
+	}
+	return value, moved(alert, value), nil
+}
+
+// moved reports whether value is the basis points of alert below or above
+// its baseline. The products are worked out in big.Int, where no market
+// value can overflow them.
+func moved(alert models.Alert, value models.Money) bool {
+	scaled := new(big.Int).Mul(big.NewInt(value.Amount), big.NewInt(bpsPerUnit))
+	if alert.Kind == models.AlertPortfolioDown {
+		bound := new(big.Int).Mul(big.NewInt(alert.Baseline.Amount), big.NewInt(bpsPerUnit-alert.Bps))
+		return scaled.Cmp(bound) <= 0
+	}
+	bound := new(big.Int).Mul(big.NewInt(alert.Baseline.Amount), big.NewInt(bpsPerUnit+alert.Bps))
+	return scaled.Cmp(bound) >= 0
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	a = a.UTC()
+	b = b.UTC()
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
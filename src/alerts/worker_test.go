@@ -0,0 +1,124 @@
+package alerts
+
+import (
+	"dbutil/src/config"
+	"dbutil/src/models"
+	"dbutil/src/portfolio"
+	"dbutil/src/testutil"
+	"testing"
+	"time"
+)
+
+// alertWorld is a world without fees in which the test user holds 10 AAPL
+// bought at 150.00, with a worker on its clock.
+type alertWorld struct {
+	*testutil.World
+	notifier *MemoryNotifier
+	worker   *Worker
+}
+
+func newAlertWorld(t *testing.T) *alertWorld {
+	t.Helper()
+	w := testutil.NewWorld(t, config.Configuration{})
+	w.Register(t, testutil.Email, "5000.00")
+	w.Buy(t, testutil.Email, "AAPL", 10)
+
+	valuer := portfolio.NewValuer(w.Store, w.Quotes)
+	valuer.SetClock(w.Clock)
+	notifier := NewMemoryNotifier()
+	worker := NewWorker(w.Store, w.Quotes, valuer, notifier)
+	worker.SetClock(w.Clock)
+	return &alertWorld{World: w, notifier: notifier, worker: worker}
+}
+
+func (w *alertWorld) createAlert(t *testing.T, alert models.Alert) models.Alert {
+	t.Helper()
+	created, err := w.Store.CreateAlert(testutil.Email, alert)
+	if err != nil {
+		t.Fatalf("CreateAlert: %v", err)
+	}
+	return created
+}
+
+func (w *alertWorld) run(t *testing.T, want int) {
+	t.Helper()
+	triggered, err := w.worker.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if triggered != want {
+		t.Fatalf("Run triggered %d alerts, want %d", triggered, want)
+	}
+}
+
+func TestPriceAlertNotifiesOnce(t *testing.T) {
+	w := newAlertWorld(t)
+	price := testutil.USD(t, "160.00")
+	alert := w.createAlert(t, models.Alert{Kind: models.AlertPriceAbove, Symbol: "aapl", Price: &price})
+
+	w.run(t, 0)
+	w.SetPrice(t, "AAPL", "160.50")
+	w.run(t, 1)
+	w.run(t, 0)
+
+	notified := w.notifier.Alerts()
+	if len(notified) != 1 || notified[0].ID != alert.ID {
+		t.Fatalf("notified %+v, want the alert once", notified)
+	}
+	if notified[0].TriggeredValue == nil || *notified[0].TriggeredValue != testutil.USD(t, "160.50") {
+		t.Fatalf("alert triggered at %v, want 160.50", notified[0].TriggeredValue)
+	}
+	if notified[0].TriggeredAt == nil || !notified[0].TriggeredAt.Equal(w.Clock.Now()) {
+		t.Fatalf("alert triggered at %v, want the store clock %s", notified[0].TriggeredAt, w.Clock.Now())
+	}
+}
+
+func TestCancelledAlertDoesNotNotify(t *testing.T) {
+	w := newAlertWorld(t)
+	price := testutil.USD(t, "140.00")
+	alert := w.createAlert(t, models.Alert{Kind: models.AlertPriceBelow, Symbol: "AAPL", Price: &price})
+	_, err := w.Store.CancelAlert(testutil.Email, alert.ID.Hex())
+	if err != nil {
+		t.Fatalf("CancelAlert: %v", err)
+	}
+
+	w.SetPrice(t, "AAPL", "130.00")
+	w.run(t, 0)
+	if notified := w.notifier.Alerts(); len(notified) != 0 {
+		t.Fatalf("notified %+v, want nothing", notified)
+	}
+}
+
+func TestPortfolioAlertComparesWithBaselineOfTheDay(t *testing.T) {
+	w := newAlertWorld(t)
+	w.createAlert(t, models.Alert{Kind: models.AlertPortfolioDown, Bps: 500, Currency: "USD"})
+
+	// The first evaluation records 1500.00 as the baseline.
+	w.run(t, 0)
+	w.SetPrice(t, "AAPL", "144.00")
+	w.run(t, 0)
+
+	// A new day starts from the value it opens at, so the drop of the day
+	// before is not counted.
+	w.Clock.Advance(24 * time.Hour)
+	w.SetPrice(t, "AAPL", "140.00")
+	w.run(t, 0)
+	w.SetPrice(t, "AAPL", "133.00")
+	w.run(t, 1)
+
+	notified := w.notifier.Alerts()
+	if len(notified) != 1 || notified[0].TriggeredValue == nil || *notified[0].TriggeredValue != testutil.USD(t, "1330.00") {
+		t.Fatalf("notified %+v, want one alert at 1330.00", notified)
+	}
+}
+
+func TestPortfolioAlertOfLargeValueDoesNotOverflow(t *testing.T) {
+	w := newAlertWorld(t)
+	w.createAlert(t, models.Alert{Kind: models.AlertPortfolioUp, Bps: 500, Currency: "USD"})
+
+	w.run(t, 0)
+	// 10 shares are worth 10000000000000.00, which overflows int64 once
+	// multiplied by 10000 basis points.
+	w.SetPrice(t, "AAPL", "1000000000000.00")
+	w.run(t, 1)
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dbutil/src/config"
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/mail"
+	"dbutil/src/models"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInvalidToken     = errors.New("Confirmation token is invalid.")
+	ErrTokenExpired     = errors.New("Confirmation token has expired.")
+	ErrAlreadyConfirmed = errors.New("Email is already confirmed.")
+)
+
+// Confirmer issues, mails and verifies the tokens users need to confirm the
+// email address they registered with.
+//
+// A token is "<payload>.<signature>" where the payload carries the email, the
+// expiry and a random nonce, and the signature is an HMAC-SHA256 over the
+// payload. Only a hash of each token is stored, and a token stops working as
+// soon as it is used or a new one is sent.
+type Confirmer struct {
+	store  db.Store
+	mailer mail.Mailer
+	secret []byte
+	ttl    time.Duration
+	url    string
+	// clock sets when tokens expire and decides whether they have.
+	clock models.Clock
+}
+
+func NewConfirmer(store db.Store, mailer mail.Mailer, confirmationConfig config.EmailConfirmationConfig) *Confirmer {
+	secret := []byte(confirmationConfig.Secret)
+	if len(secret) == 0 {
+		logger.Error("No email confirmation secret configured, tokens will not survive a restart")
+		secret = make([]byte, 32)
+		_, _ = rand.Read(secret)
+	}
+	ttl := time.Duration(confirmationConfig.TTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &Confirmer{
+		store:  store,
+		mailer: mailer,
+		secret: secret,
+		ttl:    ttl,
+		url:    confirmationConfig.URL,
+		clock:  models.SystemClock{},
+	}
+}
+
+// SetClock replaces the clock, which is the system clock by default.
+func (c *Confirmer) SetClock(clock models.Clock) {
+	c.clock = clock
+}
+
+// SendConfirmation replaces any outstanding token for email with a new one
+// and mails it to the user.
+func (c *Confirmer) SendConfirmation(email string) error {
+	token, expiresAt, err := c.newToken(email)
+	if err != nil {
+		logger.Error("Unable to generate confirmation token: " + err.Error())
+		return err
+	}
+
+	err = c.store.DeleteConfirmationTokens(email)
+	if err != nil {
+		return err
+	}
+	err = c.store.SaveConfirmationToken(models.ConfirmationToken{
+		Email:     email,
+		TokenHash: hashToken(token),
+		CreatedAt: c.clock.Now(),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body:    c.messageBody(email, token, expiresAt),
+	})
+}
+
+// ResendConfirmation sends a fresh token to a user who has not confirmed yet.
+func (c *Confirmer) ResendConfirmation(email string) error {
+	user, err := c.store.GetUserData(email)
+	if err != nil {
+		return err
+	}
+	if user.EmailConfimed {
+		return ErrAlreadyConfirmed
+	}
+	return c.SendConfirmation(email)
+}
+
+// Confirm checks token against email, marks the email as confirmed and
+// activates the account if it is pending.
+func (c *Confirmer) Confirm(email string, token string) error {
+	tokenEmail, expiresAt, err := c.parseToken(token)
+	if err != nil {
+		return err
+	}
+	if tokenEmail != email {
+		logger.Error("Confirmation token was issued for a different email")
+		return ErrInvalidToken
+	}
+	if !c.clock.Now().Before(expiresAt) {
+		return ErrTokenExpired
+	}
+
+	_, err = c.store.GetConfirmationToken(hashToken(token))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		logger.Error("Confirmation token has already been used or replaced")
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.store.ConfirmUserEmail(email)
+	if err != nil {
+		return err
+	}
+	logger.Info("Email has been confirmed: " + email)
+
+	// Confirming the email activates a pending account. Accounts in any
+	// other status keep it.
+	activate := models.StatusChange{
+		From:   models.AccountStatusPending,
+		To:     models.AccountStatusActive,
+		Actor:  "email-confirmation",
+		Reason: "Email confirmed.",
+	}
+	_, err = c.store.ChangeAccountStatus(email, activate)
+	if err != nil && !errors.Is(err, db.ErrStatusChanged) {
+		return err
+	}
+	return c.store.DeleteConfirmationTokens(email)
+}
+
+// RunCleanup removes expired tokens every interval. It never returns.
+func (c *Confirmer) RunCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := c.store.DeleteExpiredConfirmationTokens(c.clock.Now())
+		if err != nil {
+			continue
+		}
+		if deleted > 0 {
+			logger.Info("Deleted " + strconv.FormatInt(deleted, 10) + " expired confirmation tokens")
+		}
+	}
+}
+
+func (c *Confirmer) newToken(email string) (string, time.Time, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := c.clock.Now().Add(c.ttl)
+
+	payload := strings.Join([]string{email, strconv.FormatInt(expiresAt.Unix(), 10), hex.EncodeToString(nonce)}, "\n")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + sign(c.secret, encoded), expiresAt, nil
+}
+
+func (c *Confirmer) parseToken(token string) (string, time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(sign(c.secret, parts[0]))) {
+		logger.Error("Confirmation token signature does not match")
+		return "", time.Time{}, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 3 {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	expiry, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	return fields[0], time.Unix(expiry, 0), nil
+}
+
+func (c *Confirmer) messageBody(email string, token string, expiresAt time.Time) string {
+	link := c.url + "?" + url.Values{"email": {email}, "token": {token}}.Encode()
+	return "Welcome! Please confirm your email address by opening the link below.\n\n" +
+		link + "\n\n" +
+		"The link expires at " + expiresAt.UTC().Format(time.RFC1123) + ".\n"
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"dbutil/src/config"
+	"dbutil/src/mail"
+	"dbutil/src/models"
+	"dbutil/src/testutil"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const confirmationURL = "http://localhost:3000/confirm-email"
+
+// newTestConfirmer returns a Confirmer of tokens that last an hour, for a
+// world in which testutil.Email has registered, on the clock of the world.
+func newTestConfirmer(t *testing.T) (*Confirmer, *testutil.World, *mail.MemoryMailer) {
+	t.Helper()
+	w := testutil.NewWorld(t, config.Configuration{})
+	w.Register(t, testutil.Email, "0.00")
+	mailer := mail.NewMemoryMailer()
+	confirmer := NewConfirmer(w.Store, mailer, config.EmailConfirmationConfig{Secret: "secret", TTLMinutes: 60, URL: confirmationURL})
+	confirmer.SetClock(w.Clock)
+	return confirmer, w, mailer
+}
+
+func sendConfirmation(t *testing.T, confirmer *Confirmer, mailer *mail.MemoryMailer) string {
+	t.Helper()
+	err := confirmer.SendConfirmation(testutil.Email)
+	if err != nil {
+		t.Fatalf("SendConfirmation: %v", err)
+	}
+	return mailedToken(t, mailer)
+}
+
+// mailedToken reads the token out of the link in the last message mailer
+// sent to testutil.Email.
+func mailedToken(t *testing.T, mailer *mail.MemoryMailer) string {
+	t.Helper()
+	messages := mailer.Messages()
+	if len(messages) == 0 {
+		t.Fatal("no message was sent")
+	}
+	message := messages[len(messages)-1]
+	if message.To != testutil.Email {
+		t.Fatalf("message went to %s, want %s", message.To, testutil.Email)
+	}
+	for _, line := range strings.Split(message.Body, "\n") {
+		if !strings.HasPrefix(line, confirmationURL+"?") {
+			continue
+		}
+		link, err := url.Parse(line)
+		if err != nil {
+			t.Fatalf("link %q: %v", line, err)
+		}
+		if link.Query().Get("email") != testutil.Email {
+			t.Fatalf("link %q is for another email", line)
+		}
+		return link.Query().Get("token")
+	}
+	t.Fatalf("message has no confirmation link:\n%s", message.Body)
+	return ""
+}
+
+func TestConfirmActivatesPendingAccount(t *testing.T) {
+	confirmer, w, mailer := newTestConfirmer(t)
+	token := sendConfirmation(t, confirmer, mailer)
+
+	err := confirmer.Confirm(testutil.Email, token)
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	user, err := w.Store.GetUserData(testutil.Email)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	if !user.EmailConfimed || user.AccountStatus != models.AccountStatusActive {
+		t.Fatalf("user is confirmed %v with status %s, want confirmed and active", user.EmailConfimed, user.AccountStatus)
+	}
+	err = confirmer.ResendConfirmation(testutil.Email)
+	if !errors.Is(err, ErrAlreadyConfirmed) {
+		t.Fatalf("ResendConfirmation returned %v, want ErrAlreadyConfirmed", err)
+	}
+}
+
+func TestConfirmKeepsStatusOfSuspendedAccount(t *testing.T) {
+	confirmer, w, mailer := newTestConfirmer(t)
+	w.SetStatus(t, testutil.Email, models.AccountStatusSuspended)
+	token := sendConfirmation(t, confirmer, mailer)
+
+	err := confirmer.Confirm(testutil.Email, token)
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	user, err := w.Store.GetUserData(testutil.Email)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	if !user.EmailConfimed || user.AccountStatus != models.AccountStatusSuspended {
+		t.Fatalf("user is confirmed %v with status %s, want confirmed and still suspended", user.EmailConfimed, user.AccountStatus)
+	}
+}
+
+func TestTokenWorksOnce(t *testing.T) {
+	confirmer, _, mailer := newTestConfirmer(t)
+	token := sendConfirmation(t, confirmer, mailer)
+	err := confirmer.Confirm(testutil.Email, token)
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	err = confirmer.Confirm(testutil.Email, token)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("second Confirm returned %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestResendReplacesToken(t *testing.T) {
+	confirmer, _, mailer := newTestConfirmer(t)
+	first := sendConfirmation(t, confirmer, mailer)
+	err := confirmer.ResendConfirmation(testutil.Email)
+	if err != nil {
+		t.Fatalf("ResendConfirmation: %v", err)
+	}
+	second := mailedToken(t, mailer)
+
+	err = confirmer.Confirm(testutil.Email, first)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Confirm with the replaced token returned %v, want ErrInvalidToken", err)
+	}
+	err = confirmer.Confirm(testutil.Email, second)
+	if err != nil {
+		t.Fatalf("Confirm with the new token: %v", err)
+	}
+}
+
+func TestTokenIsBoundToEmailAndSignature(t *testing.T) {
+	confirmer, w, mailer := newTestConfirmer(t)
+	w.Register(t, "other@example.com", "0.00")
+	token := sendConfirmation(t, confirmer, mailer)
+
+	err := confirmer.Confirm("other@example.com", token)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Confirm for another email returned %v, want ErrInvalidToken", err)
+	}
+	err = confirmer.Confirm(testutil.Email, token+"x")
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Confirm with a tampered token returned %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestTokenExpiresOnTheClock(t *testing.T) {
+	confirmer, w, mailer := newTestConfirmer(t)
+	token := sendConfirmation(t, confirmer, mailer)
+
+	w.Clock.Advance(time.Hour)
+	err := confirmer.Confirm(testutil.Email, token)
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("Confirm after an hour returned %v, want ErrTokenExpired", err)
+	}
+	deleted, err := w.Store.DeleteExpiredConfirmationTokens(w.Clock.Now())
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteExpiredConfirmationTokens returned %d, %v; want the token deleted", deleted, err)
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAccessToken is returned for access tokens that are malformed,
+// carry a bad signature or have expired.
+var ErrInvalidAccessToken = errors.New("Access token is invalid or has expired.")
+
+// jwtHeader is the only header this service issues and accepts. Pinning it
+// rules out "alg": "none" and algorithm confusion attacks.
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+// Claims are the registered JWT claims carried by an access token. The
+// subject is the email of the user.
+type Claims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+func sign(secret []byte, data string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeJWT(secret []byte, claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(jwtHeader)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(secret, unsigned), nil
+}
+
+func decodeJWT(secret []byte, token string, now time.Time) (Claims, error) {
+	claims := Claims{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidAccessToken
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || string(header) != jwtHeader {
+		return claims, ErrInvalidAccessToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(secret, parts[0]+"."+parts[1]))) {
+		return claims, ErrInvalidAccessToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrInvalidAccessToken
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.Subject == "" {
+		return claims, ErrInvalidAccessToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var jwtSecret = []byte("secret")
+
+func TestJWTRoundTrip(t *testing.T) {
+	now := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	claims := Claims{Subject: "trader@example.com", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix(), ID: "1"}
+	token, err := encodeJWT(jwtSecret, claims)
+	if err != nil {
+		t.Fatalf("encodeJWT: %v", err)
+	}
+
+	decoded, err := decodeJWT(jwtSecret, token, now)
+	if err != nil || decoded != claims {
+		t.Fatalf("decodeJWT returned %+v, %v; want %+v", decoded, err, claims)
+	}
+	_, err = decodeJWT(jwtSecret, token, now.Add(time.Minute))
+	if !errors.Is(err, ErrInvalidAccessToken) {
+		t.Fatalf("decodeJWT at expiry returned %v, want ErrInvalidAccessToken", err)
+	}
+}
+
+func TestJWTRejectsForgeries(t *testing.T) {
+	now := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	token, err := encodeJWT(jwtSecret, Claims{Subject: "trader@example.com", ExpiresAt: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("encodeJWT: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	encode := base64.RawURLEncoding.EncodeToString
+
+	forgeries := map[string]string{
+		"other secret":   mustEncode(t, []byte("other"), Claims{Subject: "trader@example.com", ExpiresAt: now.Add(time.Minute).Unix()}),
+		"changed claims": parts[0] + "." + encode([]byte(`{"sub":"admin@example.com","exp":9999999999}`)) + "." + parts[2],
+		"alg none":       encode([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".",
+		"no signature":   parts[0] + "." + parts[1],
+		"no subject":     mustEncode(t, jwtSecret, Claims{ExpiresAt: now.Add(time.Minute).Unix()}),
+		"garbage":        "not.a.token",
+	}
+	for name, forged := range forgeries {
+		if _, err := decodeJWT(jwtSecret, forged, now); !errors.Is(err, ErrInvalidAccessToken) {
+			t.Errorf("%s: decodeJWT returned %v, want ErrInvalidAccessToken", name, err)
+		}
+	}
+}
+
+func mustEncode(t *testing.T, secret []byte, claims Claims) string {
+	t.Helper()
+	token, err := encodeJWT(secret, claims)
+	if err != nil {
+		t.Fatalf("encodeJWT: %v", err)
+	}
+	return token
+}
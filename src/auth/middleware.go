@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type contextKey int
+
+const callerKey contextKey = iota
+
+// CallerEmail returns the email of the signed in user bound to ctx by
+// Middleware.
+func CallerEmail(ctx context.Context) (string, bool) {
+	email, ok := ctx.Value(callerKey).(string)
+	return email, ok
+}
+
+// Middleware requires a valid "Authorization: Bearer" access token and binds
+// the email it was issued to into the request context. Routes with an
+// {email} variable may only be used on the caller's own account.
+func Middleware(sessions *Sessions) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, "Bearer ") {
+				rw.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(rw, "Access token is missing.", http.StatusUnauthorized)
+				return
+			}
+
+			email, err := sessions.Verify(strings.TrimPrefix(header, "Bearer "))
+			if err != nil {
+				rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(rw, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			if target, ok := mux.Vars(r)["email"]; ok && target != email {
+				logger.Error("User " + email + " attempted to act on the account of " + target)
+				http.Error(rw, "Not allowed to act on another account.", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), callerKey, email)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireAdmin lets only the users listed in emails through. It runs after
+// Middleware, which binds the caller.
+func RequireAdmin(emails []string) mux.MiddlewareFunc {
+	admins := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		admins[email] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			email, ok := CallerEmail(r.Context())
+			if !ok || !admins[email] {
+				logger.Error("User " + email + " attempted to use " + r.URL.Path)
+				http.Error(rw, "Not allowed to use administrative routes.", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto/rand"
+	"dbutil/src/config"
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidRefreshToken is returned for refresh tokens that are unknown,
+// expired, revoked or already used.
+var ErrInvalidRefreshToken = errors.New("Refresh token is invalid or has expired.")
+
+// TokenPair is handed to a client when it signs in or refreshes its session.
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Sessions issues short lived access tokens (HS256 JWTs) together with
+// rotating refresh tokens that are kept server side.
+//
+// Every refresh token can be used exactly once. Using it revokes it and
+// returns a new pair in the same family; presenting a token that was already
+// used is treated as theft and revokes the whole family.
+type Sessions struct {
+	store      db.Store
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	// clock dates the tokens and decides when they expire.
+	clock models.Clock
+}
+
+func NewSessions(store db.Store, authConfig config.AuthConfig) *Sessions {
+	secret := []byte(authConfig.TokenSecret)
+	if len(secret) == 0 {
+		logger.Error("No token secret configured, sessions will not survive a restart")
+		secret = make([]byte, 32)
+		_, _ = rand.Read(secret)
+	}
+	accessTTL := time.Duration(authConfig.AccessTokenTTLMinutes) * time.Minute
+	if accessTTL <= 0 {
+		accessTTL = 15 * time.Minute
+	}
+	refreshTTL := time.Duration(authConfig.RefreshTokenTTLHours) * time.Hour
+	if refreshTTL <= 0 {
+		refreshTTL = 30 * 24 * time.Hour
+	}
+	return &Sessions{
+		store:      store,
+		secret:     secret,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		clock:      models.SystemClock{},
+	}
+}
+
+// SetClock replaces the clock, which is the system clock by default.
+func (s *Sessions) SetClock(clock models.Clock) {
+	s.clock = clock
+}
+
+// Issue starts a new session for email.
+func (s *Sessions) Issue(email string) (TokenPair, error) {
+	return s.issue(email, primitive.NewObjectID().Hex())
+}
+
+// Refresh exchanges a refresh token for a new token pair.
+func (s *Sessions) Refresh(refreshToken string) (TokenPair, error) {
+	tokenHash := hashToken(refreshToken)
+	stored, err := s.store.GetRefreshToken(tokenHash)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !s.clock.Now().Before(stored.ExpiresAt) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	active, err := s.store.RevokeRefreshToken(tokenHash)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !active {
+		logger.Error("Refresh token was reused, revoking the session of " + stored.Email)
+		err = s.store.RevokeRefreshTokenFamily(stored.FamilyID)
+		if err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+	return s.issue(stored.Email, stored.FamilyID)
+}
+
+// Revoke ends the session a refresh token belongs to.
+func (s *Sessions) Revoke(refreshToken string) error {
+	stored, err := s.store.GetRefreshToken(hashToken(refreshToken))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	return s.store.RevokeRefreshTokenFamily(stored.FamilyID)
+}
+
+// Verify checks an access token and returns the email it was issued to.
+func (s *Sessions) Verify(accessToken string) (string, error) {
+	claims, err := decodeJWT(s.secret, accessToken, s.clock.Now())
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+func (s *Sessions) issue(email string, familyID string) (TokenPair, error) {
+	now := s.clock.Now()
+	accessToken, err := encodeJWT(s.secret, Claims{
+		Subject:   email,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.accessTTL).Unix(),
+		ID:        primitive.NewObjectID().Hex(),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	raw := make([]byte, 32)
+	_, err = rand.Read(raw)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(raw)
+
+	err = s.store.SaveRefreshToken(models.RefreshToken{
+		TokenHash: hashToken(refreshToken),
+		Email:     email,
+		FamilyID:  familyID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.refreshTTL),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL / time.Second),
+		RefreshToken: refreshToken,
+	}, nil
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"dbutil/src/config"
+	"dbutil/src/testutil"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestSessions returns Sessions with access tokens that last 15 minutes
+// and refresh tokens that last a day, on the clock of a new world.
+func newTestSessions(t *testing.T) (*Sessions, *testutil.World) {
+	t.Helper()
+	w := testutil.NewWorld(t, config.Configuration{})
+	sessions := NewSessions(w.Store, config.AuthConfig{TokenSecret: "secret", AccessTokenTTLMinutes: 15, RefreshTokenTTLHours: 24})
+	sessions.SetClock(w.Clock)
+	return sessions, w
+}
+
+func issue(t *testing.T, sessions *Sessions) TokenPair {
+	t.Helper()
+	pair, err := sessions.Issue(testutil.Email)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	return pair
+}
+
+func TestAccessTokenExpiresOnTheClock(t *testing.T) {
+	sessions, w := newTestSessions(t)
+	pair := issue(t, sessions)
+	if pair.TokenType != "Bearer" || pair.ExpiresIn != 15*60 {
+		t.Fatalf("pair is %+v, want a bearer token for 900 seconds", pair)
+	}
+
+	email, err := sessions.Verify(pair.AccessToken)
+	if err != nil || email != testutil.Email {
+		t.Fatalf("Verify returned %q, %v; want %s", email, err, testutil.Email)
+	}
+	w.Clock.Advance(15 * time.Minute)
+	_, err = sessions.Verify(pair.AccessToken)
+	if !errors.Is(err, ErrInvalidAccessToken) {
+		t.Fatalf("Verify after 15 minutes returned %v, want ErrInvalidAccessToken", err)
+	}
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	sessions, _ := newTestSessions(t)
+	first := issue(t, sessions)
+
+	second, err := sessions.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("Refresh returned the tokens it was given")
+	}
+	if email, err := sessions.Verify(second.AccessToken); err != nil || email != testutil.Email {
+		t.Fatalf("Verify of the refreshed token returned %q, %v", email, err)
+	}
+	third, err := sessions.Refresh(second.RefreshToken)
+	if err != nil || third.RefreshToken == "" {
+		t.Fatalf("Refresh of the rotated token returned %+v, %v", third, err)
+	}
+}
+
+func TestReusedRefreshTokenRevokesFamily(t *testing.T) {
+	sessions, _ := newTestSessions(t)
+	first := issue(t, sessions)
+	second, err := sessions.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	_, err = sessions.Refresh(first.RefreshToken)
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("reusing a refresh token returned %v, want ErrInvalidRefreshToken", err)
+	}
+	_, err = sessions.Refresh(second.RefreshToken)
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("refreshing after the reuse returned %v, want the family revoked", err)
+	}
+}
+
+func TestRefreshTokenExpiresOnTheClock(t *testing.T) {
+	sessions, w := newTestSessions(t)
+	pair := issue(t, sessions)
+
+	w.Clock.Advance(24 * time.Hour)
+	_, err := sessions.Refresh(pair.RefreshToken)
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Refresh after a day returned %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestRevokeEndsSessionOnly(t *testing.T) {
+	sessions, _ := newTestSessions(t)
+	revoked := issue(t, sessions)
+	other := issue(t, sessions)
+
+	err := sessions.Revoke(revoked.RefreshToken)
+	if err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	_, err = sessions.Refresh(revoked.RefreshToken)
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Refresh of a revoked session returned %v, want ErrInvalidRefreshToken", err)
+	}
+	_, err = sessions.Refresh(other.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh of another session: %v", err)
+	}
+	err = sessions.Revoke("unknown")
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Revoke of an unknown token returned %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func TestMiddlewareBindsCallerToOwnAccount(t *testing.T) {
+	sessions, _ := newTestSessions(t)
+	pair := issue(t, sessions)
+	router := mux.NewRouter()
+	router.Use(Middleware(sessions))
+	router.HandleFunc("/user/{email}", func(rw http.ResponseWriter, r *http.Request) {
+		email, _ := CallerEmail(r.Context())
+		_, _ = rw.Write([]byte(email))
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		status int
+	}{
+		{"own account", "/user/" + testutil.Email, "Bearer " + pair.AccessToken, http.StatusOK},
+		{"other account", "/user/other@example.com", "Bearer " + pair.AccessToken, http.StatusForbidden},
+		{"no token", "/user/" + testutil.Email, "", http.StatusUnauthorized},
+		{"bad token", "/user/" + testutil.Email, "Bearer " + pair.AccessToken + "x", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.header != "" {
+			request.Header.Set("Authorization", test.header)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+		}
+		if test.status == http.StatusOK && recorder.Body.String() != testutil.Email {
+			t.Errorf("%s: caller is %q, want %s", test.name, recorder.Body.String(), testutil.Email)
+		}
+	}
+}
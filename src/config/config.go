@@ -11,6 +11,136 @@ type Configuration struct {
 	Debug            bool   `json:"debug"`
 	Environment      string `json:"environment"`
 	ConnectionString string `json:"connectionString"`
+	Store            string `json:"store"`
+	// LegacyCredentialRoutes keeps the old routes that take the password as a
+	// path variable. They log a deprecation warning on every call.
+	LegacyCredentialRoutes bool `json:"legacyCredentialRoutes"`
+	// LotMatching is the default order in which sells consume lots: "FIFO",
+	// "LIFO" or "HIGHEST_COST". Requests may ask for another policy.
+	LotMatching string `json:"lotMatching"`
+
+	Auth              AuthConfig              `json:"auth"`
+	EmailConfirmation EmailConfirmationConfig `json:"emailConfirmation"`
+	Mail              MailConfig              `json:"mail"`
+	Reconciliation    ReconciliationConfig    `json:"reconciliation"`
+	Deposits          DepositConfig           `json:"deposits"`
+	Idempotency       IdempotencyConfig       `json:"idempotency"`
+	Quotes            QuotesConfig            `json:"quotes"`
+	Orders            OrdersConfig            `json:"orders"`
+	Fees              FeesConfig              `json:"fees"`
+	Admin             AdminConfig             `json:"admin"`
+	Watchlists        WatchlistsConfig        `json:"watchlists"`
+	Alerts            AlertsConfig            `json:"alerts"`
+}
+
+type AuthConfig struct {
+	// TokenSecret is the HMAC key used to sign access tokens.
+	TokenSecret           string `json:"tokenSecret"`
+	AccessTokenTTLMinutes int    `json:"accessTokenTtlMinutes"`
+	RefreshTokenTTLHours  int    `json:"refreshTokenTtlHours"`
+}
+
+type EmailConfirmationConfig struct {
+	// Secret is the HMAC key used to sign confirmation tokens.
+	Secret     string `json:"secret"`
+	TTLMinutes int    `json:"ttlMinutes"`
+	// URL is the page the confirmation link in the email points to. The email
+	// and token are appended as query parameters.
+	URL                    string `json:"url"`
+	CleanupIntervalMinutes int    `json:"cleanupIntervalMinutes"`
+}
+
+type MailConfig struct {
+	// Type selects the Mailer implementation: "smtp", "file" or "memory".
+	Type      string `json:"type"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	From      string `json:"from"`
+	Directory string `json:"directory"`
+}
+
+type ReconciliationConfig struct {
+	// IntervalMinutes is how often the server reconciles every account. Zero
+	// turns the background job off; "dbutil reconcile" still works.
+	IntervalMinutes int `json:"intervalMinutes"`
+	// FreezeAccounts sets the status of accounts that fail reconciliation to
+	// "frozen".
+	FreezeAccounts bool `json:"freezeAccounts"`
+}
+
+// DepositConfig limits how much money can be added to a balance. Amounts are
+// decimal strings and apply to the currency of the deposit; an empty amount
+// means no limit.
+type DepositConfig struct {
+	MaxAmount string `json:"maxAmount"`
+	// DailyLimit caps the deposits of a user over any 24 hours.
+	DailyLimit string `json:"dailyLimit"`
+}
+
+type IdempotencyConfig struct {
+	// TTLHours is how long the response to a request with an Idempotency-Key
+	// is kept for replay.
+	TTLHours int `json:"ttlHours"`
+}
+
+type QuotesConfig struct {
+	// Type selects the quotes Provider: "static" serves Prices, "csv" serves
+	// the prices in File.
+	Type     string            `json:"type"`
+	File     string            `json:"file"`
+	Currency string            `json:"currency"`
+	Prices   map[string]string `json:"prices"`
+	// MaxSlippageBps is how far, in basis points, the market price may move
+	// against the price a client expects before a buy or sell is rejected.
+	MaxSlippageBps int64 `json:"maxSlippageBps"`
+}
+
+type OrdersConfig struct {
+	// MatchIntervalSeconds is how often open orders are evaluated against
+	// the quotes.
+	MatchIntervalSeconds int `json:"matchIntervalSeconds"`
+}
+
+// FeesConfig is the commission charged on every buy and sell: Flat plus
+// PercentBps basis points of the value traded, at least Minimum. Amounts are
+// decimal strings in the currency of the trade.
+type FeesConfig struct {
+	Flat       string `json:"flat"`
+	PercentBps int64  `json:"percentBps"`
+	Minimum    string `json:"minimum"`
+	// Tiers lower PercentBps once the value a user traded in the current
+	// calendar month reaches MonthlyVolume.
+	Tiers []FeeTierConfig `json:"tiers"`
+}
+
+type FeeTierConfig struct {
+	MonthlyVolume string `json:"monthlyVolume"`
+	PercentBps    int64  `json:"percentBps"`
+}
+
+// WatchlistsConfig limits how many watchlists a user may keep and how many
+// symbols each may hold. Zero means no limit.
+type WatchlistsConfig struct {
+	MaxLists   int `json:"maxLists"`
+	MaxSymbols int `json:"maxSymbols"`
+}
+
+type AlertsConfig struct {
+	// IntervalSeconds is how often active alerts are evaluated against the
+	// quotes.
+	IntervalSeconds int `json:"intervalSeconds"`
+	// Notifier selects how triggered alerts are delivered: "log", "webhook",
+	// which posts them to WebhookURL, or "memory".
+	Notifier   string `json:"notifier"`
+	WebhookURL string `json:"webhookURL"`
+}
+
+type AdminConfig struct {
+	// Emails are the users allowed to use the /admin routes, such as applying
+	// corporate actions.
+	Emails []string `json:"emails"`
 }
 
 func GetConfig() Configuration {
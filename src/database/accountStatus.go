@@ -0,0 +1,185 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// restricted returns the account statuses that may not make balance changes
+// of changeType: blocked accounts may not trade or deposit, and suspended or
+// frozen ones may not withdraw either. It is nil for changes every account
+// may make.
+func restricted(changeType models.LedgerEntryType) []models.AccountStatus {
+	switch changeType {
+	case models.LedgerBuy, models.LedgerSell, models.LedgerDeposit:
+		return models.BlockedAccountStatuses
+	case models.LedgerWithdrawal:
+		return models.WithdrawalBlockedStatuses
+	}
+	return nil
+}
+
+// newStatusChange checks that an account whose stored status is current may
+// change as change asks, and fills in the change. When change.From is set,
+// the account must still have that status. The returned bool is false when
+// the account already has the requested status, which is not a change.
+func newStatusChange(userID string, email string, current models.AccountStatus, change models.StatusChange, now time.Time) (models.StatusChange, bool, error) {
+	if !change.To.Valid() {
+		return models.StatusChange{}, false, ErrInvalidAccountStatus
+	}
+	from := current.Effective()
+	if change.From != "" && change.From != from {
+		return models.StatusChange{}, false, ErrStatusChanged
+	}
+	change.From = from
+	change.Email = email
+	change.UserID = userID
+	if from == change.To {
+		return change, false, nil
+	}
+	if !from.CanBecome(change.To) {
+		return models.StatusChange{}, false, fmt.Errorf("%w from %s to %s.", ErrStatusTransition, from, change.To)
+	}
+	change.ID = primitive.NewObjectID()
+	change.Reason = strings.TrimSpace(change.Reason)
+	change.ChangedAt = now
+	return change, true, nil
+}
+
+// ChangeAccountStatus moves an account to change.To if the transition table
+// allows it, and records the change with its actor and reason in the
+// StatusHistory collection, in one transaction. Setting the status an account
+// already has changes nothing and is not recorded.
+func (s *MongoStore) ChangeAccountStatus(email string, change models.StatusChange) (models.StatusChange, error) {
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		users := getDBCollection("Users", s.client)
+		account := struct {
+			ID     primitive.ObjectID   `bson:"_id"`
+			Status models.AccountStatus `bson:"accountStatus"`
+		}{}
+		opts := options.FindOne().SetProjection(bson.D{{Key: "accountStatus", Value: 1}})
+		err := users.FindOne(ctx, bson.M{"email": bson.M{"$eq": email}}, opts).Decode(&account)
+		if err != nil {
+			return err
+		}
+
+		var changed bool
+		change, changed, err = newStatusChange(account.ID.Hex(), email, account.Status, change, s.clock.Now())
+		if err != nil || !changed {
+			return err
+		}
+
+		// The status is only replaced while it is still the one read above.
+		stored := interface{}(account.Status)
+		if account.Status == "" {
+			stored = bson.M{"$in": bson.A{"", nil}}
+		}
+		filter := bson.M{"_id": account.ID, "accountStatus": stored}
+		result, err := users.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"accountStatus": change.To}})
+		if err != nil {
+			logger.Error("Unable to update the status of user " + err.Error())
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrStatusChanged
+		}
+		undo.add(func(ctx context.Context) error {
+			_, err := users.UpdateOne(ctx, bson.M{"_id": account.ID}, bson.M{"$set": bson.M{"accountStatus": account.Status}})
+			return err
+		})
+
+		_, err = getDBCollection("StatusHistory", s.client).InsertOne(ctx, change)
+		if err != nil {
+			logger.Error("Unable to record status change: " + err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return models.StatusChange{}, err
+	}
+	return change, nil
+}
+
+// GetStatusHistory returns the status changes of an account, oldest first.
+func (s *MongoStore) GetStatusHistory(email string) ([]models.StatusChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := getDBCollection("StatusHistory", s.client).Find(ctx, bson.M{"userID": userID}, opts)
+	if err != nil {
+		logger.Error("Unable to get status history: " + err.Error())
+		return nil, err
+	}
+	history := []models.StatusChange{}
+	err = cursor.All(ctx, &history)
+	if err != nil {
+		logger.Error("Unable to decode status history: " + err.Error())
+		return nil, err
+	}
+	return history, nil
+}
+
+// checkTrading refuses users whose account status blocks trading.
+func (s *MongoStore) checkTrading(ctx context.Context, email string) error {
+	account := models.User{}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "accountStatus", Value: 1}})
+	err := getDBCollection("Users", s.client).FindOne(ctx, bson.M{"email": bson.M{"$eq": email}}, opts).Decode(&account)
+	if err != nil {
+		return err
+	}
+	if account.AccountStatus.Blocked() {
+		return ErrAccountBlocked
+	}
+	return nil
+}
+
+func (s *MemoryStore) ChangeAccountStatus(email string, change models.StatusChange) (models.StatusChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.StatusChange{}, mongo.ErrNoDocuments
+	}
+	change, changed, err := newStatusChange(entry.id.Hex(), email, entry.user.AccountStatus, change, s.clock.Now())
+	if err != nil {
+		return models.StatusChange{}, err
+	}
+	if changed {
+		entry.user.AccountStatus = change.To
+		s.statusHistory = append(s.statusHistory, change)
+	}
+	return change, nil
+}
+
+func (s *MemoryStore) GetStatusHistory(email string) ([]models.StatusChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	history := []models.StatusChange{}
+	for _, change := range s.statusHistory {
+		if change.UserID == entry.id.Hex() {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,306 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newAlert validates an alert that is about to be registered and fills in its
+// fields. Fields its kind does not use are dropped.
+func newAlert(email string, alert models.Alert, now time.Time) (models.Alert, error) {
+	if !alert.Kind.Valid() {
+		return alert, ErrInvalidAlertKind
+	}
+	if alert.Kind.WatchesPortfolio() {
+		if alert.Bps <= 0 || alert.Bps > bpsPerUnit {
+			return alert, ErrInvalidAlertBps
+		}
+		alert.Currency = models.NewMoney(0, alert.Currency).Currency
+		alert.Symbol = ""
+		alert.Price = nil
+	} else {
+		alert.Symbol = strings.ToUpper(strings.TrimSpace(alert.Symbol))
+		if alert.Symbol == "" || alert.Price == nil || !alert.Price.IsPositive() {
+			return alert, ErrMissingAlertPrice
+		}
+		price := models.NewMoney(alert.Price.Amount, alert.Price.Currency)
+		alert.Price = &price
+		alert.Bps = 0
+		alert.Currency = ""
+	}
+
+	alert.ID = primitive.NewObjectID()
+	alert.Email = email
+	alert.Status = models.AlertActive
+	alert.Baseline = nil
+	alert.BaselineAt = nil
+	alert.TriggeredValue = nil
+	alert.CreatedAt = now
+	alert.TriggeredAt = nil
+	alert.CancelledAt = nil
+	return alert, nil
+}
+
+func (s *MongoStore) CreateAlert(email string, alert models.Alert) (models.Alert, error) {
+	alert, err := newAlert(email, alert, s.clock.Now())
+	if err != nil {
+		return models.Alert{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	alert.UserID, err = s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return models.Alert{}, err
+	}
+	_, err = getDBCollection("Alerts", s.client).InsertOne(ctx, alert)
+	if err != nil {
+		logger.Error("Unable to save alert: " + err.Error())
+		return models.Alert{}, err
+	}
+	return alert, nil
+}
+
+// GetAlerts returns the alerts of a user, oldest first, optionally only those
+// with status.
+func (s *MongoStore) GetAlerts(email string, status models.AlertStatus) ([]models.Alert, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"userID": userID}
+	if status != "" {
+		filter["status"] = status
+	}
+	return s.findAlerts(ctx, filter)
+}
+
+func (s *MongoStore) CancelAlert(email string, alertID string) (models.Alert, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(alertID)
+	if err != nil {
+		return models.Alert{}, mongo.ErrNoDocuments
+	}
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return models.Alert{}, err
+	}
+	update := bson.M{"status": models.AlertCancelled, "cancelledAt": s.clock.Now()}
+	return s.resolveAlert(ctx, bson.M{"_id": id, "userID": userID}, update)
+}
+
+// GetActiveAlerts returns the active alerts of every user, oldest first.
+func (s *MongoStore) GetActiveAlerts() ([]models.Alert, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.findAlerts(ctx, bson.M{"status": models.AlertActive})
+}
+
+// SetAlertBaseline records the portfolio value a portfolio alert compares
+// with for the rest of the day of at.
+func (s *MongoStore) SetAlertBaseline(alertID string, baseline models.Money, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(alertID)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+	filter := bson.M{"_id": id, "status": models.AlertActive}
+	result, err := getDBCollection("Alerts", s.client).UpdateOne(ctx, filter, bson.M{"$set": bson.M{"baseline": baseline, "baselineAt": at}})
+	if err != nil {
+		logger.Error("Unable to update alert: " + err.Error())
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAlertNotActive
+	}
+	return nil
+}
+
+// TriggerAlert marks an active alert triggered by value. The update matches
+// active alerts only, so an alert triggers exactly once even when several
+// workers evaluate it.
+func (s *MongoStore) TriggerAlert(alertID string, value models.Money) (models.Alert, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(alertID)
+	if err != nil {
+		return models.Alert{}, mongo.ErrNoDocuments
+	}
+	update := bson.M{"status": models.AlertTriggered, "triggeredValue": value, "triggeredAt": s.clock.Now()}
+	return s.resolveAlert(ctx, bson.M{"_id": id}, update)
+}
+
+// resolveAlert applies update to the active alert matching filter and returns
+// it afterwards.
+func (s *MongoStore) resolveAlert(ctx context.Context, filter bson.M, update bson.M) (models.Alert, error) {
+	collection := getDBCollection("Alerts", s.client)
+	active := bson.M{"status": models.AlertActive}
+	for key, value := range filter {
+		active[key] = value
+	}
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	alert := models.Alert{}
+	err := collection.FindOneAndUpdate(ctx, active, bson.M{"$set": update}, after).Decode(&alert)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return models.Alert{}, err
+		}
+		if count > 0 {
+			return models.Alert{}, ErrAlertNotActive
+		}
+		return models.Alert{}, mongo.ErrNoDocuments
+	}
+	if err != nil {
+		logger.Error("Unable to update alert: " + err.Error())
+		return models.Alert{}, err
+	}
+	return alert, nil
+}
+
+func (s *MongoStore) findAlerts(ctx context.Context, filter bson.M) ([]models.Alert, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := getDBCollection("Alerts", s.client).Find(ctx, filter, opts)
+	if err != nil {
+		logger.Error("Unable to get alerts: " + err.Error())
+		return nil, err
+	}
+	alerts := []models.Alert{}
+	err = cursor.All(ctx, &alerts)
+	if err != nil {
+		logger.Error("Unable to decode alerts: " + err.Error())
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (s *MemoryStore) CreateAlert(email string, alert models.Alert) (models.Alert, error) {
+	alert, err := newAlert(email, alert, s.clock.Now())
+	if err != nil {
+		return models.Alert{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.Alert{}, mongo.ErrNoDocuments
+	}
+	alert.UserID = entry.id.Hex()
+	s.alerts = append(s.alerts, &alert)
+	return alert, nil
+}
+
+func (s *MemoryStore) GetAlerts(email string, status models.AlertStatus) ([]models.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	alerts := []models.Alert{}
+	for _, alert := range s.alerts {
+		if alert.UserID == entry.id.Hex() && (status == "" || alert.Status == status) {
+			alerts = append(alerts, *alert)
+		}
+	}
+	return alerts, nil
+}
+
+func (s *MemoryStore) CancelAlert(email string, alertID string) (models.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.Alert{}, mongo.ErrNoDocuments
+	}
+	alert, err := s.activeAlert(alertID)
+	if alert == nil || alert.UserID != entry.id.Hex() {
+		return models.Alert{}, mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return models.Alert{}, err
+	}
+	now := s.clock.Now()
+	alert.Status = models.AlertCancelled
+	alert.CancelledAt = &now
+	return *alert, nil
+}
+
+func (s *MemoryStore) GetActiveAlerts() ([]models.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts := []models.Alert{}
+	for _, alert := range s.alerts {
+		if alert.Status == models.AlertActive {
+			alerts = append(alerts, *alert)
+		}
+	}
+	return alerts, nil
+}
+
+func (s *MemoryStore) SetAlertBaseline(alertID string, baseline models.Money, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alert, err := s.activeAlert(alertID)
+	if err != nil {
+		return err
+	}
+	alert.Baseline = &baseline
+	alert.BaselineAt = &at
+	return nil
+}
+
+func (s *MemoryStore) TriggerAlert(alertID string, value models.Money) (models.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alert, err := s.activeAlert(alertID)
+	if err != nil {
+		return models.Alert{}, err
+	}
+	now := s.clock.Now()
+	alert.Status = models.AlertTriggered
+	alert.TriggeredValue = &value
+	alert.TriggeredAt = &now
+	return *alert, nil
+}
+
+// activeAlert finds an alert by id. It also returns the alert when it is no
+// longer active, together with ErrAlertNotActive. The caller holds s.mu.
+func (s *MemoryStore) activeAlert(alertID string) (*models.Alert, error) {
+	for _, alert := range s.alerts {
+		if alert.ID.Hex() != alertID {
+			continue
+		}
+		if alert.Status != models.AlertActive {
+			return alert, ErrAlertNotActive
+		}
+		return alert, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
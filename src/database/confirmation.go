@@ -0,0 +1,144 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (s *MongoStore) SaveConfirmationToken(token models.ConfirmationToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("EmailConfirmations", s.client)
+	_, err := collection.InsertOne(ctx, token)
+	if err != nil {
+		logger.Error("Unable to save confirmation token: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *MongoStore) GetConfirmationToken(tokenHash string) (models.ConfirmationToken, error) {
+	token := models.ConfirmationToken{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("EmailConfirmations", s.client)
+	filter := bson.M{"tokenHash": bson.M{"$eq": tokenHash}}
+	err := collection.FindOne(ctx, filter).Decode(&token)
+	if err != nil {
+		logger.Error("Unable to get confirmation token: " + err.Error())
+		return token, err
+	}
+	return token, nil
+}
+
+func (s *MongoStore) DeleteConfirmationTokens(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("EmailConfirmations", s.client)
+	filter := bson.M{"email": bson.M{"$eq": email}}
+	_, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		logger.Error("Unable to delete confirmation tokens: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *MongoStore) DeleteExpiredConfirmationTokens(now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("EmailConfirmations", s.client)
+	filter := bson.M{"expiresAt": bson.M{"$lte": now}}
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		logger.Error("Unable to delete expired confirmation tokens: " + err.Error())
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (s *MongoStore) ConfirmUserEmail(email string) (*mongo.UpdateResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("Users", s.client)
+	filter := bson.M{"email": bson.M{"$eq": email}}
+	update := bson.M{"$set": bson.M{"emailConfirmed": true}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logger.Error("Unable to confirm email of user " + err.Error())
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) SaveConfirmationToken(token models.ConfirmationToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.confirmations[token.TokenHash] = token
+	return nil
+}
+
+func (s *MemoryStore) GetConfirmationToken(tokenHash string) (models.ConfirmationToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.confirmations[tokenHash]
+	if !ok {
+		return token, mongo.ErrNoDocuments
+	}
+	return token, nil
+}
+
+func (s *MemoryStore) DeleteConfirmationTokens(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.confirmations {
+		if token.Email == email {
+			delete(s.confirmations, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredConfirmationTokens(now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := int64(0)
+	for hash, token := range s.confirmations {
+		if !token.ExpiresAt.After(now) {
+			delete(s.confirmations, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStore) ConfirmUserEmail(email string) (*mongo.UpdateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &mongo.UpdateResult{}
+	entry, ok := s.users[email]
+	if !ok {
+		return result, nil
+	}
+	result.MatchedCount = 1
+	if !entry.user.EmailConfimed {
+		entry.user.EmailConfimed = true
+		result.ModifiedCount = 1
+	}
+	return result, nil
+}
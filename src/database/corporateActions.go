@@ -0,0 +1,686 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// splitCancelReason is the reason given to the open orders a split cancels.
+// Their prices and quantities were in shares that no longer exist.
+const splitCancelReason = "Cancelled by a stock split."
+
+// newCorporateAction validates an action that is about to be applied and
+// clears the fields its type does not use. Split ratios are reduced, so 4:2
+// is recorded as 2:1.
+func newCorporateAction(action models.CorporateAction, now time.Time) (models.CorporateAction, error) {
+	action.ID = strings.TrimSpace(action.ID)
+	if action.ID == "" {
+		return action, ErrMissingActionID
+	}
+	action.Symbol = strings.TrimSpace(action.Symbol)
+	if action.Symbol == "" {
+		return action, ErrMissingSymbol
+	}
+
+	switch action.Type {
+	case models.CorporateSplit:
+		if action.SplitTo <= 0 || action.SplitFrom <= 0 || action.SplitTo == action.SplitFrom {
+			return action, models.ErrInvalidSplitRatio
+		}
+		divisor := gcd(action.SplitTo, action.SplitFrom)
+		action.SplitTo /= divisor
+		action.SplitFrom /= divisor
+		action.AmountPerShare = nil
+		action.RecordDate = nil
+	case models.CorporateDividend:
+		if action.AmountPerShare == nil || !action.AmountPerShare.IsPositive() {
+			return action, ErrInvalidAmount
+		}
+		amount := models.NewMoney(action.AmountPerShare.Amount, action.AmountPerShare.Currency)
+		action.AmountPerShare = &amount
+		if action.RecordDate == nil {
+			return action, ErrMissingRecordDate
+		}
+		year, month, day := action.RecordDate.UTC().Date()
+		date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		if recordCutoff(date).After(now) {
+			return action, ErrRecordDateNotPast
+		}
+		action.RecordDate = &date
+		action.SplitTo = 0
+		action.SplitFrom = 0
+	default:
+		return action, ErrInvalidActionType
+	}
+
+	action.AppliedAt = now
+	action.Lots = 0
+	action.CancelledOrders = 0
+	action.Holders = 0
+	action.Paid = nil
+	action.Skipped = nil
+	return action, nil
+}
+
+// recordCutoff is the end of a record date. Shares held then are paid.
+func recordCutoff(recordDate time.Time) time.Time {
+	return recordDate.Add(24 * time.Hour)
+}
+
+func gcd(a int, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// splitLot turns every from shares of an open lot into to shares. The cost
+// basis does not change: the new price is rounded down to a minor unit and
+// BasisAdjustment keeps what the rounding lost.
+func splitLot(lot models.Share, to int, from int) (models.Share, error) {
+	if lot.Quantity*to%from != 0 {
+		logger.Error("Lot " + lot.ShareID + " of " + strconv.Itoa(lot.Quantity) + " shares cannot be split " + strconv.Itoa(to) + ":" + strconv.Itoa(from))
+		return lot, ErrFractionalSplit
+	}
+	split := lot
+	split.Quantity = lot.Quantity * to / from
+	if split.Quantity > 0 {
+		currency := lot.PriceBaught.Currency
+		cost, err := lot.PriceBaught.Mul(int64(lot.Quantity))
+		if err != nil {
+			return lot, err
+		}
+		cost, err = cost.Add(lot.BasisAdjustment)
+		if err != nil {
+			return lot, err
+		}
+		price := cost.Amount / int64(split.Quantity)
+		split.PriceBaught = models.NewMoney(price, currency)
+		split.BasisAdjustment = models.NewMoney(cost.Amount-price*int64(split.Quantity), currency)
+	}
+
+	splitTo, splitFrom := lot.SplitTo, lot.SplitFrom
+	if splitTo == 0 || splitFrom == 0 {
+		splitTo, splitFrom = 1, 1
+	}
+	splitTo *= to
+	splitFrom *= from
+	divisor := gcd(splitTo, splitFrom)
+	split.SplitTo = splitTo / divisor
+	split.SplitFrom = splitFrom / divisor
+	return split, nil
+}
+
+// splitFields are the fields of a lot a split changes.
+func splitFields(lot models.Share) bson.M {
+	return bson.M{
+		"quantity":        lot.Quantity,
+		"priceBaught":     lot.PriceBaught,
+		"basisAdjustment": lot.BasisAdjustment,
+		"splitTo":         lot.SplitTo,
+		"splitFrom":       lot.SplitFrom,
+	}
+}
+
+// heldAt reports whether lot was owned at cutoff: bought before it and not
+// sold before it.
+func heldAt(lot models.Share, cutoff time.Time) bool {
+	if !lot.DateBaught.Before(cutoff) {
+		return false
+	}
+	return lot.SoldIndicator == "N" || lot.DateSold == nil || !lot.DateSold.Before(cutoff)
+}
+
+// dividendHoldings adds up, per user, the shares of lots held at the end of
+// the record date of a dividend. Users are sorted by id. Lots are stored in
+// the shares of today, so the splits applied since the record date, while the
+// lot was still open, are undone to count it in the shares of that date.
+func dividendHoldings(lots []models.Share, cutoff time.Time, splits []models.CorporateAction) ([]string, map[string]int, error) {
+	held := make(map[string]*big.Rat)
+	userIDs := []string{}
+	for _, lot := range lots {
+		if !heldAt(lot, cutoff) {
+			continue
+		}
+		shares := new(big.Rat).SetInt64(int64(lot.Quantity))
+		for _, split := range splits {
+			if !split.AppliedAt.After(cutoff) {
+				continue
+			}
+			if lot.SoldIndicator != "N" && lot.DateSold != nil && !split.AppliedAt.Before(*lot.DateSold) {
+				continue
+			}
+			shares.Mul(shares, big.NewRat(int64(split.SplitFrom), int64(split.SplitTo)))
+		}
+		if _, ok := held[lot.UserID]; !ok {
+			userIDs = append(userIDs, lot.UserID)
+			held[lot.UserID] = new(big.Rat)
+		}
+		held[lot.UserID].Add(held[lot.UserID], shares)
+	}
+
+	quantities := make(map[string]int, len(held))
+	for userID, shares := range held {
+		if !shares.IsInt() {
+			logger.Error("User " + userID + " held " + shares.RatString() + " shares at the record date")
+			return nil, nil, ErrRecordDateHoldings
+		}
+		quantities[userID] = int(shares.Num().Int64())
+	}
+	sort.Strings(userIDs)
+	return userIDs, quantities, nil
+}
+
+// dividendOf credits the dividend action pays on quantity shares.
+func dividendOf(action models.CorporateAction, quantity int) (models.BalanceChange, error) {
+	perShare := *action.AmountPerShare
+	amount, err := perShare.Mul(int64(quantity))
+	if err != nil {
+		return models.BalanceChange{}, err
+	}
+	return models.BalanceChange{
+		Type:        models.LedgerDividend,
+		Amount:      amount,
+		Credit:      true,
+		ReferenceID: action.ID,
+		Symbol:      action.Symbol,
+		Quantity:    quantity,
+		Price:       &perShare,
+	}, nil
+}
+
+// dividendBatchSize is how many holders one transaction of a dividend pays.
+const dividendBatchSize = 100
+
+// ApplyCorporateAction applies a split or pays a dividend to every holder of
+// its symbol, and logs it. The log is keyed by the action id, so an action
+// that was already applied is refused with ErrActionApplied. A split is
+// applied and logged in one transaction; a dividend is paid in batches, see
+// applyDividend.
+func (s *MongoStore) ApplyCorporateAction(action models.CorporateAction) (models.CorporateAction, error) {
+	action, err := newCorporateAction(action, s.clock.Now())
+	if err != nil {
+		return models.CorporateAction{}, err
+	}
+	if action.Type == models.CorporateDividend {
+		return s.applyDividend(action)
+	}
+
+	applied := models.CorporateAction{}
+	err = s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		applied = action
+		collection := getDBCollection("CorporateActions", s.client)
+		_, err := collection.InsertOne(ctx, applied)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrActionApplied
+		}
+		if err != nil {
+			logger.Error("Unable to log corporate action: " + err.Error())
+			return err
+		}
+		undo.add(func(ctx context.Context) error {
+			_, err := collection.DeleteOne(ctx, bson.M{"_id": applied.ID})
+			return err
+		})
+
+		err = s.applySplit(ctx, undo, &applied)
+		if err != nil {
+			return err
+		}
+		_, err = collection.ReplaceOne(ctx, bson.M{"_id": applied.ID}, applied)
+		if err != nil {
+			logger.Error("Unable to log corporate action: " + err.Error())
+		}
+		return err
+	})
+	if err != nil {
+		return models.CorporateAction{}, err
+	}
+	logger.Info("Applied " + string(applied.Type) + " " + applied.ID + " of " + applied.Symbol)
+	return applied, nil
+}
+
+// applyDividend logs a dividend as in progress, pays its holders in
+// transactions of dividendBatchSize, and then logs the totals. Every payment
+// is a ledger entry that references the action, so applying a dividend that
+// stopped halfway again pays only the holders that are left.
+func (s *MongoStore) applyDividend(action models.CorporateAction) (models.CorporateAction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("CorporateActions", s.client)
+	action.InProgress = true
+	_, err := collection.InsertOne(ctx, action)
+	if mongo.IsDuplicateKeyError(err) {
+		logged := models.CorporateAction{}
+		err = collection.FindOne(ctx, bson.M{"_id": action.ID}).Decode(&logged)
+		if err != nil {
+			return models.CorporateAction{}, err
+		}
+		if logged.Type != models.CorporateDividend || !logged.InProgress {
+			return models.CorporateAction{}, ErrActionApplied
+		}
+		logger.Info("Resuming dividend " + logged.ID)
+		action = logged
+	} else if err != nil {
+		logger.Error("Unable to log corporate action: " + err.Error())
+		return models.CorporateAction{}, err
+	}
+
+	userIDs, quantities, emails, err := s.dividendHolders(ctx, action)
+	if err != nil {
+		return models.CorporateAction{}, err
+	}
+	paid := models.NewMoney(0, action.AmountPerShare.Currency)
+	for start := 0; start < len(userIDs); start += dividendBatchSize {
+		end := start + dividendBatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		batch := dividendBatch{}
+		err = s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+			batch = dividendBatch{paid: models.NewMoney(0, paid.Currency)}
+			return s.payDividend(ctx, undo, action, userIDs[start:end], quantities, emails, &batch)
+		})
+		if err != nil {
+			return models.CorporateAction{}, err
+		}
+		action.Holders += batch.holders
+		action.Skipped = append(action.Skipped, batch.skipped...)
+		paid, err = paid.Add(batch.paid)
+		if err != nil {
+			return models.CorporateAction{}, err
+		}
+	}
+
+	action.Paid = &paid
+	action.InProgress = false
+	logCtx, cancelLog := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelLog()
+	_, err = collection.ReplaceOne(logCtx, bson.M{"_id": action.ID}, action)
+	if err != nil {
+		logger.Error("Unable to log corporate action: " + err.Error())
+		return models.CorporateAction{}, err
+	}
+	logger.Info("Applied " + string(action.Type) + " " + action.ID + " of " + action.Symbol)
+	return action, nil
+}
+
+// applySplit splits every open lot of the symbol of action and cancels the
+// open orders in it. No lot is changed unless all of them split into whole
+// shares.
+func (s *MongoStore) applySplit(ctx context.Context, undo *undoLog, action *models.CorporateAction) error {
+	collection := getDBCollection("Lots", s.client)
+	cursor, err := collection.Find(ctx, bson.M{"symbol": action.Symbol, "soldIndicator": "N"})
+	if err != nil {
+		logger.Error("Unable to get lots: " + err.Error())
+		return err
+	}
+	lots := []models.Share{}
+	err = cursor.All(ctx, &lots)
+	if err != nil {
+		logger.Error("Unable to decode lots: " + err.Error())
+		return err
+	}
+
+	split := make([]models.Share, len(lots))
+	for i, lot := range lots {
+		split[i], err = splitLot(lot, action.SplitTo, action.SplitFrom)
+		if err != nil {
+			return err
+		}
+	}
+	for i, lot := range lots {
+		key := bson.M{"userID": lot.UserID, "shareID": lot.ShareID}
+		filter := bson.M{"userID": lot.UserID, "shareID": lot.ShareID, "soldIndicator": "N", "quantity": lot.Quantity}
+		result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": splitFields(split[i])})
+		if err != nil {
+			logger.Error("Unable to split lot: " + err.Error())
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrCorporateActionRaced
+		}
+		previous := lot
+		undo.add(func(ctx context.Context) error {
+			_, err := collection.UpdateOne(ctx, key, bson.M{"$set": splitFields(previous)})
+			return err
+		})
+	}
+	action.Lots = len(lots)
+
+	orders, err := s.findOrders(ctx, bson.M{"symbol": action.Symbol, "status": models.OrderOpen})
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		_, err := s.closeOrder(ctx, undo, order.Email, order.ID.Hex(), models.OrderCancelled, splitCancelReason)
+		if errors.Is(err, ErrOrderNotOpen) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		action.CancelledOrders++
+	}
+	return nil
+}
+
+// dividendBatch is what one transaction of a dividend paid.
+type dividendBatch struct {
+	holders int
+	paid    models.Money
+	skipped []string
+}
+
+// dividendHolders returns the users who held the symbol of a dividend at the
+// end of its record date, sorted by id, their shares then and their emails.
+func (s *MongoStore) dividendHolders(ctx context.Context, action models.CorporateAction) ([]string, map[string]int, map[string]string, error) {
+	cutoff := recordCutoff(*action.RecordDate)
+	filter := bson.M{
+		"symbol":     action.Symbol,
+		"dateBaught": bson.M{"$lt": cutoff},
+		"$or":        bson.A{bson.M{"soldIndicator": "N"}, bson.M{"dateSold": bson.M{"$gte": cutoff}}},
+	}
+	cursor, err := getDBCollection("Lots", s.client).Find(ctx, filter)
+	if err != nil {
+		logger.Error("Unable to get lots: " + err.Error())
+		return nil, nil, nil, err
+	}
+	lots := []models.Share{}
+	err = cursor.All(ctx, &lots)
+	if err != nil {
+		logger.Error("Unable to decode lots: " + err.Error())
+		return nil, nil, nil, err
+	}
+	splits, err := s.splitsSince(ctx, action.Symbol, cutoff)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	userIDs, quantities, err := dividendHoldings(lots, cutoff, splits)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	emails, err := s.emailsByID(ctx, userIDs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return userIDs, quantities, emails, nil
+}
+
+// payDividend credits the users in userIDs the dividend of action on their
+// shares, and adds what it paid to batch. Users whose balance is in another
+// currency are skipped, and users the action already paid are only counted.
+func (s *MongoStore) payDividend(ctx context.Context, undo *undoLog, action models.CorporateAction, userIDs []string, quantities map[string]int, emails map[string]string, batch *dividendBatch) error {
+	ledger := getDBCollection("Ledger", s.client)
+	for _, userID := range userIDs {
+		email, ok := emails[userID]
+		if !ok {
+			continue
+		}
+		change, err := dividendOf(action, quantities[userID])
+		if err != nil {
+			return err
+		}
+		filter := bson.M{"userID": userID, "type": models.LedgerDividend, "referenceID": action.ID}
+		count, err := ledger.CountDocuments(ctx, filter)
+		if err != nil {
+			logger.Error("Unable to check dividend payments: " + err.Error())
+			return err
+		}
+		if count == 0 {
+			_, err = s.updateBalance(ctx, undo, email, change)
+		}
+		if errors.Is(err, ErrCurrencyMismatch) {
+			batch.skipped = append(batch.skipped, email)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		batch.holders++
+		batch.paid, err = batch.paid.Add(change.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitsSince returns the splits of symbol applied after cutoff.
+func (s *MongoStore) splitsSince(ctx context.Context, symbol string, cutoff time.Time) ([]models.CorporateAction, error) {
+	filter := bson.M{"symbol": symbol, "type": models.CorporateSplit, "appliedAt": bson.M{"$gt": cutoff}}
+	cursor, err := getDBCollection("CorporateActions", s.client).Find(ctx, filter)
+	if err != nil {
+		logger.Error("Unable to get splits: " + err.Error())
+		return nil, err
+	}
+	splits := []models.CorporateAction{}
+	err = cursor.All(ctx, &splits)
+	if err != nil {
+		logger.Error("Unable to decode splits: " + err.Error())
+		return nil, err
+	}
+	return splits, nil
+}
+
+// emailsByID maps the ids of users to their email.
+func (s *MongoStore) emailsByID(ctx context.Context, userIDs []string) (map[string]string, error) {
+	ids := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, userID := range userIDs {
+		id, err := primitive.ObjectIDFromHex(userID)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "email", Value: 1}})
+	cursor, err := getDBCollection("Users", s.client).Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		logger.Error("Unable to get users: " + err.Error())
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	emails := make(map[string]string, len(ids))
+	for cursor.Next(ctx) {
+		user := struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Email string             `bson:"email"`
+		}{}
+		err = cursor.Decode(&user)
+		if err != nil {
+			return nil, err
+		}
+		emails[user.ID.Hex()] = user.Email
+	}
+	return emails, cursor.Err()
+}
+
+// GetCorporateActions returns the actions applied to symbol, or to every
+// symbol when it is empty, oldest first.
+func (s *MongoStore) GetCorporateActions(symbol string) ([]models.CorporateAction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if symbol != "" {
+		filter["symbol"] = symbol
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "appliedAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := getDBCollection("CorporateActions", s.client).Find(ctx, filter, opts)
+	if err != nil {
+		logger.Error("Unable to get corporate actions: " + err.Error())
+		return nil, err
+	}
+	actions := []models.CorporateAction{}
+	err = cursor.All(ctx, &actions)
+	if err != nil {
+		logger.Error("Unable to decode corporate actions: " + err.Error())
+		return nil, err
+	}
+	return actions, nil
+}
+
+func (s *MemoryStore) ApplyCorporateAction(action models.CorporateAction) (models.CorporateAction, error) {
+	action, err := newCorporateAction(action, s.clock.Now())
+	if err != nil {
+		return models.CorporateAction{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.actions[action.ID]; ok {
+		return models.CorporateAction{}, ErrActionApplied
+	}
+	if action.Type == models.CorporateSplit {
+		err = s.applySplit(&action)
+	} else {
+		err = s.payDividend(&action)
+	}
+	if err != nil {
+		return models.CorporateAction{}, err
+	}
+	s.actions[action.ID] = action
+	return action, nil
+}
+
+// applySplit is the MongoStore applySplit for a caller that holds s.mu.
+func (s *MemoryStore) applySplit(action *models.CorporateAction) error {
+	open := []*models.Share{}
+	split := []models.Share{}
+	for _, lot := range s.lots {
+		if lot.Symbol != action.Symbol || lot.SoldIndicator != "N" {
+			continue
+		}
+		adjusted, err := splitLot(*lot, action.SplitTo, action.SplitFrom)
+		if err != nil {
+			return err
+		}
+		open = append(open, lot)
+		split = append(split, adjusted)
+	}
+
+	// Every order and its hold are looked up before anything changes, so a
+	// failure leaves the lots and orders as they were.
+	type cancellation struct {
+		entry *memoryUser
+		order *models.Order
+		hold  *models.Hold
+	}
+	cancellations := []cancellation{}
+	for _, order := range s.orders {
+		if order.Symbol != action.Symbol || order.Status != models.OrderOpen {
+			continue
+		}
+		entry, _, err := s.openOrder(order.Email, order.ID.Hex())
+		if err != nil {
+			return err
+		}
+		var hold *models.Hold
+		if order.HoldID != "" {
+			_, hold, err = s.pendingHold(order.Email, order.HoldID, models.HoldOrder)
+			if err != nil && !errors.Is(err, ErrHoldNotPending) {
+				return err
+			}
+		}
+		cancellations = append(cancellations, cancellation{entry: entry, order: order, hold: hold})
+	}
+
+	now := s.clock.Now()
+	for _, cancel := range cancellations {
+		if cancel.hold != nil {
+			releaseMemoryHold(cancel.entry, cancel.hold, now)
+		}
+		closeMemoryOrder(cancel.order, models.OrderCancelled, now)
+		cancel.order.Reason = splitCancelReason
+	}
+	for i, lot := range open {
+		*lot = split[i]
+	}
+	action.CancelledOrders = len(cancellations)
+	action.Lots = len(open)
+	return nil
+}
+
+// payDividend is the MongoStore payDividend for a caller that holds s.mu.
+func (s *MemoryStore) payDividend(action *models.CorporateAction) error {
+	lots := []models.Share{}
+	for _, lot := range s.lots {
+		if lot.Symbol == action.Symbol {
+			lots = append(lots, *lot)
+		}
+	}
+	splits := []models.CorporateAction{}
+	for _, applied := range s.actions {
+		if applied.Symbol == action.Symbol && applied.Type == models.CorporateSplit {
+			splits = append(splits, applied)
+		}
+	}
+	userIDs, quantities, err := dividendHoldings(lots, recordCutoff(*action.RecordDate), splits)
+	if err != nil {
+		return err
+	}
+	users := make(map[string]*memoryUser, len(s.users))
+	for _, entry := range s.users {
+		users[entry.id.Hex()] = entry
+	}
+
+	paid := models.NewMoney(0, action.AmountPerShare.Currency)
+	for _, userID := range userIDs {
+		entry, ok := users[userID]
+		if !ok {
+			continue
+		}
+		change, err := dividendOf(*action, quantities[userID])
+		if err != nil {
+			return err
+		}
+		_, err = s.applyBalanceChange(entry, change)
+		if errors.Is(err, ErrCurrencyMismatch) {
+			action.Skipped = append(action.Skipped, entry.user.Email)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		action.Holders++
+		paid, err = paid.Add(change.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	action.Paid = &paid
+	return nil
+}
+
+func (s *MemoryStore) GetCorporateActions(symbol string) ([]models.CorporateAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	actions := []models.CorporateAction{}
+	for _, action := range s.actions {
+		if symbol == "" || action.Symbol == symbol {
+			actions = append(actions, action)
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if !actions[i].AppliedAt.Equal(actions[j].AppliedAt) {
+			return actions[i].AppliedAt.Before(actions[j].AppliedAt)
+		}
+		return actions[i].ID < actions[j].ID
+	})
+	return actions, nil
+}
@@ -0,0 +1,113 @@
+package src
+
+import "errors"
+
+var (
+	// ErrEmailTaken is returned when registering an email that another user
+	// already has.
+	ErrEmailTaken = errors.New("Email already in use.")
+
+	// ErrInsufficientFunds is returned when a debit would take a balance below zero.
+	ErrInsufficientFunds = errors.New("Insufficient balance to complete the transaction.")
+
+	// ErrCurrencyMismatch is returned when an amount is not in the currency
+	// of the balance it is applied to.
+	ErrCurrencyMismatch = errors.New("Amount is not in the currency of the balance.")
+
+	// ErrShareNotOwned is returned when selling a share the user does not hold.
+	ErrShareNotOwned = errors.New("Unable to complete the transaction. User does not own the shares.")
+
+	// ErrInsufficientShares is returned when a sell asks for more shares than
+	// the matching lots hold.
+	ErrInsufficientShares = errors.New("Unable to complete the transaction. User does not own enough shares.")
+
+	// ErrHoldingsChanged is returned when a lot was modified by a concurrent
+	// request while a sell was consuming it. Retrying the sell is safe.
+	ErrHoldingsChanged = errors.New("Holdings changed while selling, please retry.")
+
+	// ErrSlippageExceeded is returned when the market price moved further
+	// from the price the client expected than quotes.maxSlippageBps allows.
+	ErrSlippageExceeded = errors.New("Market price moved too far from the expected price.")
+
+	// ErrMixedSymbols is returned when the lots named by a sell are not all of
+	// the same symbol.
+	ErrMixedSymbols = errors.New("All lots of a sell must be of the same symbol.")
+
+	// ErrHoldNotPending is returned when settling or cancelling a hold that
+	// was already settled or cancelled.
+	ErrHoldNotPending = errors.New("Hold is no longer pending.")
+	// ErrHoldType is returned when settling or cancelling a hold of another
+	// type, such as the hold of an open order through the withdrawal routes.
+	ErrHoldType = errors.New("Hold is not a withdrawal.")
+
+	// ErrDepositTooLarge and ErrDailyDepositLimit are returned when a deposit
+	// exceeds the limits in config.json.
+	ErrDepositTooLarge   = errors.New("Amount exceeds the maximum deposit.")
+	ErrDailyDepositLimit = errors.New("Amount exceeds the daily deposit limit.")
+
+	ErrInvalidAmount      = errors.New("Amount must be positive.")
+	ErrInvalidQuantity    = errors.New("Quantity must be a whole number from 1 to 1000000.")
+	ErrInvalidPrice       = errors.New("Price must not be negative.")
+	ErrMissingSymbol      = errors.New("Symbol is missing.")
+	ErrInvalidLotMatching = errors.New("Lot matching must be FIFO, LIFO, HIGHEST_COST, or SPECIFIC with a list of shareIDs.")
+	// ErrMissingExpectedPrice is returned for a buy or sell without the price
+	// the client expects to trade at, which the slippage check needs.
+	ErrMissingExpectedPrice = errors.New("Buys and sells need the price the client expects to trade at.")
+
+	ErrInvalidOrderSide   = errors.New("Order side must be buy or sell.")
+	ErrInvalidOrderType   = errors.New("Order type must be MARKET, LIMIT, STOP or STOP_LIMIT.")
+	ErrInvalidTimeInForce = errors.New("Time in force must be GTC or DAY.")
+	ErrMissingLimitPrice  = errors.New("LIMIT and STOP_LIMIT orders need a positive limitPrice.")
+	ErrMissingStopPrice   = errors.New("STOP and STOP_LIMIT orders need a positive stopPrice.")
+
+	// ErrOrderNotOpen is returned when cancelling, filling or triggering an
+	// order that was already filled or closed.
+	ErrOrderNotOpen = errors.New("Order is no longer open.")
+
+	ErrMissingActionID      = errors.New("Corporate action id is missing.")
+	ErrInvalidActionType    = errors.New("Corporate action type must be split or dividend.")
+	ErrMissingRecordDate    = errors.New("Dividends need a recordDate.")
+	ErrRecordDateNotPast    = errors.New("Record date must be a day that has already ended.")
+	ErrFractionalSplit      = errors.New("Split would leave a lot with a fractional number of shares.")
+	ErrActionApplied        = errors.New("Corporate action was already applied.")
+	ErrCorporateActionRaced = errors.New("Holdings changed while applying the corporate action, please retry.")
+	// ErrRecordDateHoldings is returned when undoing the splits applied since
+	// the record date of a dividend leaves a holder with a fractional share.
+	ErrRecordDateHoldings = errors.New("Shares held at the record date could not be worked out from the splits applied since.")
+
+	ErrInvalidTransactionType = errors.New("Transaction type must be buy or sell.")
+
+	ErrInvalidWatchlistName = errors.New("Watchlist name must be between 1 and 100 characters.")
+	ErrDuplicateSymbol      = errors.New("A watchlist may list each symbol only once.")
+	ErrWatchlistTooLarge    = errors.New("Watchlist holds more symbols than allowed.")
+	ErrTooManyWatchlists    = errors.New("User already has the maximum number of watchlists.")
+	// ErrWatchlistExists is returned when a user already has a watchlist of
+	// the same name.
+	ErrWatchlistExists = errors.New("A watchlist with this name already exists.")
+
+	ErrInvalidAlertKind  = errors.New("Alert kind must be price_above, price_below, portfolio_down or portfolio_up.")
+	ErrMissingAlertPrice = errors.New("Price alerts need a symbol and a positive price.")
+	ErrInvalidAlertBps   = errors.New("Portfolio alerts need bps between 1 and 10000.")
+	// ErrAlertNotActive is returned when triggering or cancelling an alert
+	// that already fired or was cancelled.
+	ErrAlertNotActive = errors.New("Alert is no longer active.")
+
+	ErrInvalidAccountStatus = errors.New("Account status must be pending, active, suspended, frozen or closed.")
+	// ErrStatusTransition is returned for a status change the transition
+	// table does not allow.
+	ErrStatusTransition = errors.New("Account status can not change")
+	// ErrStatusChanged is returned when the status of an account is not the
+	// one a change expected, usually because it changed concurrently.
+	ErrStatusChanged = errors.New("Account status changed, please retry.")
+	// ErrAccountBlocked is returned when a suspended, frozen or closed account
+	// tries to trade or deposit, or a suspended or frozen one to withdraw.
+	ErrAccountBlocked = errors.New("Account is suspended, frozen or closed.")
+
+	// ErrInvalidCursor is returned when a page cursor was not issued by us.
+	ErrInvalidCursor = errors.New("Invalid page cursor.")
+
+	// ErrRollbackFailed is returned when a multi-step write failed on a
+	// deployment without transactions and its compensating writes could not
+	// be applied.
+	ErrRollbackFailed = errors.New("Unable to roll back a partially applied transaction")
+)
@@ -0,0 +1,357 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newHold fills in the fields of a hold that is about to be placed.
+func newHold(email string, hold models.Hold, now time.Time) (models.Hold, error) {
+	if !hold.Amount.IsPositive() {
+		return hold, ErrInvalidAmount
+	}
+	hold.ID = primitive.NewObjectID()
+	hold.Email = email
+	hold.Amount = models.NewMoney(hold.Amount.Amount, hold.Amount.Currency)
+	hold.Status = models.HoldPending
+	hold.CreatedAt = now
+	hold.ResolvedAt = nil
+	return hold, nil
+}
+
+// settlementOf is the debit that settles hold.
+func settlementOf(hold models.Hold) models.BalanceChange {
+	return models.BalanceChange{
+		Type:        models.LedgerWithdrawal,
+		Amount:      hold.Amount,
+		ReferenceID: hold.ID.Hex(),
+		Release:     hold.Amount,
+	}
+}
+
+// holdRestricted returns the account statuses that may not place hold. The
+// hold of an order is checked when the order is placed.
+func holdRestricted(hold models.Hold) []models.AccountStatus {
+	if hold.Type == models.HoldWithdrawal {
+		return restricted(models.LedgerWithdrawal)
+	}
+	return nil
+}
+
+// PlaceHold reserves hold.Amount of the available balance of a user. The
+// balance itself does not change until the hold is settled.
+func (s *MongoStore) PlaceHold(email string, hold models.Hold) (models.Hold, error) {
+	hold, err := newHold(email, hold, s.clock.Now())
+	if err != nil {
+		return models.Hold{}, err
+	}
+
+	err = s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		hold, err = s.placeHold(ctx, undo, email, hold)
+		return err
+	})
+	if err != nil {
+		return models.Hold{}, err
+	}
+	logger.Info("Placed hold " + hold.ID.Hex())
+	return hold, nil
+}
+
+// placeHold adds a hold made by newHold to the held balance of a user, as long
+// as their available balance covers it, and saves it.
+func (s *MongoStore) placeHold(ctx context.Context, undo *undoLog, email string, hold models.Hold) (models.Hold, error) {
+	filter := bson.M{
+		"email":            bson.M{"$eq": email},
+		"balance.currency": hold.Amount.Currency,
+		"$expr":            availableCovers(hold.Amount.Amount, 0),
+	}
+	blocked := holdRestricted(hold)
+	if blocked != nil {
+		filter["accountStatus"] = bson.M{"$nin": blocked}
+	}
+	update := bson.M{"$inc": bson.M{"heldBalance.amount": hold.Amount.Amount}}
+	opts := options.FindOneAndUpdate().SetProjection(bson.D{{Key: "_id", Value: 1}})
+	user := account{}
+	err := getDBCollection("Users", s.client).FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.Hold{}, s.balanceFailure(ctx, email, hold.Amount, blocked)
+	}
+	if err != nil {
+		logger.Error("Unable to hold funds: " + err.Error())
+		return models.Hold{}, err
+	}
+	hold.UserID = user.ID.Hex()
+	undo.add(func(ctx context.Context) error {
+		return s.incHeld(ctx, user.ID, hold.Amount.Neg())
+	})
+
+	collection := getDBCollection("Holds", s.client)
+	_, err = collection.InsertOne(ctx, hold)
+	if err != nil {
+		logger.Error("Unable to save hold: " + err.Error())
+		return models.Hold{}, err
+	}
+	undo.add(func(ctx context.Context) error {
+		_, err := collection.DeleteOne(ctx, bson.M{"_id": hold.ID})
+		return err
+	})
+	return hold, nil
+}
+
+// SettleHold debits a pending withdrawal hold from the balance and records it
+// in the ledger. The holds of orders are settled by their fill only.
+func (s *MongoStore) SettleHold(email string, holdID string) (models.Hold, error) {
+	hold := models.Hold{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		var err error
+		hold, err = s.resolveHold(ctx, undo, email, holdID, models.HoldWithdrawal, models.HoldSettled)
+		if err != nil {
+			return err
+		}
+		_, err = s.updateBalance(ctx, undo, email, settlementOf(hold))
+		return err
+	})
+	if err != nil {
+		return models.Hold{}, err
+	}
+	return hold, nil
+}
+
+// CancelHold makes the funds of a pending withdrawal hold available again.
+// The holds of orders are released by closing the order.
+func (s *MongoStore) CancelHold(email string, holdID string) (models.Hold, error) {
+	hold := models.Hold{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		var err error
+		hold, err = s.releaseHold(ctx, undo, email, holdID, models.HoldWithdrawal)
+		return err
+	})
+	if err != nil {
+		return models.Hold{}, err
+	}
+	return hold, nil
+}
+
+// releaseHold cancels a pending hold of holdType and takes it off the held
+// balance.
+func (s *MongoStore) releaseHold(ctx context.Context, undo *undoLog, email string, holdID string, holdType models.HoldType) (models.Hold, error) {
+	hold, err := s.resolveHold(ctx, undo, email, holdID, holdType, models.HoldCancelled)
+	if err != nil {
+		return models.Hold{}, err
+	}
+	userID, err := primitive.ObjectIDFromHex(hold.UserID)
+	if err != nil {
+		return models.Hold{}, err
+	}
+	err = s.incHeld(ctx, userID, hold.Amount.Neg())
+	if err != nil {
+		logger.Error("Unable to release hold: " + err.Error())
+		return models.Hold{}, err
+	}
+	undo.add(func(ctx context.Context) error {
+		return s.incHeld(ctx, userID, hold.Amount)
+	})
+	return hold, nil
+}
+
+// GetHolds returns the holds of a user with status, or all of them when
+// status is empty, oldest first.
+func (s *MongoStore) GetHolds(email string, status models.HoldStatus) ([]models.Hold, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"userID": userID}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := getDBCollection("Holds", s.client).Find(ctx, filter, opts)
+	if err != nil {
+		logger.Error("Unable to get holds of user: " + err.Error())
+		return nil, err
+	}
+	holds := []models.Hold{}
+	err = cursor.All(ctx, &holds)
+	if err != nil {
+		logger.Error("Unable to decode holds of user: " + err.Error())
+		return nil, err
+	}
+	return holds, nil
+}
+
+// resolveHold moves a pending hold of holdType of a user to status. Only one
+// request can do so, since the update matches pending holds only.
+func (s *MongoStore) resolveHold(ctx context.Context, undo *undoLog, email string, holdID string, holdType models.HoldType, status models.HoldStatus) (models.Hold, error) {
+	id, err := primitive.ObjectIDFromHex(holdID)
+	if err != nil {
+		return models.Hold{}, mongo.ErrNoDocuments
+	}
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return models.Hold{}, err
+	}
+
+	collection := getDBCollection("Holds", s.client)
+	filter := bson.M{"_id": id, "userID": userID, "type": holdType, "status": models.HoldPending}
+	update := bson.M{"$set": bson.M{"status": status, "resolvedAt": s.clock.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	hold := models.Hold{}
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&hold)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = collection.FindOne(ctx, bson.M{"_id": id, "userID": userID}).Decode(&hold)
+		if err != nil {
+			return models.Hold{}, err
+		}
+		if hold.Type != holdType {
+			return models.Hold{}, ErrHoldType
+		}
+		return models.Hold{}, ErrHoldNotPending
+	}
+	if err != nil {
+		logger.Error("Unable to update hold: " + err.Error())
+		return models.Hold{}, err
+	}
+	undo.add(func(ctx context.Context) error {
+		restore := bson.M{"$set": bson.M{"status": models.HoldPending}, "$unset": bson.M{"resolvedAt": ""}}
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, restore)
+		return err
+	})
+	return hold, nil
+}
+
+// incHeld adds amount to the held balance of a user.
+func (s *MongoStore) incHeld(ctx context.Context, userID primitive.ObjectID, amount models.Money) error {
+	update := bson.M{"$inc": bson.M{"heldBalance.amount": amount.Amount}}
+	_, err := getDBCollection("Users", s.client).UpdateOne(ctx, bson.M{"_id": userID}, update)
+	return err
+}
+
+func (s *MemoryStore) PlaceHold(email string, hold models.Hold) (models.Hold, error) {
+	hold, err := newHold(email, hold, s.clock.Now())
+	if err != nil {
+		return models.Hold{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.Hold{}, mongo.ErrNoDocuments
+	}
+	return s.placeHold(entry, hold)
+}
+
+// placeHold adds a hold made by newHold to the held balance of entry. The
+// caller holds s.mu.
+func (s *MemoryStore) placeHold(entry *memoryUser, hold models.Hold) (models.Hold, error) {
+	if entry.user.AccountStatus.In(holdRestricted(hold)) {
+		return models.Hold{}, ErrAccountBlocked
+	}
+	if !entry.user.Balance.SameCurrency(hold.Amount) {
+		return models.Hold{}, ErrCurrencyMismatch
+	}
+	if available(&entry.user).LessThan(hold.Amount) {
+		return models.Hold{}, ErrInsufficientFunds
+	}
+	held, err := entry.user.HeldBalance.Add(hold.Amount)
+	if err != nil {
+		return models.Hold{}, err
+	}
+	entry.user.HeldBalance = held
+	hold.UserID = entry.id.Hex()
+	s.holds = append(s.holds, &hold)
+	return hold, nil
+}
+
+func (s *MemoryStore) SettleHold(email string, holdID string) (models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, hold, err := s.pendingHold(email, holdID, models.HoldWithdrawal)
+	if err != nil {
+		return models.Hold{}, err
+	}
+	_, err = s.applyBalanceChange(entry, settlementOf(*hold))
+	if err != nil {
+		return models.Hold{}, err
+	}
+	resolveMemoryHold(hold, models.HoldSettled, s.clock.Now())
+	return *hold, nil
+}
+
+func (s *MemoryStore) CancelHold(email string, holdID string) (models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, hold, err := s.pendingHold(email, holdID, models.HoldWithdrawal)
+	if err != nil {
+		return models.Hold{}, err
+	}
+	releaseMemoryHold(entry, hold, s.clock.Now())
+	return *hold, nil
+}
+
+func (s *MemoryStore) GetHolds(email string, status models.HoldStatus) ([]models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	userID := entry.id.Hex()
+	holds := []models.Hold{}
+	for _, hold := range s.holds {
+		if hold.UserID == userID && (status == "" || hold.Status == status) {
+			holds = append(holds, *hold)
+		}
+	}
+	return holds, nil
+}
+
+// pendingHold finds a pending hold of holdType of a user. The caller holds
+// s.mu.
+func (s *MemoryStore) pendingHold(email string, holdID string, holdType models.HoldType) (*memoryUser, *models.Hold, error) {
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, nil, mongo.ErrNoDocuments
+	}
+	userID := entry.id.Hex()
+	for _, hold := range s.holds {
+		if hold.UserID != userID || hold.ID.Hex() != holdID {
+			continue
+		}
+		if hold.Type != holdType {
+			return nil, nil, ErrHoldType
+		}
+		if hold.Status != models.HoldPending {
+			return nil, nil, ErrHoldNotPending
+		}
+		return entry, hold, nil
+	}
+	return nil, nil, mongo.ErrNoDocuments
+}
+
+// releaseMemoryHold cancels a pending hold of entry. The caller holds s.mu.
+func releaseMemoryHold(entry *memoryUser, hold *models.Hold, now time.Time) {
+	entry.user.HeldBalance = entry.user.HeldBalance.Sub(hold.Amount)
+	resolveMemoryHold(hold, models.HoldCancelled, now)
+}
+
+func resolveMemoryHold(hold *models.Hold, status models.HoldStatus, now time.Time) {
+	hold.Status = status
+	hold.ResolvedAt = &now
+}
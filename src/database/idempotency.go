@@ -0,0 +1,125 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ReserveIdempotencyKey inserts record, relying on the unique index on email
+// and key to let only one request through. Expired records may linger until
+// the TTL monitor runs, so they are deleted and the insert is retried once.
+func (s *MongoStore) ReserveIdempotencyKey(record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("IdempotencyKeys", s.client)
+	filter := bson.M{"email": record.Email, "key": record.Key}
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := collection.InsertOne(ctx, record)
+		if err == nil {
+			return models.IdempotencyRecord{}, true, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			logger.Error("Unable to save idempotency key: " + err.Error())
+			return models.IdempotencyRecord{}, false, err
+		}
+
+		existing := models.IdempotencyRecord{}
+		err = collection.FindOne(ctx, filter).Decode(&existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			logger.Error("Unable to get idempotency key: " + err.Error())
+			return models.IdempotencyRecord{}, false, err
+		}
+		if existing.ExpiresAt.After(s.clock.Now()) {
+			return existing, false, nil
+		}
+		_, err = collection.DeleteOne(ctx, bson.M{"email": record.Email, "key": record.Key, "expiresAt": existing.ExpiresAt})
+		if err != nil {
+			return models.IdempotencyRecord{}, false, err
+		}
+	}
+	return models.IdempotencyRecord{}, false, mongo.ErrNoDocuments
+}
+
+// CompleteIdempotencyKey stores the response to the request that reserved
+// the key.
+func (s *MongoStore) CompleteIdempotencyKey(record models.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"email": record.Email, "key": record.Key}
+	update := bson.M{"$set": bson.M{
+		"completed":   true,
+		"statusCode":  record.StatusCode,
+		"contentType": record.ContentType,
+		"body":        record.Body,
+	}}
+	_, err := getDBCollection("IdempotencyKeys", s.client).UpdateOne(ctx, filter, update)
+	if err != nil {
+		logger.Error("Unable to save idempotent response: " + err.Error())
+	}
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a key, so that the request can be retried.
+func (s *MongoStore) ReleaseIdempotencyKey(email string, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := getDBCollection("IdempotencyKeys", s.client).DeleteOne(ctx, bson.M{"email": email, "key": key})
+	if err != nil {
+		logger.Error("Unable to release idempotency key: " + err.Error())
+	}
+	return err
+}
+
+func idempotencyID(email string, key string) string {
+	return email + "\n" + key
+}
+
+func (s *MemoryStore) ReserveIdempotencyKey(record models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyID(record.Email, record.Key)
+	existing, ok := s.idempotency[id]
+	if ok && existing.ExpiresAt.After(s.clock.Now()) {
+		return existing, false, nil
+	}
+	s.idempotency[id] = record
+	return models.IdempotencyRecord{}, true, nil
+}
+
+func (s *MemoryStore) CompleteIdempotencyKey(record models.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyID(record.Email, record.Key)
+	stored, ok := s.idempotency[id]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	stored.Completed = true
+	stored.StatusCode = record.StatusCode
+	stored.ContentType = record.ContentType
+	stored.Body = record.Body
+	s.idempotency[id] = stored
+	return nil
+}
+
+func (s *MemoryStore) ReleaseIdempotencyKey(email string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idempotency, idempotencyID(email, key))
+	return nil
+}
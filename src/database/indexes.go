@@ -0,0 +1,82 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionIndexes lists the indexes every collection needs. They are created
+// when the store starts; creating an index that already exists is a no-op.
+var collectionIndexes = map[string][]mongo.IndexModel{
+	"Users": {
+		// Every user is looked up by email, and the unique index keeps two
+		// concurrent registrations from creating the same user twice.
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"Lots": {
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "shareID", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "symbol", Value: 1}, {Key: "soldIndicator", Value: 1}}},
+		{Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "soldIndicator", Value: 1}}},
+	},
+	"Ledger": {
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "type", Value: 1}, {Key: "createdAt", Value: 1}}},
+	},
+	"Holds": {
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	"Orders": {
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "status", Value: 1}}},
+	},
+	"Alerts": {
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+	},
+	"StatusHistory": {
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "_id", Value: 1}}},
+	},
+	"Watchlists": {
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"CorporateActions": {
+		{Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "appliedAt", Value: 1}}},
+	},
+	"IdempotencyKeys": {
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"EmailConfirmations": {
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "email", Value: 1}}},
+		// Lets the server drop expired tokens on its own.
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"RefreshTokens": {
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "familyID", Value: 1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+}
+
+func ensureIndexes(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for collectionName, indexes := range collectionIndexes {
+		collection := getDBCollection(collectionName, client)
+		_, err := collection.Indexes().CreateMany(ctx, indexes)
+		if err != nil {
+			logger.Error("Unable to create indexes on " + collectionName + ": " + err.Error())
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,523 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultLedgerPageSize = 50
+	MaxLedgerPageSize     = 500
+)
+
+// ledgerPageSize clamps a requested page size to what GetLedger serves.
+func ledgerPageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultLedgerPageSize
+	}
+	if limit > MaxLedgerPageSize {
+		return MaxLedgerPageSize
+	}
+	return limit
+}
+
+// openingBalance is the change that takes an empty balance to balance.
+func openingBalance(balance models.Money) models.BalanceChange {
+	change := models.BalanceChange{Type: models.LedgerOpening, Amount: balance, Credit: true}
+	if balance.IsNegative() {
+		change.Amount = balance.Neg()
+		change.Credit = false
+	}
+	return change
+}
+
+// buyChange debits the cost of a new lot, fee included.
+func buyChange(share models.Share) (models.BalanceChange, error) {
+	price := share.PriceBaught
+	fee := share.BuyFee
+	cost, err := share.CostBasis()
+	if err != nil {
+		return models.BalanceChange{}, err
+	}
+	return models.BalanceChange{
+		Type:        models.LedgerBuy,
+		Amount:      cost,
+		ReferenceID: share.ShareID,
+		Symbol:      share.Symbol,
+		Quantity:    share.Quantity,
+		Price:       &price,
+		Fee:         &fee,
+	}, nil
+}
+
+func sellChange(order models.SellOrder, result models.SellResult) models.BalanceChange {
+	price := order.PriceSold
+	fee := result.Fee
+	return models.BalanceChange{
+		Type:        models.LedgerSell,
+		Amount:      result.Proceeds,
+		Credit:      true,
+		ReferenceID: result.SaleID,
+		Symbol:      result.Symbol,
+		Quantity:    result.Quantity,
+		Price:       &price,
+		Fee:         &fee,
+	}
+}
+
+// reversalOf is the change that undoes entry.
+func reversalOf(entry models.LedgerEntry) models.BalanceChange {
+	change := models.BalanceChange{
+		Type:        models.LedgerReversal,
+		Amount:      entry.Amount,
+		Credit:      true,
+		ReferenceID: entry.ID.Hex(),
+		Symbol:      entry.Symbol,
+		Quantity:    entry.Quantity,
+	}
+	if entry.Amount.IsPositive() {
+		change.Credit = false
+	} else {
+		change.Amount = entry.Amount.Neg()
+	}
+	return change
+}
+
+// account is the part of a user document a balance update returns.
+type account struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Balance models.Money       `bson:"balance"`
+}
+
+// incBalance adds delta to the balance of the user matching filter, takes
+// released off their held balance, and returns the balance it ended up with.
+func (s *MongoStore) incBalance(ctx context.Context, filter bson.M, delta models.Money, released models.Money) (account, error) {
+	result := account{}
+	inc := bson.M{"balance.amount": delta.Amount}
+	if released.Amount != 0 {
+		inc["heldBalance.amount"] = -released.Amount
+	}
+	update := bson.M{"$inc": inc}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "balance", Value: 1}})
+	err := getDBCollection("Users", s.client).FindOneAndUpdate(ctx, filter, update, opts).Decode(&result)
+	return result, err
+}
+
+// reverseBalance compensates a balance change made without a transaction,
+// including the held funds it released. The ledger stays append-only: when
+// the change was recorded, a reversal entry is written instead of deleting
+// it.
+func (s *MongoStore) reverseBalance(ctx context.Context, entry models.LedgerEntry, released models.Money, recorded bool) error {
+	change := reversalOf(entry)
+	userID, err := primitive.ObjectIDFromHex(entry.UserID)
+	if err != nil {
+		return err
+	}
+	account, err := s.incBalance(ctx, bson.M{"_id": userID}, change.Delta(), released.Neg())
+	if err != nil {
+		return err
+	}
+	if !recorded {
+		return nil
+	}
+	reversal := models.NewLedgerEntry(entry.UserID, entry.Email, change, account.Balance, s.clock.Now())
+	_, err = getDBCollection("Ledger", s.client).InsertOne(ctx, reversal)
+	return err
+}
+
+// checkDeposit applies the deposit limits to a deposit of amount. In a
+// transaction, two concurrent deposits both write the user document, so one
+// of them is retried and sees the other in the ledger.
+func (s *MongoStore) checkDeposit(ctx context.Context, email string, amount models.Money) error {
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"userID":          userID,
+			"type":            models.LedgerDeposit,
+			"amount.currency": models.NewMoney(0, amount.Currency).Currency,
+			"createdAt":       bson.M{"$gte": s.clock.Now().Add(-depositWindow)},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$amount.amount"}}}},
+	}
+	cursor, err := getDBCollection("Ledger", s.client).Aggregate(ctx, pipeline)
+	if err != nil {
+		logger.Error("Unable to sum deposits of user: " + err.Error())
+		return err
+	}
+	sums := []struct {
+		Total int64 `bson:"total"`
+	}{}
+	err = cursor.All(ctx, &sums)
+	if err != nil {
+		return err
+	}
+
+	deposited := models.NewMoney(0, amount.Currency)
+	if len(sums) > 0 {
+		deposited.Amount = sums[0].Total
+	}
+	return s.validateDeposit(amount, deposited)
+}
+
+// tradedSince sums the value of the buys and sells of a user in the currency
+// of price since a point in time, which sets their fee tier.
+func (s *MongoStore) tradedSince(ctx context.Context, userID string, price models.Money, since time.Time) (models.Money, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"userID":         userID,
+			"type":           bson.M{"$in": bson.A{models.LedgerBuy, models.LedgerSell}},
+			"price.currency": models.NewMoney(0, price.Currency).Currency,
+			"createdAt":      bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": bson.M{"$multiply": bson.A{"$price.amount", "$quantity"}}}}}},
+	}
+	cursor, err := getDBCollection("Ledger", s.client).Aggregate(ctx, pipeline)
+	if err != nil {
+		logger.Error("Unable to sum trades of user: " + err.Error())
+		return models.Money{}, err
+	}
+	sums := []struct {
+		Total int64 `bson:"total"`
+	}{}
+	err = cursor.All(ctx, &sums)
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	traded := models.NewMoney(0, price.Currency)
+	if len(sums) > 0 {
+		traded.Amount = sums[0].Total
+	}
+	return traded, nil
+}
+
+// GetLedger returns up to limit ledger entries of a user, oldest first,
+// starting after the entry with id after.
+func (s *MongoStore) GetLedger(email string, after string, limit int) (models.LedgerPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return models.LedgerPage{}, err
+	}
+
+	filter := bson.M{"userID": userID}
+	if after != "" {
+		cursorID, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return models.LedgerPage{}, ErrInvalidCursor
+		}
+		filter["_id"] = bson.M{"$gt": cursorID}
+	}
+
+	limit = ledgerPageSize(limit)
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit) + 1)
+	cursor, err := getDBCollection("Ledger", s.client).Find(ctx, filter, opts)
+	if err != nil {
+		logger.Error("Unable to get ledger of user: " + err.Error())
+		return models.LedgerPage{}, err
+	}
+	entries := []models.LedgerEntry{}
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		logger.Error("Unable to decode ledger of user: " + err.Error())
+		return models.LedgerPage{}, err
+	}
+	return newLedgerPage(entries, limit), nil
+}
+
+// VerifyBalance sums the ledger of a user and compares it with their stored
+// balance. Both are read in one transaction so that a concurrent change
+// cannot make them disagree.
+func (s *MongoStore) VerifyBalance(email string) (models.BalanceVerification, error) {
+	verification := models.BalanceVerification{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		user := account{}
+		opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "balance", Value: 1}})
+		err := getDBCollection("Users", s.client).FindOne(ctx, bson.M{"email": bson.M{"$eq": email}}, opts).Decode(&user)
+		if err != nil {
+			return err
+		}
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"userID": user.ID.Hex()}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":     nil,
+				"total":   bson.M{"$sum": "$amount.amount"},
+				"entries": bson.M{"$sum": 1},
+			}}},
+		}
+		cursor, err := getDBCollection("Ledger", s.client).Aggregate(ctx, pipeline)
+		if err != nil {
+			logger.Error("Unable to sum ledger of user: " + err.Error())
+			return err
+		}
+		sums := []struct {
+			Total   int64 `bson:"total"`
+			Entries int64 `bson:"entries"`
+		}{}
+		err = cursor.All(ctx, &sums)
+		if err != nil {
+			return err
+		}
+
+		derived := models.NewMoney(0, user.Balance.Currency)
+		count := int64(0)
+		if len(sums) > 0 {
+			derived.Amount = sums[0].Total
+			count = sums[0].Entries
+		}
+		verification = newBalanceVerification(email, user.Balance, derived, count)
+		return nil
+	})
+	return verification, err
+}
+
+func newLedgerPage(entries []models.LedgerEntry, limit int) models.LedgerPage {
+	page := models.LedgerPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = entries[limit-1].ID.Hex()
+	}
+	return page
+}
+
+func newBalanceVerification(email string, stored models.Money, derived models.Money, entries int64) models.BalanceVerification {
+	verification := models.BalanceVerification{
+		Email:      email,
+		Stored:     stored,
+		Derived:    derived,
+		Entries:    entries,
+		Consistent: stored.SameCurrency(derived) && stored.Amount == derived.Amount,
+	}
+	if !verification.Consistent {
+		logger.Error("Balance of " + email + " is " + stored.String() + " but its ledger adds up to " + derived.String())
+	}
+	return verification
+}
+
+// GetAccountSnapshot reads a user with their lots, their ledger and their
+// pending holds in one transaction, like VerifyBalance.
+func (s *MongoStore) GetAccountSnapshot(email string) (models.AccountSnapshot, error) {
+	snapshot := models.AccountSnapshot{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		stored := struct {
+			ID          primitive.ObjectID `bson:"_id"`
+			models.User `bson:",inline"`
+		}{}
+		err := getDBCollection("Users", s.client).FindOne(ctx, bson.M{"email": bson.M{"$eq": email}}).Decode(&stored)
+		if err != nil {
+			return err
+		}
+		user := stored.User
+		userID := stored.ID.Hex()
+		user.Shares, err = s.findLotsByUserID(ctx, userID, bson.M{})
+		if err != nil {
+			return err
+		}
+
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+		cursor, err := getDBCollection("Ledger", s.client).Find(ctx, bson.M{"userID": userID}, opts)
+		if err != nil {
+			logger.Error("Unable to get ledger of user: " + err.Error())
+			return err
+		}
+		entries := []models.LedgerEntry{}
+		err = cursor.All(ctx, &entries)
+		if err != nil {
+			logger.Error("Unable to decode ledger of user: " + err.Error())
+			return err
+		}
+
+		cursor, err = getDBCollection("Holds", s.client).Find(ctx, bson.M{"userID": userID, "status": models.HoldPending}, opts)
+		if err != nil {
+			logger.Error("Unable to get holds of user: " + err.Error())
+			return err
+		}
+		holds := []models.Hold{}
+		err = cursor.All(ctx, &holds)
+		if err != nil {
+			logger.Error("Unable to decode holds of user: " + err.Error())
+			return err
+		}
+		snapshot = models.AccountSnapshot{User: user, Ledger: entries, Holds: holds}
+		return nil
+	})
+	if err != nil {
+		return models.AccountSnapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// applyBalanceChange applies change to the balance of entry and appends it to
+// the ledger. The caller holds s.mu.
+func (s *MemoryStore) applyBalanceChange(entry *memoryUser, change models.BalanceChange) (models.LedgerEntry, error) {
+	if change.Amount.IsNegative() {
+		return models.LedgerEntry{}, ErrInvalidAmount
+	}
+	if entry.user.AccountStatus.In(restricted(change.Type)) {
+		return models.LedgerEntry{}, ErrAccountBlocked
+	}
+	if change.Type == models.LedgerDeposit {
+		deposited, err := s.depositedSince(entry.id.Hex(), change.Amount, s.clock.Now().Add(-depositWindow))
+		if err != nil {
+			return models.LedgerEntry{}, err
+		}
+		err = s.validateDeposit(change.Amount, deposited)
+		if err != nil {
+			return models.LedgerEntry{}, err
+		}
+	}
+	if !entry.user.Balance.SameCurrency(change.Amount) {
+		return models.LedgerEntry{}, ErrCurrencyMismatch
+	}
+	if change.Credit {
+		balance, err := entry.user.Balance.Add(change.Amount)
+		if err != nil {
+			return models.LedgerEntry{}, err
+		}
+		entry.user.Balance = balance
+		entry.user.HeldBalance = entry.user.HeldBalance.Sub(change.Release)
+	} else {
+		err := debit(&entry.user, change.Amount, change.Release)
+		if err != nil {
+			return models.LedgerEntry{}, err
+		}
+	}
+	ledgerEntry := models.NewLedgerEntry(entry.id.Hex(), entry.user.Email, change, entry.user.Balance, s.clock.Now())
+	s.ledger = append(s.ledger, ledgerEntry)
+	return ledgerEntry, nil
+}
+
+// depositedSince sums the deposits of a user in the currency of amount since
+// a point in time. The caller holds s.mu.
+func (s *MemoryStore) depositedSince(userID string, amount models.Money, since time.Time) (models.Money, error) {
+	deposited := models.NewMoney(0, amount.Currency)
+	for _, ledgerEntry := range s.ledger {
+		if ledgerEntry.UserID == userID && ledgerEntry.Type == models.LedgerDeposit &&
+			ledgerEntry.Amount.SameCurrency(amount) && !ledgerEntry.CreatedAt.Before(since) {
+			var err error
+			deposited, err = deposited.Add(ledgerEntry.Amount)
+			if err != nil {
+				return models.Money{}, err
+			}
+		}
+	}
+	return deposited, nil
+}
+
+// tradedSince sums the value of the buys and sells of a user in the currency
+// of price since a point in time. The caller holds s.mu.
+func (s *MemoryStore) tradedSince(userID string, price models.Money, since time.Time) (models.Money, error) {
+	traded := models.NewMoney(0, price.Currency)
+	for _, ledgerEntry := range s.ledger {
+		if ledgerEntry.UserID != userID || ledgerEntry.Price == nil || ledgerEntry.CreatedAt.Before(since) {
+			continue
+		}
+		if (ledgerEntry.Type == models.LedgerBuy || ledgerEntry.Type == models.LedgerSell) && ledgerEntry.Price.SameCurrency(price) {
+			value, err := ledgerEntry.Price.Mul(int64(ledgerEntry.Quantity))
+			if err != nil {
+				return models.Money{}, err
+			}
+			traded, err = traded.Add(value)
+			if err != nil {
+				return models.Money{}, err
+			}
+		}
+	}
+	return traded, nil
+}
+
+func (s *MemoryStore) GetLedger(email string, after string, limit int) (models.LedgerPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.LedgerPage{}, mongo.ErrNoDocuments
+	}
+	cursorID := primitive.NilObjectID
+	if after != "" {
+		var err error
+		cursorID, err = primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return models.LedgerPage{}, ErrInvalidCursor
+		}
+	}
+
+	limit = ledgerPageSize(limit)
+	userID := entry.id.Hex()
+	entries := []models.LedgerEntry{}
+	for _, ledgerEntry := range s.ledger {
+		if ledgerEntry.UserID != userID || after != "" && ledgerEntry.ID.Hex() <= cursorID.Hex() {
+			continue
+		}
+		entries = append(entries, ledgerEntry)
+		if len(entries) > limit {
+			break
+		}
+	}
+	return newLedgerPage(entries, limit), nil
+}
+
+func (s *MemoryStore) VerifyBalance(email string) (models.BalanceVerification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.BalanceVerification{}, mongo.ErrNoDocuments
+	}
+	userID := entry.id.Hex()
+	derived := models.NewMoney(0, entry.user.Balance.Currency)
+	count := int64(0)
+	for _, ledgerEntry := range s.ledger {
+		if ledgerEntry.UserID == userID {
+			derived.Amount += ledgerEntry.Amount.Amount
+			count++
+		}
+	}
+	return newBalanceVerification(email, entry.user.Balance, derived, count), nil
+}
+
+func (s *MemoryStore) GetAccountSnapshot(email string) (models.AccountSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.AccountSnapshot{}, mongo.ErrNoDocuments
+	}
+	userID := entry.id.Hex()
+	snapshot := models.AccountSnapshot{
+		User:   entry.user,
+		Ledger: []models.LedgerEntry{},
+		Holds:  []models.Hold{},
+	}
+	snapshot.User.Shares = s.lotsOf(userID, func(lot *models.Share) bool { return true })
+	for _, ledgerEntry := range s.ledger {
+		if ledgerEntry.UserID == userID {
+			snapshot.Ledger = append(snapshot.Ledger, ledgerEntry)
+		}
+	}
+	for _, hold := range s.holds {
+		if hold.UserID == userID && hold.Status == models.HoldPending {
+			snapshot.Holds = append(snapshot.Holds, *hold)
+		}
+	}
+	return snapshot, nil
+}
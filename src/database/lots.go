@@ -0,0 +1,227 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetLots returns every lot of a user, sold or not, oldest first.
+func (s *MongoStore) GetLots(email string) ([]models.Share, error) {
+	return s.findLots(email, bson.M{})
+}
+
+// GetHoldings returns the lots a user still owns, oldest first.
+func (s *MongoStore) GetHoldings(email string) ([]models.Share, error) {
+	return s.findLots(email, bson.M{"soldIndicator": "N"})
+}
+
+// GetHoldingsBySymbol returns the lots of symbol a user still owns, oldest
+// first.
+func (s *MongoStore) GetHoldingsBySymbol(email string, symbol string) ([]models.Share, error) {
+	return s.findLots(email, bson.M{"symbol": symbol, "soldIndicator": "N"})
+}
+
+// SummarizeLots totals the lots of a user per symbol, currency and whether
+// they are still open, in one aggregation on the server. Summaries are
+// sorted by symbol and currency, open lots first.
+func (s *MongoStore) SummarizeLots(email string) ([]models.LotSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := func(field string) bson.M {
+		return bson.M{"$ifNull": bson.A{field, 0}}
+	}
+	value := func(price string) bson.M {
+		return bson.M{"$multiply": bson.A{amount(price), "$quantity"}}
+	}
+	money := func(amount string) bson.M {
+		return bson.M{"amount": bson.M{"$toLong": amount}, "currency": "$_id.currency"}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userID": userID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"symbol":   "$symbol",
+				"currency": bson.M{"$ifNull": bson.A{"$priceBaught.currency", models.DefaultCurrency}},
+				"open":     bson.M{"$eq": bson.A{"$soldIndicator", "N"}},
+			},
+			"lots":     bson.M{"$sum": 1},
+			"quantity": bson.M{"$sum": "$quantity"},
+			"cost":     bson.M{"$sum": bson.M{"$add": bson.A{value("$priceBaught.amount"), amount("$buyFee.amount"), amount("$basisAdjustment.amount")}}},
+			"proceeds": bson.M{"$sum": bson.M{"$subtract": bson.A{value("$priceSold.amount"), amount("$sellFee.amount")}}},
+			"fees":     bson.M{"$sum": bson.M{"$add": bson.A{amount("$buyFee.amount"), amount("$sellFee.amount")}}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"symbol":   "$_id.symbol",
+			"currency": "$_id.currency",
+			"open":     "$_id.open",
+			"lots":     1,
+			"quantity": 1,
+			"cost":     money("$cost"),
+			"proceeds": money("$proceeds"),
+			"fees":     money("$fees"),
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "symbol", Value: 1}, {Key: "currency", Value: 1}, {Key: "open", Value: -1}}}},
+	}
+	cursor, err := getDBCollection("Lots", s.client).Aggregate(ctx, pipeline)
+	if err != nil {
+		logger.Error("Unable to summarize lots of user: " + err.Error())
+		return nil, err
+	}
+	summaries := []models.LotSummary{}
+	err = cursor.All(ctx, &summaries)
+	if err != nil {
+		logger.Error("Unable to decode lot summaries: " + err.Error())
+		return nil, err
+	}
+	return summaries, nil
+}
+
+func (s *MongoStore) findLots(email string, filter bson.M) ([]models.Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	return s.findLotsByUserID(ctx, userID, filter)
+}
+
+func (s *MongoStore) findLotsByUserID(ctx context.Context, userID string, filter bson.M) ([]models.Share, error) {
+	filter["userID"] = userID
+	collection := getDBCollection("Lots", s.client)
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		logger.Error("Unable to get lots of user: " + err.Error())
+		return nil, err
+	}
+
+	lots := []models.Share{}
+	err = cursor.All(ctx, &lots)
+	if err != nil {
+		logger.Error("Unable to decode lots of user: " + err.Error())
+		return nil, err
+	}
+	return lots, nil
+}
+
+func (s *MemoryStore) GetLots(email string) ([]models.Share, error) {
+	return s.findLots(email, func(lot *models.Share) bool { return true })
+}
+
+func (s *MemoryStore) GetHoldings(email string) ([]models.Share, error) {
+	return s.findLots(email, func(lot *models.Share) bool {
+		return lot.SoldIndicator == "N"
+	})
+}
+
+func (s *MemoryStore) GetHoldingsBySymbol(email string, symbol string) ([]models.Share, error) {
+	return s.findLots(email, func(lot *models.Share) bool {
+		return lot.Symbol == symbol && lot.SoldIndicator == "N"
+	})
+}
+
+func (s *MemoryStore) SummarizeLots(email string) ([]models.LotSummary, error) {
+	lots, err := s.GetLots(email)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []models.LotSummary{}
+	index := make(map[models.LotSummary]int)
+	for _, lot := range lots {
+		currency := models.NewMoney(0, lot.PriceBaught.Currency).Currency
+		key := models.LotSummary{Symbol: lot.Symbol, Currency: currency, Open: lot.SoldIndicator == "N"}
+		i, ok := index[key]
+		if !ok {
+			i = len(summaries)
+			index[key] = i
+			key.Cost = models.NewMoney(0, currency)
+			key.Proceeds = models.NewMoney(0, currency)
+			key.Fees = models.NewMoney(0, currency)
+			summaries = append(summaries, key)
+		}
+		summary := &summaries[i]
+		summary.Lots++
+		summary.Quantity += lot.Quantity
+		cost, err := lot.CostBasis()
+		if err != nil {
+			return nil, err
+		}
+		proceeds, err := lot.Proceeds()
+		if err != nil {
+			return nil, err
+		}
+		fees, err := lot.BuyFee.Add(lot.SellFee)
+		if err != nil {
+			return nil, err
+		}
+		if summary.Cost, err = summary.Cost.Add(cost); err != nil {
+			return nil, err
+		}
+		if summary.Proceeds, err = summary.Proceeds.Add(proceeds); err != nil {
+			return nil, err
+		}
+		if summary.Fees, err = summary.Fees.Add(fees); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		return a.Open && !b.Open
+	})
+	return summaries, nil
+}
+
+func (s *MemoryStore) findLots(email string, match func(lot *models.Share) bool) ([]models.Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return s.lotsOf(entry.id.Hex(), match), nil
+}
+
+// lotsOf copies the lots of a user that match. The caller holds s.mu.
+func (s *MemoryStore) lotsOf(userID string, match func(lot *models.Share) bool) []models.Share {
+	lots := []models.Share{}
+	for _, lot := range s.lots {
+		if lot.UserID == userID && match(lot) {
+			lots = append(lots, *lot)
+		}
+	}
+	return lots
+}
+
+// findLot returns the lot of a user with shareID. The caller holds s.mu.
+func (s *MemoryStore) findLot(userID string, shareID string) *models.Share {
+	for _, lot := range s.lots {
+		if lot.UserID == userID && lot.ShareID == shareID {
+			return lot
+		}
+	}
+	return nil
+}
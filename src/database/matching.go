@@ -0,0 +1,257 @@
+package src
+
+import (
+	"dbutil/src/models"
+	"math/big"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// lotFill is the quantity a sell takes from one lot.
+type lotFill struct {
+	lot      models.Share
+	quantity int
+}
+
+// maxQuantity is the most shares one trade or order may be for. It keeps
+// quantities far enough from the int64 range that prices times quantities
+// only overflow for absurd prices, which Money arithmetic then refuses.
+const maxQuantity = 1000000
+
+func validateBuy(share models.Share) error {
+	if share.Symbol == "" {
+		return ErrMissingSymbol
+	}
+	if share.Quantity <= 0 || share.Quantity > maxQuantity {
+		return ErrInvalidQuantity
+	}
+	if share.PriceBaught.IsNegative() {
+		return ErrInvalidPrice
+	}
+	if share.PriceBaught.IsZero() {
+		return ErrMissingExpectedPrice
+	}
+	return nil
+}
+
+// sellOrderForLot turns a sell of one lot by shareID into a SellOrder. lots
+// are the open lots of the user with that shareID; older clients send no
+// quantity and sell all of the lot.
+func sellOrderForLot(share models.Share, lots []models.Share) models.SellOrder {
+	quantity := share.Quantity
+	if quantity == 0 && len(lots) == 1 {
+		quantity = lots[0].Quantity
+	}
+	return models.SellOrder{
+		Symbol:    share.Symbol,
+		Quantity:  quantity,
+		PriceSold: share.PriceSold,
+		Matching:  models.MatchSpecificLot,
+		ShareIDs:  []string{share.ShareID},
+	}
+}
+
+// validateSellOrder checks order and fills in the default lot matching.
+func (p policy) validateSellOrder(order models.SellOrder) (models.SellOrder, error) {
+	if order.Matching == "" {
+		order.Matching = p.lotMatching
+	}
+	if !order.Matching.Valid() {
+		return order, ErrInvalidLotMatching
+	}
+	if order.Matching == models.MatchSpecificLot && len(order.ShareIDs) == 0 {
+		return order, ErrInvalidLotMatching
+	}
+	if order.Matching != models.MatchSpecificLot && order.Symbol == "" {
+		return order, ErrMissingSymbol
+	}
+	if order.Quantity <= 0 || order.Quantity > maxQuantity {
+		return order, ErrInvalidQuantity
+	}
+	if order.PriceSold.IsNegative() {
+		return order, ErrInvalidPrice
+	}
+	if order.PriceSold.IsZero() {
+		return order, ErrMissingExpectedPrice
+	}
+	return order, nil
+}
+
+// matchLots picks the open lots a sell consumes and how much of each, in the
+// order its matching policy prescribes.
+func matchLots(open []models.Share, order models.SellOrder) ([]lotFill, error) {
+	candidates := make([]models.Share, 0, len(open))
+	if order.Matching == models.MatchSpecificLot {
+		byID := make(map[string]models.Share, len(open))
+		for _, lot := range open {
+			byID[lot.ShareID] = lot
+		}
+		for _, shareID := range order.ShareIDs {
+			lot, ok := byID[shareID]
+			if !ok {
+				return nil, ErrShareNotOwned
+			}
+			delete(byID, shareID)
+			candidates = append(candidates, lot)
+		}
+	} else {
+		candidates = append(candidates, open...)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			switch order.Matching {
+			case models.MatchLIFO:
+				return a.DateBaught.After(b.DateBaught)
+			case models.MatchHighestCost:
+				if a.PriceBaught.Amount != b.PriceBaught.Amount {
+					return a.PriceBaught.Amount > b.PriceBaught.Amount
+				}
+			}
+			return a.DateBaught.Before(b.DateBaught)
+		})
+	}
+
+	fills := []lotFill{}
+	remaining := order.Quantity
+	for _, lot := range candidates {
+		if remaining == 0 {
+			break
+		}
+		quantity := lot.Quantity
+		if quantity > remaining {
+			quantity = remaining
+		}
+		if quantity <= 0 {
+			continue
+		}
+		fills = append(fills, lotFill{lot: lot, quantity: quantity})
+		remaining -= quantity
+	}
+	if remaining > 0 {
+		return nil, ErrInsufficientShares
+	}
+	return fills, nil
+}
+
+// fillSymbol is the symbol of the lots a sell consumes, which must all be the
+// same so the sell has one price.
+func fillSymbol(fills []lotFill) (string, error) {
+	symbol := fills[0].lot.Symbol
+	for _, fill := range fills[1:] {
+		if fill.lot.Symbol != symbol {
+			return "", ErrMixedSymbols
+		}
+	}
+	return symbol, nil
+}
+
+// sellLot sells fill.quantity of a lot at price as part of the sale saleID,
+// charging it fee. sold is the lot as it is stored afterwards when all of it
+// is sold, or a new lot split off it otherwise; remaining is then the unsold
+// rest of the original lot. A split divides the buy fee and basis adjustment
+// between the parts.
+func sellLot(fill lotFill, saleID string, price models.Money, fee models.Money, date time.Time) (remaining *models.Share, sold models.Share, err error) {
+	sold = fill.lot
+	if fill.quantity < fill.lot.Quantity {
+		sold.BuyFee = proportion(fill.lot.BuyFee, fill.quantity, fill.lot.Quantity)
+		sold.BasisAdjustment = proportion(fill.lot.BasisAdjustment, fill.quantity, fill.lot.Quantity)
+
+		rest := fill.lot
+		rest.Quantity -= fill.quantity
+		rest.BuyFee = fill.lot.BuyFee.Sub(sold.BuyFee)
+		rest.BasisAdjustment = fill.lot.BasisAdjustment.Sub(sold.BasisAdjustment)
+		remaining = &rest
+
+		sold.ShareID = primitive.NewObjectID().Hex()
+		sold.ParentShareID = fill.lot.ShareID
+		sold.Quantity = fill.quantity
+	}
+	sold.SoldIndicator = "Y"
+	sold.PriceSold = price
+	sold.SellFee = fee
+	sold.DateSold = &date
+	sold.SaleID = saleID
+	proceeds, err := sold.Proceeds()
+	if err != nil {
+		return nil, models.Share{}, err
+	}
+	cost, err := sold.CostBasis()
+	if err != nil {
+		return nil, models.Share{}, err
+	}
+	sold.RealizedGain = proceeds.Sub(cost)
+	return remaining, sold, nil
+}
+
+// splitFee divides the fee of a sale between the lots it consumes in
+// proportion to the quantity taken from each. The last lot takes what
+// rounding leaves over.
+func splitFee(fee models.Money, fills []lotFill) []models.Money {
+	quantity := 0
+	for _, fill := range fills {
+		quantity += fill.quantity
+	}
+	shares := make([]models.Money, len(fills))
+	left := fee
+	for i, fill := range fills {
+		if i == len(fills)-1 {
+			shares[i] = left
+			break
+		}
+		shares[i] = proportion(fee, fill.quantity, quantity)
+		left = left.Sub(shares[i])
+	}
+	return shares
+}
+
+// proportion is the part of amount that part out of whole accounts for,
+// rounded down to the minor unit. part is at most whole, so the result fits
+// even where amount times part would not.
+func proportion(amount models.Money, part int, whole int) models.Money {
+	share := new(big.Int).Mul(big.NewInt(amount.Amount), big.NewInt(int64(part)))
+	share.Quo(share, big.NewInt(int64(whole)))
+	return models.NewMoney(share.Int64(), amount.Currency)
+}
+
+func newSellResult(order models.SellOrder, fee models.Money) models.SellResult {
+	return models.SellResult{
+		SaleID:       primitive.NewObjectID().Hex(),
+		Symbol:       order.Symbol,
+		Quantity:     order.Quantity,
+		Price:        order.PriceSold,
+		Fee:          fee,
+		Proceeds:     models.NewMoney(0, order.PriceSold.Currency),
+		RealizedGain: models.NewMoney(0, order.PriceSold.Currency),
+		Lots:         []models.ConsumedLot{},
+	}
+}
+
+// addSoldLot records a sold lot on the result of a sell.
+func addSoldLot(result *models.SellResult, source string, sold models.Share) error {
+	if result.Symbol == "" {
+		result.Symbol = sold.Symbol
+	}
+	proceeds, err := sold.Proceeds()
+	if err != nil {
+		return err
+	}
+	result.Proceeds, err = result.Proceeds.Add(proceeds)
+	if err != nil {
+		return err
+	}
+	result.RealizedGain, err = result.RealizedGain.Add(sold.RealizedGain)
+	if err != nil {
+		return err
+	}
+	result.Lots = append(result.Lots, models.ConsumedLot{
+		ShareID:       sold.ShareID,
+		SourceShareID: source,
+		Quantity:      sold.Quantity,
+		PriceBaught:   sold.PriceBaught,
+		PriceSold:     sold.PriceSold,
+		Fee:           sold.SellFee,
+		RealizedGain:  sold.RealizedGain,
+	})
+	return nil
+}
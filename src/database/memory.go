@@ -0,0 +1,405 @@
+package src
+
+import (
+	"dbutil/src/config"
+	"dbutil/src/fees"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/quotes"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryStore is a Store that keeps every document in process memory. It is
+// safe for concurrent use and is meant for local runs and tests where no
+// MongoDB deployment is available. A single mutex guards all collections so
+// that multi-step operations are atomic, just like a transaction would be.
+type MemoryStore struct {
+	policy
+	mu            sync.Mutex
+	users         map[string]*memoryUser
+	confirmations map[string]models.ConfirmationToken
+	refreshTokens map[string]*models.RefreshToken
+	lots          []*models.Share
+	ledger        []models.LedgerEntry
+	holds         []*models.Hold
+	orders        []*models.Order
+	watchlists    []*models.Watchlist
+	alerts        []*models.Alert
+	statusHistory []models.StatusChange
+	idempotency   map[string]models.IdempotencyRecord
+	actions       map[string]models.CorporateAction
+}
+
+type memoryUser struct {
+	id   primitive.ObjectID
+	user models.User
+}
+
+func NewMemoryStore(appConfig config.Configuration, provider quotes.Provider) *MemoryStore {
+	return &MemoryStore{
+		policy:        newPolicy(appConfig, provider),
+		users:         make(map[string]*memoryUser),
+		confirmations: make(map[string]models.ConfirmationToken),
+		refreshTokens: make(map[string]*models.RefreshToken),
+		idempotency:   make(map[string]models.IdempotencyRecord),
+		actions:       make(map[string]models.CorporateAction),
+	}
+}
+
+// available is the part of the balance of user that no hold reserves.
+func available(user *models.User) models.Money {
+	return user.Balance.Sub(user.HeldBalance)
+}
+
+// debit takes amount off the balance of user unless that would spend more
+// than is available, and takes released off their held balance. released is
+// held money the debit may use.
+func debit(user *models.User, amount models.Money, released models.Money) error {
+	if !user.Balance.SameCurrency(amount) {
+		return ErrCurrencyMismatch
+	}
+	if available(user).LessThan(amount.Sub(released)) {
+		logger.Error("Insufficient balance to deduct the amount")
+		return ErrInsufficientFunds
+	}
+	user.Balance = user.Balance.Sub(amount)
+	user.HeldBalance = user.HeldBalance.Sub(released)
+	return nil
+}
+
+func (s *MemoryStore) GetUserHash(email string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		logger.Error("Unable to get user credentials: " + mongo.ErrNoDocuments.Error())
+		return "", mongo.ErrNoDocuments
+	}
+	return entry.user.Hash, nil
+}
+
+func (s *MemoryStore) GetDbIdByEmail(email string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		logger.Error("Unable to get Id: " + mongo.ErrNoDocuments.Error())
+		return "", mongo.ErrNoDocuments
+	}
+	return entry.id.Hex(), nil
+}
+
+func (s *MemoryStore) CheckIfEmailExists(email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[email]
+	return ok, nil
+}
+
+func (s *MemoryStore) SaveNewUser(user models.User) (*mongo.InsertOneResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.Email]; ok {
+		return nil, ErrEmailTaken
+	}
+	user.EmailConfimed = false
+	user.Balance = models.NewMoney(0, user.Balance.Currency)
+	user.HeldBalance = models.NewMoney(0, user.Balance.Currency)
+	user.AccountStatus = models.AccountStatusPending
+	user.CreatedDate = s.clock.Now()
+	user.Shares = nil
+
+	id := primitive.NewObjectID()
+	s.users[user.Email] = &memoryUser{id: id, user: user}
+	logger.Info("Successfully saved user data - " + id.Hex())
+
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (s *MemoryStore) GetUserData(email string) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		logger.Error("User does not exist. " + mongo.ErrNoDocuments.Error())
+		return models.User{}, mongo.ErrNoDocuments
+	}
+	user := entry.user
+	user.Shares = s.lotsOf(entry.id.Hex(), func(lot *models.Share) bool { return true })
+	return user, nil
+}
+
+func (s *MemoryStore) ListUserEmails() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*memoryUser, 0, len(s.users))
+	for _, entry := range s.users {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].id.Hex() < entries[j].id.Hex()
+	})
+	emails := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		emails = append(emails, entry.user.Email)
+	}
+	return emails, nil
+}
+
+func (s *MemoryStore) DeleteUserFromDB(email string) (*mongo.DeleteResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &mongo.DeleteResult{}
+	entry, ok := s.users[email]
+	if !ok {
+		return result, nil
+	}
+
+	userID := entry.id.Hex()
+	lots := s.lots[:0]
+	for _, lot := range s.lots {
+		if lot.UserID != userID {
+			lots = append(lots, lot)
+		}
+	}
+	s.lots = lots
+	for _, order := range s.orders {
+		if order.UserID == userID && order.Status == models.OrderOpen {
+			closeMemoryOrder(order, models.OrderCancelled, s.clock.Now())
+		}
+	}
+	watchlists := s.watchlists[:0]
+	for _, watchlist := range s.watchlists {
+		if watchlist.UserID != userID {
+			watchlists = append(watchlists, watchlist)
+		}
+	}
+	s.watchlists = watchlists
+	now := s.clock.Now()
+	for _, alert := range s.alerts {
+		if alert.UserID == userID && alert.Status == models.AlertActive {
+			alert.Status = models.AlertCancelled
+			alert.CancelledAt = &now
+		}
+	}
+
+	delete(s.users, email)
+	result.DeletedCount = 1
+	return result, nil
+}
+
+func (s *MemoryStore) SaveBaughtShare(email string, share models.Share) (*mongo.UpdateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	err := validateBuy(share)
+	if err != nil {
+		return nil, err
+	}
+	share.PriceBaught, err = s.executionPrice(share.Symbol, share.PriceBaught, true)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.buyShare(entry, share, models.Money{})
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+// buyShare debits the cost of share at share.PriceBaught and records it as a
+// new lot of entry, using released held money. The caller holds s.mu.
+func (s *MemoryStore) buyShare(entry *memoryUser, share models.Share, released models.Money) (models.Share, error) {
+	share.UserID = entry.id.Hex()
+	share.ShareID = primitive.NewObjectID().Hex()
+	share.SoldIndicator = "N"
+	share.DateBaught = s.clock.Now()
+	traded, err := s.tradedSince(share.UserID, share.PriceBaught, fees.MonthStart(share.DateBaught))
+	if err != nil {
+		return models.Share{}, err
+	}
+	share.BuyFee, err = s.tradeFee(share.PriceBaught, share.Quantity, traded)
+	if err != nil {
+		return models.Share{}, err
+	}
+
+	change, err := buyChange(share)
+	if err != nil {
+		return models.Share{}, err
+	}
+	change.Release = released
+	_, err = s.applyBalanceChange(entry, change)
+	if err != nil {
+		return models.Share{}, err
+	}
+	s.lots = append(s.lots, &share)
+	return share, nil
+}
+
+func (s *MemoryStore) UpdateShareToSold(email string, share models.Share) (*mongo.UpdateResult, error) {
+	lots, err := s.findLots(email, func(lot *models.Share) bool {
+		return lot.ShareID == share.ShareID && lot.SoldIndicator == "N"
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.SellShares(email, sellOrderForLot(share, lots))
+	if err != nil {
+		return nil, err
+	}
+	count := int64(len(result.Lots))
+	return &mongo.UpdateResult{MatchedCount: count, ModifiedCount: count}, nil
+}
+
+func (s *MemoryStore) SellShares(email string, order models.SellOrder) (models.SellResult, error) {
+	order, err := s.validateSellOrder(order)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.SellResult{}, mongo.ErrNoDocuments
+	}
+	expected := order.PriceSold
+	return s.sellShares(entry, order, func(symbol string) (models.Money, error) {
+		return s.executionPrice(symbol, expected, false)
+	})
+}
+
+// sellShares sells the lots of entry a validated order consumes at the price
+// priceOf gives for their symbol. The caller holds s.mu.
+func (s *MemoryStore) sellShares(entry *memoryUser, order models.SellOrder, priceOf func(symbol string) (models.Money, error)) (models.SellResult, error) {
+	specific := make(map[string]bool, len(order.ShareIDs))
+	for _, shareID := range order.ShareIDs {
+		specific[shareID] = true
+	}
+	userID := entry.id.Hex()
+	open := s.lotsOf(userID, func(lot *models.Share) bool {
+		if lot.SoldIndicator != "N" || order.Symbol != "" && lot.Symbol != order.Symbol {
+			return false
+		}
+		return order.Matching != models.MatchSpecificLot || specific[lot.ShareID]
+	})
+	fills, err := matchLots(open, order)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	symbol, err := fillSymbol(fills)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	order.PriceSold, err = priceOf(symbol)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	if !entry.user.Balance.SameCurrency(order.PriceSold) {
+		return models.SellResult{}, ErrCurrencyMismatch
+	}
+
+	traded, err := s.tradedSince(userID, order.PriceSold, fees.MonthStart(s.clock.Now()))
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	fee, err := s.tradeFee(order.PriceSold, order.Quantity, traded)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+
+	result := newSellResult(order, fee)
+	lotFees := splitFee(result.Fee, fills)
+	date := s.clock.Now()
+	remainders := make([]*models.Share, len(fills))
+	solds := make([]models.Share, len(fills))
+	for i, fill := range fills {
+		remainders[i], solds[i], err = sellLot(fill, result.SaleID, order.PriceSold, lotFees[i], date)
+		if err != nil {
+			return models.SellResult{}, err
+		}
+		err = addSoldLot(&result, fill.lot.ShareID, solds[i])
+		if err != nil {
+			return models.SellResult{}, err
+		}
+	}
+
+	// The proceeds are credited before any lot changes, so a refused credit
+	// leaves the lots as they were.
+	_, err = s.applyBalanceChange(entry, sellChange(order, result))
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	for i, fill := range fills {
+		lot := s.findLot(userID, fill.lot.ShareID)
+		if remainders[i] == nil {
+			*lot = solds[i]
+		} else {
+			lot.Quantity = remainders[i].Quantity
+			lot.BuyFee = remainders[i].BuyFee
+			lot.BasisAdjustment = remainders[i].BasisAdjustment
+			s.lots = append(s.lots, &solds[i])
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetSoldIndicator(email string, shareID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		logger.Error("Unable to get sold indicator for the share " + mongo.ErrNoDocuments.Error())
+		return "", mongo.ErrNoDocuments
+	}
+	lot := s.findLot(entry.id.Hex(), shareID)
+	if lot == nil {
+		return "", nil
+	}
+	return lot.SoldIndicator, nil
+}
+
+func (s *MemoryStore) GetBalance(email string) (models.AccountBalance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.AccountBalance{}, mongo.ErrNoDocuments
+	}
+	return models.Balance{Balance: entry.user.Balance, Held: entry.user.HeldBalance}.Summary(), nil
+}
+
+func (s *MemoryStore) UpdateBalance(email string, change models.BalanceChange) (models.LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		logger.Error("Unable to get current balance " + mongo.ErrNoDocuments.Error())
+		return models.LedgerEntry{}, mongo.ErrNoDocuments
+	}
+	ledgerEntry, err := s.applyBalanceChange(entry, change)
+	if err != nil {
+		return models.LedgerEntry{}, err
+	}
+	logger.Info("Balance has been updated successfully.")
+	return ledgerEntry, nil
+}
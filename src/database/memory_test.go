@@ -0,0 +1,498 @@
+package src_test
+
+import (
+	"dbutil/src/config"
+	db "dbutil/src/database"
+	"dbutil/src/models"
+	"dbutil/src/testutil"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// newWorld returns a world that charges testutil.Fees and allows 0.50% of
+// slippage.
+func newWorld(t *testing.T) *testutil.World {
+	t.Helper()
+	return testutil.NewWorld(t, config.Configuration{
+		Quotes: config.QuotesConfig{MaxSlippageBps: 50},
+		Fees:   testutil.Fees,
+	})
+}
+
+func placeLimitBuy(t *testing.T, w *testutil.World, symbol string, quantity int, limit string) models.Order {
+	t.Helper()
+	limitPrice := testutil.USD(t, limit)
+	order, err := w.Store.PlaceOrder(testutil.Email, models.Order{
+		Side:       models.OrderBuy,
+		Type:       models.OrderLimit,
+		Symbol:     symbol,
+		Quantity:   quantity,
+		LimitPrice: &limitPrice,
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	return order
+}
+
+func TestSaveNewUserRefusesTakenEmail(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "100.00")
+
+	_, err := w.Store.SaveNewUser(models.User{Email: testutil.Email})
+	if !errors.Is(err, db.ErrEmailTaken) {
+		t.Fatalf("second registration returned %v, want ErrEmailTaken", err)
+	}
+	w.AssertBalance(t, testutil.Email, "100.00", "100.00")
+}
+
+func TestNewUserIsPendingAndUnconfirmed(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "0.00")
+
+	user, err := w.Store.GetUserData(testutil.Email)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	if user.EmailConfimed || user.AccountStatus != models.AccountStatusPending || !user.CreatedDate.Equal(testutil.Start) {
+		t.Fatalf("user is %+v, want a pending, unconfirmed user created at %s", user, testutil.Start)
+	}
+	id, err := w.Store.GetDbIdByEmail(testutil.Email)
+	if err != nil || id == "" {
+		t.Fatalf("GetDbIdByEmail returned %q, %v; want the id of the user", id, err)
+	}
+	exists, err := w.Store.CheckIfEmailExists("nobody@example.com")
+	if err != nil || exists {
+		t.Fatalf("CheckIfEmailExists of an unknown email returned %v, %v", exists, err)
+	}
+}
+
+func TestDeleteUserForgetsEmail(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "0.00")
+
+	result, err := w.Store.DeleteUserFromDB(testutil.Email)
+	if err != nil || result.DeletedCount != 1 {
+		t.Fatalf("DeleteUserFromDB returned %+v, %v; want one user deleted", result, err)
+	}
+	exists, err := w.Store.CheckIfEmailExists(testutil.Email)
+	if err != nil || exists {
+		t.Fatalf("CheckIfEmailExists after delete returned %v, %v", exists, err)
+	}
+}
+
+func TestBuyDebitsPriceAndFee(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+
+	w.Buy(t, testutil.Email, "AAPL", 2)
+
+	w.AssertBalance(t, testutil.Email, "699.00", "699.00")
+	lots := w.Holdings(t, testutil.Email)
+	if len(lots) != 1 || lots[0].Quantity != 2 || lots[0].BuyFee != testutil.USD(t, "1.00") {
+		t.Fatalf("holdings are %+v, want one lot of 2 with a 1.00 fee", lots)
+	}
+	if !lots[0].DateBaught.Equal(testutil.Start) {
+		t.Fatalf("lot was bought at %s, want the store clock %s", lots[0].DateBaught, testutil.Start)
+	}
+}
+
+func TestBuyRefusesMoreThanAvailable(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "100.00")
+
+	_, err := w.Store.SaveBaughtShare(testutil.Email, models.Share{Symbol: "AAPL", Quantity: 1, PriceBaught: testutil.USD(t, "150.00")})
+	if !errors.Is(err, db.ErrInsufficientFunds) {
+		t.Fatalf("buy returned %v, want ErrInsufficientFunds", err)
+	}
+	if lots := w.Holdings(t, testutil.Email); len(lots) != 0 {
+		t.Fatalf("refused buy left lots %+v", lots)
+	}
+	w.AssertBalance(t, testutil.Email, "100.00", "100.00")
+}
+
+func TestTradesRefuseQuantityAboveMaximum(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+
+	_, err := w.Store.SaveBaughtShare(testutil.Email, models.Share{Symbol: "AAPL", Quantity: 1000001, PriceBaught: testutil.USD(t, "150.00")})
+	if !errors.Is(err, db.ErrInvalidQuantity) {
+		t.Fatalf("buy of 1000001 shares returned %v, want ErrInvalidQuantity", err)
+	}
+	_, err = w.Store.SellShares(testutil.Email, models.SellOrder{Symbol: "AAPL", Quantity: 1000001, PriceSold: testutil.USD(t, "150.00"), Matching: models.MatchFIFO})
+	if !errors.Is(err, db.ErrInvalidQuantity) {
+		t.Fatalf("sell of 1000001 shares returned %v, want ErrInvalidQuantity", err)
+	}
+	w.AssertBalance(t, testutil.Email, "1000.00", "1000.00")
+}
+
+func TestBuyRefusesPriceThatOverflows(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	price := models.NewMoney(math.MaxInt64/1000, "USD")
+	w.Quotes.Set("AAPL", price)
+
+	_, err := w.Store.SaveBaughtShare(testutil.Email, models.Share{Symbol: "AAPL", Quantity: 1000000, PriceBaught: price})
+	if !errors.Is(err, models.ErrMoneyOverflow) {
+		t.Fatalf("buy worth more than int64 returned %v, want ErrMoneyOverflow", err)
+	}
+	w.AssertBalance(t, testutil.Email, "1000.00", "1000.00")
+}
+
+func TestTradesNeedExpectedPrice(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	w.Buy(t, testutil.Email, "AAPL", 1)
+
+	_, err := w.Store.SaveBaughtShare(testutil.Email, models.Share{Symbol: "AAPL", Quantity: 1})
+	if !errors.Is(err, db.ErrMissingExpectedPrice) {
+		t.Fatalf("buy without a price returned %v, want ErrMissingExpectedPrice", err)
+	}
+	_, err = w.Store.SellShares(testutil.Email, models.SellOrder{Symbol: "AAPL", Quantity: 1, Matching: models.MatchFIFO})
+	if !errors.Is(err, db.ErrMissingExpectedPrice) {
+		t.Fatalf("sell without a price returned %v, want ErrMissingExpectedPrice", err)
+	}
+	w.AssertBalance(t, testutil.Email, "849.00", "849.00")
+}
+
+func TestTradesRefuseSlippage(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	w.Buy(t, testutil.Email, "AAPL", 1)
+
+	tests := []struct {
+		name     string
+		buying   bool
+		expected models.Money
+		err      error
+	}{
+		{"buy within 0.50%", true, testutil.USD(t, "149.30"), nil},
+		{"buy above 0.50%", true, testutil.USD(t, "149.00"), db.ErrSlippageExceeded},
+		{"sell above 0.50%", false, testutil.USD(t, "151.00"), db.ErrSlippageExceeded},
+		{"sell of the largest price", false, models.NewMoney(math.MaxInt64, "USD"), db.ErrSlippageExceeded},
+	}
+	for _, test := range tests {
+		var err error
+		if test.buying {
+			_, err = w.Store.SaveBaughtShare(testutil.Email, models.Share{Symbol: "AAPL", Quantity: 1, PriceBaught: test.expected})
+		} else {
+			_, err = w.Store.SellShares(testutil.Email, models.SellOrder{Symbol: "AAPL", Quantity: 1, PriceSold: test.expected, Matching: models.MatchFIFO})
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s returned %v, want %v", test.name, err, test.err)
+		}
+	}
+	lots := w.Holdings(t, testutil.Email)
+	if len(lots) != 2 || lots[1].PriceBaught != testutil.USD(t, "150.00") {
+		t.Fatalf("holdings are %+v, want a second lot bought at the market price", lots)
+	}
+}
+
+func TestSellSplitsPartlySoldLot(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	w.Buy(t, testutil.Email, "AAPL", 4)
+
+	result := w.Sell(t, testutil.Email, "AAPL", 1)
+
+	if len(result.Lots) != 1 || result.Lots[0].Quantity != 1 {
+		t.Fatalf("sale consumed %+v, want 1 share of one lot", result.Lots)
+	}
+	lots := w.Holdings(t, testutil.Email)
+	if len(lots) != 1 || lots[0].Quantity != 3 {
+		t.Fatalf("holdings are %+v, want 3 shares left", lots)
+	}
+	// 1000.00 - 600.00 - 1.00 to buy, + 150.00 - 1.00 to sell.
+	w.AssertBalance(t, testutil.Email, "548.00", "548.00")
+}
+
+func TestLegacySellWithoutQuantitySellsWholeLot(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	w.Buy(t, testutil.Email, "AAPL", 3)
+	shareID := w.Holdings(t, testutil.Email)[0].ShareID
+
+	_, err := w.Store.UpdateShareToSold(testutil.Email, models.Share{ShareID: shareID, PriceSold: testutil.USD(t, "150.00")})
+	if err != nil {
+		t.Fatalf("UpdateShareToSold: %v", err)
+	}
+
+	if lots := w.Holdings(t, testutil.Email); len(lots) != 0 {
+		t.Fatalf("holdings are %+v, want the whole lot sold", lots)
+	}
+	indicator, err := w.Store.GetSoldIndicator(testutil.Email, shareID)
+	if err != nil || indicator != "Y" {
+		t.Fatalf("sold indicator is %q, %v; want Y", indicator, err)
+	}
+}
+
+func TestSellOfBlockedAccountKeepsLots(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	w.Buy(t, testutil.Email, "AAPL", 4)
+	w.SetStatus(t, testutil.Email, models.AccountStatusFrozen)
+
+	_, err := w.Store.SellShares(testutil.Email, models.SellOrder{Symbol: "AAPL", Quantity: 1, PriceSold: testutil.USD(t, "150.00")})
+	if !errors.Is(err, db.ErrAccountBlocked) {
+		t.Fatalf("sell returned %v, want ErrAccountBlocked", err)
+	}
+
+	lots, err := w.Store.GetLots(testutil.Email)
+	if err != nil {
+		t.Fatalf("GetLots: %v", err)
+	}
+	if len(lots) != 1 || lots[0].Quantity != 4 || lots[0].SoldIndicator != "N" {
+		t.Fatalf("lots are %+v, want the lot of 4 untouched", lots)
+	}
+	w.AssertBalance(t, testutil.Email, "399.00", "399.00")
+}
+
+func TestSettledWithdrawalDebitsBalance(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "500.00")
+
+	hold, err := w.Store.PlaceHold(testutil.Email, models.Hold{Type: models.HoldWithdrawal, Amount: testutil.USD(t, "200.00")})
+	if err != nil {
+		t.Fatalf("PlaceHold: %v", err)
+	}
+	w.AssertBalance(t, testutil.Email, "500.00", "300.00")
+
+	settled, err := w.Store.SettleHold(testutil.Email, hold.ID.Hex())
+	if err != nil {
+		t.Fatalf("SettleHold: %v", err)
+	}
+	if settled.Status != models.HoldSettled {
+		t.Fatalf("hold is %s, want settled", settled.Status)
+	}
+	w.AssertBalance(t, testutil.Email, "300.00", "300.00")
+
+	_, err = w.Store.CancelHold(testutil.Email, hold.ID.Hex())
+	if !errors.Is(err, db.ErrHoldNotPending) {
+		t.Fatalf("cancelling a settled hold returned %v, want ErrHoldNotPending", err)
+	}
+}
+
+func TestFrozenAccountCanNotWithdraw(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "500.00")
+	hold, err := w.Store.PlaceHold(testutil.Email, models.Hold{Type: models.HoldWithdrawal, Amount: testutil.USD(t, "100.00")})
+	if err != nil {
+		t.Fatalf("PlaceHold: %v", err)
+	}
+	w.SetStatus(t, testutil.Email, models.AccountStatusFrozen)
+
+	_, err = w.Store.PlaceHold(testutil.Email, models.Hold{Type: models.HoldWithdrawal, Amount: testutil.USD(t, "100.00")})
+	if !errors.Is(err, db.ErrAccountBlocked) {
+		t.Fatalf("PlaceHold returned %v, want ErrAccountBlocked", err)
+	}
+	_, err = w.Store.SettleHold(testutil.Email, hold.ID.Hex())
+	if !errors.Is(err, db.ErrAccountBlocked) {
+		t.Fatalf("SettleHold returned %v, want ErrAccountBlocked", err)
+	}
+	w.AssertBalance(t, testutil.Email, "500.00", "400.00")
+}
+
+func TestClosedAccountMayWithdrawRest(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "500.00")
+	w.SetStatus(t, testutil.Email, models.AccountStatusClosed)
+
+	hold, err := w.Store.PlaceHold(testutil.Email, models.Hold{Type: models.HoldWithdrawal, Amount: testutil.USD(t, "500.00")})
+	if err != nil {
+		t.Fatalf("PlaceHold: %v", err)
+	}
+	_, err = w.Store.SettleHold(testutil.Email, hold.ID.Hex())
+	if err != nil {
+		t.Fatalf("SettleHold: %v", err)
+	}
+	w.AssertBalance(t, testutil.Email, "0.00", "0.00")
+}
+
+func TestHoldsOfOrdersAreNotWithdrawals(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	order := placeLimitBuy(t, w, "AAPL", 2, "140.00")
+
+	_, err := w.Store.SettleHold(testutil.Email, order.HoldID)
+	if !errors.Is(err, db.ErrHoldType) {
+		t.Fatalf("SettleHold returned %v, want ErrHoldType", err)
+	}
+	_, err = w.Store.CancelHold(testutil.Email, order.HoldID)
+	if !errors.Is(err, db.ErrHoldType) {
+		t.Fatalf("CancelHold returned %v, want ErrHoldType", err)
+	}
+	// 280.00 and a 1.00 fee stay reserved for the order.
+	w.AssertBalance(t, testutil.Email, "1000.00", "719.00")
+}
+
+func TestFilledOrderSettlesItsHold(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	order := placeLimitBuy(t, w, "AAPL", 2, "150.00")
+
+	filled, err := w.Store.FillOrder(testutil.Email, order.ID.Hex(), testutil.USD(t, "145.00"))
+	if err != nil {
+		t.Fatalf("FillOrder: %v", err)
+	}
+
+	if filled.Status != models.OrderFilled || filled.ReferenceID == "" {
+		t.Fatalf("order is %+v, want it filled with a lot", filled)
+	}
+	holds, err := w.Store.GetHolds(testutil.Email, models.HoldPending)
+	if err != nil || len(holds) != 0 {
+		t.Fatalf("pending holds are %+v, %v; want none", holds, err)
+	}
+	// 1000.00 - 290.00 - 1.00; the rest of the reservation is available again.
+	w.AssertBalance(t, testutil.Email, "709.00", "709.00")
+}
+
+func TestCancelledOrderReleasesItsHold(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	order := placeLimitBuy(t, w, "AAPL", 2, "150.00")
+
+	_, err := w.Store.CancelOrder(testutil.Email, order.ID.Hex())
+	if err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	_, err = w.Store.FillOrder(testutil.Email, order.ID.Hex(), testutil.USD(t, "145.00"))
+	if !errors.Is(err, db.ErrOrderNotOpen) {
+		t.Fatalf("filling a cancelled order returned %v, want ErrOrderNotOpen", err)
+	}
+	w.AssertBalance(t, testutil.Email, "1000.00", "1000.00")
+}
+
+func TestFeeTiersFollowTheMonthOfTheClock(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "10000.00")
+
+	// 3000.00 at 0.10%, then 0.05% once 1000.00 was traded this month.
+	w.Buy(t, testutil.Email, "MSFT", 10)
+	w.Buy(t, testutil.Email, "MSFT", 10)
+	// The volume of March does not count in April.
+	w.Clock.Set(time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC))
+	w.Buy(t, testutil.Email, "MSFT", 10)
+
+	lots := w.Holdings(t, testutil.Email)
+	want := []string{"3.00", "1.50", "3.00"}
+	if len(lots) != len(want) {
+		t.Fatalf("holdings are %+v, want %d lots", lots, len(want))
+	}
+	for i, lot := range lots {
+		if lot.BuyFee != testutil.USD(t, want[i]) {
+			t.Errorf("lot %d paid a fee of %s, want %s", i, lot.BuyFee, want[i])
+		}
+	}
+}
+
+func TestSplitAdjustsLotsAndCancelsOrders(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	w.Buy(t, testutil.Email, "AAPL", 3)
+	order := placeLimitBuy(t, w, "AAPL", 1, "140.00")
+
+	action, err := w.Store.ApplyCorporateAction(models.CorporateAction{
+		ID: "aapl-split", Type: models.CorporateSplit, Symbol: "AAPL", SplitTo: 2, SplitFrom: 1,
+	})
+	if err != nil {
+		t.Fatalf("ApplyCorporateAction: %v", err)
+	}
+
+	if action.Lots != 1 || action.CancelledOrders != 1 {
+		t.Fatalf("split adjusted %d lots and cancelled %d orders, want 1 and 1", action.Lots, action.CancelledOrders)
+	}
+	lots := w.Holdings(t, testutil.Email)
+	if len(lots) != 1 || lots[0].Quantity != 6 || lots[0].PriceBaught != testutil.USD(t, "75.00") {
+		t.Fatalf("holdings are %+v, want 6 shares at 75.00", lots)
+	}
+	orders, err := w.Store.GetOrders(testutil.Email, models.OrderCancelled)
+	if err != nil || len(orders) != 1 || orders[0].ID != order.ID || orders[0].Reason == "" {
+		t.Fatalf("cancelled orders are %+v, %v; want the open order with a reason", orders, err)
+	}
+	w.AssertBalance(t, testutil.Email, "549.00", "549.00")
+}
+
+func TestFractionalSplitChangesNothing(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	w.Buy(t, testutil.Email, "AAPL", 2)
+	w.Buy(t, testutil.Email, "AAPL", 1)
+	placeLimitBuy(t, w, "AAPL", 1, "140.00")
+
+	_, err := w.Store.ApplyCorporateAction(models.CorporateAction{
+		ID: "aapl-split", Type: models.CorporateSplit, Symbol: "AAPL", SplitTo: 3, SplitFrom: 2,
+	})
+	if !errors.Is(err, db.ErrFractionalSplit) {
+		t.Fatalf("ApplyCorporateAction returned %v, want ErrFractionalSplit", err)
+	}
+
+	lots := w.Holdings(t, testutil.Email)
+	if len(lots) != 2 || lots[0].Quantity != 2 || lots[1].Quantity != 1 {
+		t.Fatalf("holdings are %+v, want the lots of 2 and 1 untouched", lots)
+	}
+	orders, err := w.Store.GetOrders(testutil.Email, models.OrderOpen)
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("open orders are %+v, %v; want the order still open", orders, err)
+	}
+	actions, err := w.Store.GetCorporateActions("AAPL")
+	if err != nil || len(actions) != 0 {
+		t.Fatalf("corporate actions are %+v, %v; want none applied", actions, err)
+	}
+}
+
+func TestDividendPaysSharesHeldOnRecordDate(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	w.Buy(t, testutil.Email, "AAPL", 3)
+	recordDate := testutil.Start
+
+	// A split after the record date must not double the dividend.
+	w.Clock.Advance(48 * time.Hour)
+	_, err := w.Store.ApplyCorporateAction(models.CorporateAction{
+		ID: "aapl-split", Type: models.CorporateSplit, Symbol: "AAPL", SplitTo: 2, SplitFrom: 1,
+	})
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	amount := testutil.USD(t, "0.50")
+	dividend := models.CorporateAction{
+		ID: "aapl-dividend", Type: models.CorporateDividend, Symbol: "AAPL", AmountPerShare: &amount, RecordDate: &recordDate,
+	}
+	action, err := w.Store.ApplyCorporateAction(dividend)
+	if err != nil {
+		t.Fatalf("dividend: %v", err)
+	}
+
+	if action.Holders != 1 || action.Paid == nil || *action.Paid != testutil.USD(t, "1.50") {
+		t.Fatalf("dividend paid %d holders %v, want 1 holder 1.50", action.Holders, action.Paid)
+	}
+	w.AssertBalance(t, testutil.Email, "550.50", "550.50")
+
+	_, err = w.Store.ApplyCorporateAction(dividend)
+	if !errors.Is(err, db.ErrActionApplied) {
+		t.Fatalf("paying the dividend again returned %v, want ErrActionApplied", err)
+	}
+	w.AssertBalance(t, testutil.Email, "550.50", "550.50")
+}
+
+func TestDividendSkipsSharesBoughtAfterRecordDate(t *testing.T) {
+	w := newWorld(t)
+	w.Register(t, testutil.Email, "1000.00")
+	recordDate := testutil.Start
+	w.Clock.Advance(48 * time.Hour)
+	w.Buy(t, testutil.Email, "AAPL", 3)
+
+	amount := testutil.USD(t, "0.50")
+	action, err := w.Store.ApplyCorporateAction(models.CorporateAction{
+		ID: "aapl-dividend", Type: models.CorporateDividend, Symbol: "AAPL", AmountPerShare: &amount, RecordDate: &recordDate,
+	})
+	if err != nil {
+		t.Fatalf("ApplyCorporateAction: %v", err)
+	}
+	if action.Holders != 0 {
+		t.Fatalf("dividend paid %d holders, want none", action.Holders)
+	}
+	w.AssertBalance(t, testutil.Email, "549.00", "549.00")
+}
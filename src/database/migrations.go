@@ -0,0 +1,286 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations are one-off data conversions run with "dbutil migrate <name>".
+// Each one is idempotent and returns the number of documents it rewrote.
+// They only apply to MongoDB, since the in-memory store never holds data
+// written by older versions.
+var migrations = map[string]func(s *MongoStore) (int64, error){
+	"money":      (*MongoStore).migrateMoney,
+	"lots":       (*MongoStore).migrateLots,
+	"ledger":     (*MongoStore).migrateLedger,
+	"timestamps": (*MongoStore).migrateTimestamps,
+}
+
+// MigrationNames lists the migrations RunMigration accepts.
+func MigrationNames() []string {
+	names := make([]string, 0, len(migrations))
+	for name := range migrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func RunMigration(store Store, name string) (int64, error) {
+	mongoStore, ok := store.(*MongoStore)
+	if !ok {
+		return 0, errors.New("Migrations can only run against MongoDB")
+	}
+	migration, ok := migrations[name]
+	if !ok {
+		return 0, fmt.Errorf("Unknown migration %q", name)
+	}
+	logger.Info("Running migration " + name)
+	return migration(mongoStore)
+}
+
+// userDocument is a user together with its _id, for migrations that rewrite
+// users one by one.
+type userDocument struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	models.User `bson:",inline"`
+}
+
+// lotDocument is a lot together with its _id.
+type lotDocument struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	models.Share `bson:",inline"`
+}
+
+// migrateMoney converts the float64 balances and share prices written by
+// older versions into Money documents. Decoding already rounds the floats to
+// whole cents, so each matching user is simply written back.
+func (s *MongoStore) migrateMoney() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	numeric := bson.M{"$type": bson.A{"double", "int", "long", "null"}}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"balance": bson.M{"$exists": false}},
+		bson.M{"balance": numeric},
+		bson.M{"shares.priceBaught": numeric},
+		bson.M{"shares.priceSold": numeric},
+	}}
+
+	collection := getDBCollection("Users", s.client)
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		logger.Error("Unable to find users to migrate: " + err.Error())
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := int64(0)
+	for cursor.Next(ctx) {
+		user := userDocument{}
+		err = cursor.Decode(&user)
+		if err != nil {
+			logger.Error("Unable to decode user: " + err.Error())
+			return migrated, err
+		}
+
+		set := bson.M{"balance": models.NewMoney(user.Balance.Amount, user.Balance.Currency)}
+		if user.Shares != nil {
+			for i := range user.Shares {
+				share := &user.Shares[i]
+				share.PriceBaught = models.NewMoney(share.PriceBaught.Amount, share.PriceBaught.Currency)
+				share.PriceSold = models.NewMoney(share.PriceSold.Amount, share.PriceSold.Currency)
+			}
+			set["shares"] = user.Shares
+		}
+
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": set})
+		if err != nil {
+			logger.Error("Unable to migrate user " + user.Email + ": " + err.Error())
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+// migrateLots moves the shares embedded in user documents into the Lots
+// collection and removes them from the users. Lots are upserted by user and
+// shareID, so a run that stopped halfway can simply be repeated. Legacy float
+// prices are converted to Money on the way.
+func (s *MongoStore) migrateLots() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	users := getDBCollection("Users", s.client)
+	lots := getDBCollection("Lots", s.client)
+	cursor, err := users.Find(ctx, bson.M{"shares.0": bson.M{"$exists": true}})
+	if err != nil {
+		logger.Error("Unable to find users to migrate: " + err.Error())
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := int64(0)
+	for cursor.Next(ctx) {
+		user := userDocument{}
+		err = cursor.Decode(&user)
+		if err != nil {
+			logger.Error("Unable to decode user: " + err.Error())
+			return migrated, err
+		}
+
+		userID := user.ID.Hex()
+		for _, share := range user.Shares {
+			share.UserID = userID
+			if share.ShareID == "" {
+				share.ShareID = primitive.NewObjectID().Hex()
+			}
+			share.PriceBaught = models.NewMoney(share.PriceBaught.Amount, share.PriceBaught.Currency)
+			share.PriceSold = models.NewMoney(share.PriceSold.Amount, share.PriceSold.Currency)
+
+			filter := bson.M{"userID": userID, "shareID": share.ShareID}
+			opts := options.Update().SetUpsert(true)
+			_, err = lots.UpdateOne(ctx, filter, bson.M{"$setOnInsert": share}, opts)
+			if err != nil {
+				logger.Error("Unable to move share " + share.ShareID + ": " + err.Error())
+				return migrated, err
+			}
+			migrated++
+		}
+
+		_, err = users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$unset": bson.M{"shares": ""}})
+		if err != nil {
+			logger.Error("Unable to remove embedded shares of " + user.Email + ": " + err.Error())
+			return migrated, err
+		}
+	}
+	return migrated, cursor.Err()
+}
+
+// migrateLedger gives every user who has a balance but no ledger yet an
+// opening entry for it, so that their balance can be derived from the ledger.
+// Run it while no balances are changing.
+func (s *MongoStore) migrateLedger() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	users := getDBCollection("Users", s.client)
+	ledger := getDBCollection("Ledger", s.client)
+	cursor, err := users.Find(ctx, bson.M{"balance.amount": bson.M{"$ne": 0}})
+	if err != nil {
+		logger.Error("Unable to find users to migrate: " + err.Error())
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := int64(0)
+	for cursor.Next(ctx) {
+		user := userDocument{}
+		err = cursor.Decode(&user)
+		if err != nil {
+			logger.Error("Unable to decode user: " + err.Error())
+			return migrated, err
+		}
+
+		userID := user.ID.Hex()
+		count, err := ledger.CountDocuments(ctx, bson.M{"userID": userID}, options.Count().SetLimit(1))
+		if err != nil {
+			logger.Error("Unable to look up ledger of " + user.Email + ": " + err.Error())
+			return migrated, err
+		}
+		if count > 0 {
+			continue
+		}
+
+		opening := models.NewLedgerEntry(userID, user.Email, openingBalance(user.Balance), user.Balance, s.clock.Now())
+		_, err = ledger.InsertOne(ctx, opening)
+		if err != nil {
+			logger.Error("Unable to record opening balance of " + user.Email + ": " + err.Error())
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+// migrateTimestamps converts the time.Time.String() values older versions
+// stored in the createdDate of users and the dateBaught and dateSold of lots
+// into dates. Decoding already parses them, so each matching document is
+// written back; users without a creation date get the time of their _id.
+// Shares still embedded in users are converted by the "lots" migration.
+func (s *MongoStore) migrateTimestamps() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	text := bson.M{"$type": "string"}
+	users := getDBCollection("Users", s.client)
+	cursor, err := users.Find(ctx, bson.M{"createdDate": text})
+	if err != nil {
+		logger.Error("Unable to find users to migrate: " + err.Error())
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := int64(0)
+	for cursor.Next(ctx) {
+		user := userDocument{}
+		err = cursor.Decode(&user)
+		if err != nil {
+			logger.Error("Unable to decode user: " + err.Error())
+			return migrated, err
+		}
+		if user.CreatedDate.IsZero() {
+			user.CreatedDate = user.ID.Timestamp().UTC()
+		}
+		_, err = users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"createdDate": user.CreatedDate}})
+		if err != nil {
+			logger.Error("Unable to migrate user " + user.Email + ": " + err.Error())
+			return migrated, err
+		}
+		migrated++
+	}
+	if err = cursor.Err(); err != nil {
+		return migrated, err
+	}
+
+	lots := getDBCollection("Lots", s.client)
+	lotCursor, err := lots.Find(ctx, bson.M{"$or": bson.A{bson.M{"dateBaught": text}, bson.M{"dateSold": text}}})
+	if err != nil {
+		logger.Error("Unable to find lots to migrate: " + err.Error())
+		return migrated, err
+	}
+	defer lotCursor.Close(ctx)
+
+	for lotCursor.Next(ctx) {
+		lot := lotDocument{}
+		err = lotCursor.Decode(&lot)
+		if err != nil {
+			logger.Error("Unable to decode lot: " + err.Error())
+			return migrated, err
+		}
+		set := bson.M{"dateBaught": lot.DateBaught}
+		update := bson.M{"$set": set}
+		if lot.DateSold == nil {
+			update["$unset"] = bson.M{"dateSold": ""}
+		} else {
+			set["dateSold"] = *lot.DateSold
+		}
+		_, err = lots.UpdateOne(ctx, bson.M{"_id": lot.ID}, update)
+		if err != nil {
+			logger.Error("Unable to migrate lot " + lot.ShareID + ": " + err.Error())
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, lotCursor.Err()
+}
@@ -3,11 +3,12 @@ package src
 import (
 	"context"
 	"dbutil/src/config"
+	"dbutil/src/fees"
 	logger "dbutil/src/logging"
 	"dbutil/src/models"
+	"dbutil/src/quotes"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log"
 	"time"
 
@@ -15,7 +16,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func ConnectToDB() (*mongo.Client, error) {
@@ -27,13 +27,28 @@ func ConnectToDB() (*mongo.Client, error) {
 	defer cancel()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(
 		appConfig.ConnectionString,
-	))
+	).SetRegistry(timestampRegistry()))
 	if err != nil {
 		log.Fatal(err)
 	}
 	return client, err
 }
 
+// MongoStore is the Store implementation backed by a MongoDB deployment.
+type MongoStore struct {
+	policy
+	client       *mongo.Client
+	transactions bool
+}
+
+func NewMongoStore(client *mongo.Client, appConfig config.Configuration, provider quotes.Provider) *MongoStore {
+	transactions := supportsTransactions(client)
+	if !transactions {
+		logger.Info("Deployment does not support transactions, falling back to compensating writes")
+	}
+	return &MongoStore{policy: newPolicy(appConfig, provider), client: client, transactions: transactions}
+}
+
 func getDBCollection(collectionName string, client *mongo.Client) *mongo.Collection {
 	if client != nil {
 		collection := client.Database("CoinDB").Collection(collectionName)
@@ -44,7 +59,7 @@ func getDBCollection(collectionName string, client *mongo.Client) *mongo.Collect
 	return nil
 }
 
-func GetUserHash(email string, client *mongo.Client) (string, error) {
+func (s *MongoStore) GetUserHash(email string) (string, error) {
 	credentials := models.UserCredentials{}
 	logger.Info("Searching user with email: " + email)
 
@@ -52,7 +67,7 @@ func GetUserHash(email string, client *mongo.Client) (string, error) {
 	defer cancel()
 
 	filter := bson.M{"email": bson.M{"$eq": email}}
-	collection := getDBCollection("Users", client)
+	collection := getDBCollection("Users", s.client)
 	opts := options.FindOne().SetProjection(bson.D{{Key: "email", Value: 1}, {Key: "hash", Value: 1}})
 
 	err := collection.FindOne(ctx, filter, opts).Decode(&credentials)
@@ -67,16 +82,20 @@ func GetUserHash(email string, client *mongo.Client) (string, error) {
 	return hash, nil
 }
 
-func GetDbIdByEmail(email string, client *mongo.Client) (string, error) {
+func (s *MongoStore) GetDbIdByEmail(email string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return s.dbIDByEmail(ctx, email)
+}
+
+func (s *MongoStore) dbIDByEmail(ctx context.Context, email string) (string, error) {
 	objId := models.UserID{}
 
 	logger.Info("Searching user with email: " + email)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
 	filter := bson.M{"email": bson.M{"$eq": email}}
-	collection := getDBCollection("Users", client)
+	collection := getDBCollection("Users", s.client)
 	opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})
 
 	err := collection.FindOne(ctx, filter, opts).Decode(&objId)
@@ -90,14 +109,14 @@ func GetDbIdByEmail(email string, client *mongo.Client) (string, error) {
 	return objId.ID, nil
 }
 
-func CheckIfEmailExists(email string, client *mongo.Client) (bool, error) {
+func (s *MongoStore) CheckIfEmailExists(email string) (bool, error) {
 	logger.Info("Looking up user with email: " + email)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{"email": bson.M{"$eq": email}}
-	collection := getDBCollection("Users", client)
+	collection := getDBCollection("Users", s.client)
 	number, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
 		logger.Error("Encountered error while looking up email")
@@ -110,15 +129,24 @@ func CheckIfEmailExists(email string, client *mongo.Client) (bool, error) {
 	return false, nil
 }
 
-func SaveNewUser(user models.User, client *mongo.Client) (*mongo.InsertOneResult, error) {
+// SaveNewUser inserts a pending user. Users start with an empty balance in
+// the currency they registered with; money only comes in through deposits,
+// which validateDeposit checks.
+func (s *MongoStore) SaveNewUser(user models.User) (*mongo.InsertOneResult, error) {
 	user.EmailConfimed = false
+	user.Balance = models.NewMoney(0, user.Balance.Currency)
+	user.HeldBalance = models.NewMoney(0, user.Balance.Currency)
+	user.AccountStatus = models.AccountStatusPending
+	user.CreatedDate = s.clock.Now()
+	user.Shares = nil
 
-	collection := getDBCollection("Users", client)
+	collection := getDBCollection("Users", s.client)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-
 	result, err := collection.InsertOne(ctx, user)
-
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrEmailTaken
+	}
 	if err != nil {
 		logger.Error("Encountered error while saving user data. " + err.Error())
 		return nil, err
@@ -129,14 +157,14 @@ func SaveNewUser(user models.User, client *mongo.Client) (*mongo.InsertOneResult
 	return result, nil
 }
 
-func GetUserData(email string, client *mongo.Client) (models.User, error) {
+func (s *MongoStore) GetUserData(email string) (models.User, error) {
 	logger.Info("Searching user with username: " + email)
 
 	user := models.User{}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	collection := getDBCollection("Users", client)
+	collection := getDBCollection("Users", s.client)
 	filter := bson.M{"email": email}
 
 	err := collection.FindOne(ctx, filter).Decode(&user)
@@ -145,37 +173,94 @@ func GetUserData(email string, client *mongo.Client) (models.User, error) {
 		return user, err
 	}
 
+	user.Shares, err = s.GetLots(email)
+	if err != nil {
+		return user, err
+	}
+
 	logger.Info("Successfully retrieved user data")
 	return user, nil
 }
 
-func AuthenticateUserOnDB(email string, password string, client *mongo.Client) error {
-	hashedPassword := []byte(password)
-	hash, err := GetUserHash(email, client)
+// ListUserEmails returns the email of every user, in insertion order.
+func (s *MongoStore) ListUserEmails() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	collection := getDBCollection("Users", s.client)
+	opts := options.Find().SetProjection(bson.M{"email": 1}).SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
 	if err != nil {
-		logger.Error("Unable to get user hash.")
-		return err
+		logger.Error("Unable to list users: " + err.Error())
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	err = bcrypt.CompareHashAndPassword([]byte(hash), hashedPassword)
-	if err != nil {
-		logger.Error("Unable to authenticate the user")
-		return err
+	emails := []string{}
+	for cursor.Next(ctx) {
+		credentials := models.UserCredentials{}
+		err = cursor.Decode(&credentials)
+		if err != nil {
+			logger.Error("Unable to decode user: " + err.Error())
+			return nil, err
+		}
+		emails = append(emails, credentials.Email)
 	}
-	logger.Info("User has been authenticated successfully.")
-	return nil
+	return emails, cursor.Err()
 }
 
-func DeleteUserFromDB(email string, client *mongo.Client) (*mongo.DeleteResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// DeleteUserFromDB removes a user together with the lots they hold and their
+// watchlists, and cancels their open orders and active alerts. Their ledger is
+// kept.
+func (s *MongoStore) DeleteUserFromDB(email string) (*mongo.DeleteResult, error) {
+	result := &mongo.DeleteResult{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		userID, err := s.dbIDByEmail(ctx, email)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-	collection := getDBCollection("Users", client)
-	filter := bson.M{"email": bson.M{"$eq": email}}
+		_, err = getDBCollection("Lots", s.client).DeleteMany(ctx, bson.M{"userID": userID})
+		if err != nil {
+			logger.Error("Unable to delete lots of user: " + err.Error())
+			return err
+		}
+
+		_, err = getDBCollection("Watchlists", s.client).DeleteMany(ctx, bson.M{"userID": userID})
+		if err != nil {
+			logger.Error("Unable to delete watchlists of user: " + err.Error())
+			return err
+		}
+
+		filter := bson.M{"userID": userID, "status": models.OrderOpen}
+		cancel := bson.M{"$set": bson.M{"status": models.OrderCancelled, "closedAt": s.clock.Now()}}
+		_, err = getDBCollection("Orders", s.client).UpdateMany(ctx, filter, cancel)
+		if err != nil {
+			logger.Error("Unable to cancel orders of user: " + err.Error())
+			return err
+		}
+
+		filter = bson.M{"userID": userID, "status": models.AlertActive}
+		cancel = bson.M{"$set": bson.M{"status": models.AlertCancelled, "cancelledAt": s.clock.Now()}}
+		_, err = getDBCollection("Alerts", s.client).UpdateMany(ctx, filter, cancel)
+		if err != nil {
+			logger.Error("Unable to cancel alerts of user: " + err.Error())
+			return err
+		}
 
-	result, err := collection.DeleteOne(ctx, filter)
+		collection := getDBCollection("Users", s.client)
+		filter = bson.M{"email": bson.M{"$eq": email}}
+		result, err = collection.DeleteOne(ctx, filter)
+		if err != nil {
+			logger.Error("Unable to delete user from db: " + err.Error())
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		logger.Error("Unable to delete user from db: " + err.Error())
 		return nil, err
 	}
 	logger.Info("User has been deleted successfully.")
@@ -183,179 +268,366 @@ func DeleteUserFromDB(email string, client *mongo.Client) (*mongo.DeleteResult,
 	return result, nil
 }
 
-func UpdateUserStatusOnDB(email string, status string, client *mongo.Client) (*mongo.UpdateResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	collection := getDBCollection("Users", client)
-	filter := bson.M{"email": bson.M{"$eq": email}}
-	update := bson.M{"$set": bson.M{"accountStatus": status}}
-	result, err := collection.UpdateOne(ctx, filter, update)
+// SaveBaughtShare buys share at the market price, debiting the cost and
+// recording it as a new lot in one transaction. share.PriceBaught is the
+// price the client expects.
+func (s *MongoStore) SaveBaughtShare(email string, share models.Share) (*mongo.UpdateResult, error) {
+	err := validateBuy(share)
 	if err != nil {
-		logger.Error("Unable to update the status of user " + err.Error())
 		return nil, err
 	}
-	return result, nil
-}
-
-func SaveBaughtShare(email string, share models.Share, client *mongo.Client) (*mongo.UpdateResult, error) {
-	balance, err := GetBalance(email, client)
+	share.PriceBaught, err = s.executionPrice(share.Symbol, share.PriceBaught, true)
 	if err != nil {
-		logger.Error(err.Error())
 		return nil, err
 	}
-	if balance < share.PriceBaught*float64(share.Quantity) {
-		logger.Error("Insufficient balance to purchase the shares")
-		return nil, fmt.Errorf("Insufficient balance to purchase the shares.")
-	}
 
-	cost := share.PriceBaught * float64(share.Quantity)
-	err = UpdateBalance(client, email, cost, false, true)
+	err = s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		_, err := s.buyShare(ctx, undo, email, share, models.Money{})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	user, err := GetUserData(email, client)
+// buyShare debits the cost of share at share.PriceBaught and records it as a
+// new lot. released is held money the debit may use, when the buy settles a
+// hold.
+func (s *MongoStore) buyShare(ctx context.Context, undo *undoLog, email string, share models.Share, released models.Money) (models.Share, error) {
+	userID, err := s.dbIDByEmail(ctx, email)
 	if err != nil {
-		logger.Error(err.Error())
-		return nil, err
+		return models.Share{}, err
 	}
-
+	share.UserID = userID
 	share.ShareID = primitive.NewObjectID().Hex()
 	share.SoldIndicator = "N"
-	share.DateBaught = time.Now().String()
+	share.DateBaught = s.clock.Now()
 
-	if user.Shares == nil {
-		shares := []models.Share{}
-		shares = append(shares, share)
-
-		collection := getDBCollection("Users", client)
-		filter := bson.M{"email": bson.M{"$eq": email}}
-		update := bson.M{"$set": bson.M{"shares": shares}}
-		result, err := collection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			logger.Error("Unable to save baught share" + err.Error())
-			_ = UpdateBalance(client, email, cost, true, false)
-			return nil, err
-		}
-		return result, nil
+	traded, err := s.tradedSince(ctx, userID, share.PriceBaught, fees.MonthStart(s.clock.Now()))
+	if err != nil {
+		return models.Share{}, err
+	}
+	share.BuyFee, err = s.tradeFee(share.PriceBaught, share.Quantity, traded)
+	if err != nil {
+		return models.Share{}, err
+	}
 
+	change, err := buyChange(share)
+	if err != nil {
+		return models.Share{}, err
 	}
-	collection := getDBCollection("Users", client)
-	filter := bson.M{"email": bson.M{"$eq": email}}
-	update := bson.M{"$push": bson.M{"shares": share}}
-	result, err := collection.UpdateOne(ctx, filter, update)
+	change.Release = released
+	_, err = s.updateBalance(ctx, undo, email, change)
+	if err != nil {
+		return models.Share{}, err
+	}
+
+	collection := getDBCollection("Lots", s.client)
+	_, err = collection.InsertOne(ctx, share)
 	if err != nil {
 		logger.Error("Unable to save baught share" + err.Error())
-		_ = UpdateBalance(client, email, cost, true, false)
+		return models.Share{}, err
+	}
+	undo.add(func(ctx context.Context) error {
+		_, err := collection.DeleteOne(ctx, bson.M{"userID": share.UserID, "shareID": share.ShareID})
+		return err
+	})
+	return share, nil
+}
+
+// UpdateShareToSold sells share.Quantity shares of the lot share.ShareID,
+// splitting the lot when only part of it is sold. Without a quantity the
+// whole lot is sold.
+func (s *MongoStore) UpdateShareToSold(email string, share models.Share) (*mongo.UpdateResult, error) {
+	lots, err := s.findLots(email, bson.M{"shareID": share.ShareID, "soldIndicator": "N"})
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.SellShares(email, sellOrderForLot(share, lots))
+	if err != nil {
 		return nil, err
 	}
+	count := int64(len(result.Lots))
+	return &mongo.UpdateResult{MatchedCount: count, ModifiedCount: count}, nil
+}
+
+// SellShares sells order.Quantity shares at the market price, consuming lots
+// in the order of the matching policy and crediting the proceeds. Every lot
+// update and the credit happen in one transaction. order.PriceSold is the
+// price the client expects.
+func (s *MongoStore) SellShares(email string, order models.SellOrder) (models.SellResult, error) {
+	order, err := s.validateSellOrder(order)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	expected := order.PriceSold
+	priceOf := func(symbol string) (models.Money, error) {
+		return s.executionPrice(symbol, expected, false)
+	}
 
+	result := models.SellResult{}
+	err = s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		var err error
+		result, err = s.sellShares(ctx, undo, email, order, priceOf)
+		return err
+	})
+	if err != nil {
+		return models.SellResult{}, err
+	}
 	return result, nil
 }
 
-func UpdateShareToSold(email string, share models.Share, client *mongo.Client) (*mongo.UpdateResult, error) {
-	shareID := share.ShareID
-	result := &mongo.UpdateResult{}
-	soldIndicator, err := GetSoldIndicator(email, shareID, client)
+// sellShares matches the lots a validated order consumes, sells them at the
+// price priceOf gives for their symbol and credits the proceeds.
+func (s *MongoStore) sellShares(ctx context.Context, undo *undoLog, email string, order models.SellOrder, priceOf func(symbol string) (models.Money, error)) (models.SellResult, error) {
+	userID, err := s.dbIDByEmail(ctx, email)
 	if err != nil {
-		logger.Error(err.Error())
-		return result, err
+		return models.SellResult{}, err
+	}
+
+	filter := bson.M{"soldIndicator": "N"}
+	if order.Symbol != "" {
+		filter["symbol"] = order.Symbol
+	}
+	if order.Matching == models.MatchSpecificLot {
+		filter["shareID"] = bson.M{"$in": order.ShareIDs}
+	}
+	open, err := s.findLotsByUserID(ctx, userID, filter)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	fills, err := matchLots(open, order)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	symbol, err := fillSymbol(fills)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	order.PriceSold, err = priceOf(symbol)
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	traded, err := s.tradedSince(ctx, userID, order.PriceSold, fees.MonthStart(s.clock.Now()))
+	if err != nil {
+		return models.SellResult{}, err
 	}
-	if soldIndicator == "N" {
-		cost := share.PriceSold * float64(share.Quantity)
-		err = UpdateBalance(client, email, cost, true, false)
-		if err != nil {
-			return nil, err
-		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	fee, err := s.tradeFee(order.PriceSold, order.Quantity, traded)
+	if err != nil {
+		return models.SellResult{}, err
+	}
 
-		date := time.Now().String()
-		collection := getDBCollection("Users", client)
-		filter := bson.M{"email": email, "shares.shareID": shareID}
-		update := bson.M{"$set": bson.M{"shares.$.ownedOrSold": "Sold", "shares.$.dateSold": date, "shares.$.soldIndicator": "Y", "shares.$.priceSold": share.PriceSold}}
-		result, err = collection.UpdateOne(ctx, filter, update)
+	result := newSellResult(order, fee)
+	lotFees := splitFee(result.Fee, fills)
+	date := s.clock.Now()
+	for i, fill := range fills {
+		remaining, sold, err := sellLot(fill, result.SaleID, order.PriceSold, lotFees[i], date)
 		if err != nil {
-			logger.Error("Unable to save sold share" + err.Error())
-			_ = UpdateBalance(client, email, cost, false, true)
-			return nil, err
+			return models.SellResult{}, err
 		}
+		err = s.saveSoldLot(ctx, undo, fill.lot, remaining, sold)
+		if err != nil {
+			return models.SellResult{}, err
+		}
+		err = addSoldLot(&result, fill.lot.ShareID, sold)
+		if err != nil {
+			return models.SellResult{}, err
+		}
+	}
+
+	_, err = s.updateBalance(ctx, undo, email, sellChange(order, result))
+	if err != nil {
+		return models.SellResult{}, err
+	}
+	return result, nil
+}
+
+// saveSoldLot writes the outcome of sellLot. The lot is only updated while it
+// is still open with the quantity it was read with, so two sells can never
+// consume the same shares.
+func (s *MongoStore) saveSoldLot(ctx context.Context, undo *undoLog, lot models.Share, remaining *models.Share, sold models.Share) error {
+	collection := getDBCollection("Lots", s.client)
+	filter := bson.M{"userID": lot.UserID, "shareID": lot.ShareID, "soldIndicator": "N", "quantity": lot.Quantity}
+	restore := bson.M{"userID": lot.UserID, "shareID": lot.ShareID}
+
+	update := bson.M{"$set": bson.M{"soldIndicator": "Y", "priceSold": sold.PriceSold, "sellFee": sold.SellFee, "dateSold": sold.DateSold, "saleID": sold.SaleID, "realizedGain": sold.RealizedGain}}
+	undoUpdate := bson.M{"$set": bson.M{"soldIndicator": "N", "priceSold": models.Money{}, "sellFee": models.Money{}, "realizedGain": models.Money{}}, "$unset": bson.M{"dateSold": "", "saleID": ""}}
+	if remaining != nil {
+		update = bson.M{"$set": bson.M{"quantity": remaining.Quantity, "buyFee": remaining.BuyFee, "basisAdjustment": remaining.BasisAdjustment}}
+		undoUpdate = bson.M{"$set": bson.M{"quantity": lot.Quantity, "buyFee": lot.BuyFee, "basisAdjustment": lot.BasisAdjustment}}
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logger.Error("Unable to save sold share" + err.Error())
+		return err
+	}
+	if result.MatchedCount == 0 {
+		logger.Error("Lot " + lot.ShareID + " changed while selling")
+		return ErrHoldingsChanged
+	}
+	undo.add(func(ctx context.Context) error {
+		_, err := collection.UpdateOne(ctx, restore, undoUpdate)
+		return err
+	})
 
-		return result, nil
+	if remaining == nil {
+		return nil
+	}
+	_, err = collection.InsertOne(ctx, sold)
+	if err != nil {
+		logger.Error("Unable to save sold part of lot " + lot.ShareID + ": " + err.Error())
+		return err
 	}
-	return result, fmt.Errorf("Unable to complete the transaction. User does not own the shares.")
+	undo.add(func(ctx context.Context) error {
+		_, err := collection.DeleteOne(ctx, bson.M{"userID": sold.UserID, "shareID": sold.ShareID})
+		return err
+	})
+	return nil
 }
 
-func GetSoldIndicator(email string, shareID string, client *mongo.Client) (string, error) {
-	shares := models.Shares{}
-	soldIndicator := ""
+func (s *MongoStore) GetSoldIndicator(email string, shareID string) (string, error) {
+	share := models.Share{}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	collection := getDBCollection("Users", client)
-	filter := bson.M{"email": email}
-	opts := options.FindOne().SetProjection(bson.M{"shares": 1})
-	err := collection.FindOne(ctx, filter, opts).Decode(&shares)
+
+	userID, err := s.dbIDByEmail(ctx, email)
 	if err != nil {
 		logger.Error("Unable to get sold indicator for the share " + err.Error())
-		return soldIndicator, err
+		return "", err
 	}
-	for i := 0; i < len(shares.Shares); i++ {
-		if shares.Shares[i].ShareID == shareID {
-			soldIndicator = shares.Shares[i].SoldIndicator
-		}
+
+	collection := getDBCollection("Lots", s.client)
+	filter := bson.M{"userID": userID, "shareID": shareID}
+	opts := options.FindOne().SetProjection(bson.M{"soldIndicator": 1})
+	err = collection.FindOne(ctx, filter, opts).Decode(&share)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	if err != nil {
+		logger.Error("Unable to get sold indicator for the share " + err.Error())
+		return "", err
 	}
-	logger.Info(shares)
-	return soldIndicator, nil
+	return share.SoldIndicator, nil
 }
 
-func GetBalance(email string, client *mongo.Client) (float64, error) {
+// GetBalance returns the balance of a user, split into what pending holds
+// reserve and what is available.
+func (s *MongoStore) GetBalance(email string) (models.AccountBalance, error) {
 	balance := models.Balance{}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	collection := getDBCollection("Users", client)
+	collection := getDBCollection("Users", s.client)
 	filter := bson.M{"email": bson.M{"$eq": email}}
-	opts := options.FindOne().SetProjection(bson.D{{Key: "balance", Value: 1}})
+	opts := options.FindOne().SetProjection(bson.D{{Key: "balance", Value: 1}, {Key: "heldBalance", Value: 1}})
 	err := collection.FindOne(ctx, filter, opts).Decode(&balance)
 	if err != nil {
-		logger.Error("Unable to update the status of user " + err.Error())
-		return balance.Balance, err
+		logger.Error("Unable to get balance of user " + err.Error())
+		return models.AccountBalance{}, err
 	}
-	return balance.Balance, nil
+	return balance.Summary(), nil
 }
 
-func UpdateBalance(client *mongo.Client, email string, amountToAddOrDeduct float64, toAdd bool, toDeduct bool) error {
-	currentBalance, err := GetBalance(email, client)
-	newBalance := float64(0)
-	if err != nil {
-		logger.Error("Unable to get current balance " + err.Error())
+// UpdateBalance credits or debits the balance of a user and appends the
+// change to their ledger, in one transaction. Debits only match while the
+// available balance covers the amount, so concurrent requests can never
+// overdraw the account or spend held funds; ErrInsufficientFunds is returned
+// when they would.
+func (s *MongoStore) UpdateBalance(email string, change models.BalanceChange) (models.LedgerEntry, error) {
+	entry := models.LedgerEntry{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		var err error
+		entry, err = s.updateBalance(ctx, undo, email, change)
 		return err
+	})
+	if err != nil {
+		return models.LedgerEntry{}, err
+	}
+	logger.Info("Balance has been updated successfully.")
+	return entry, nil
+}
+
+// updateBalance applies change with a single atomic $inc and records it in the
+// ledger. Without transactions, a failure after the $inc is compensated by a
+// reversal.
+func (s *MongoStore) updateBalance(ctx context.Context, undo *undoLog, email string, change models.BalanceChange) (models.LedgerEntry, error) {
+	if change.Amount.IsNegative() {
+		return models.LedgerEntry{}, ErrInvalidAmount
 	}
-	if toAdd {
-		newBalance = currentBalance + amountToAddOrDeduct
+	if change.Type == models.LedgerDeposit {
+		err := s.checkDeposit(ctx, email, change.Amount)
+		if err != nil {
+			return models.LedgerEntry{}, err
+		}
+	}
+
+	currency := models.NewMoney(0, change.Amount.Currency).Currency
+	filter := bson.M{"email": bson.M{"$eq": email}, "balance.currency": currency}
+	if !change.Credit {
+		filter["$expr"] = availableCovers(change.Amount.Amount, change.Release.Amount)
 	}
-	if toDeduct {
-		newBalance = currentBalance - amountToAddOrDeduct
+	blocked := restricted(change.Type)
+	if blocked != nil {
+		filter["accountStatus"] = bson.M{"$nin": blocked}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	account, err := s.incBalance(ctx, filter, change.Delta(), change.Release)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.LedgerEntry{}, s.balanceFailure(ctx, email, change.Amount, blocked)
+	}
+	if err != nil {
+		logger.Error("Unable to update balance " + err.Error())
+		return models.LedgerEntry{}, err
+	}
 
-	collection := getDBCollection("Users", client)
-	filter := bson.M{"email": bson.M{"$eq": email}}
-	update := bson.M{"$set": bson.M{"balance": newBalance}}
-	_, err = collection.UpdateOne(ctx, filter, update)
+	entry := models.NewLedgerEntry(account.ID.Hex(), email, change, account.Balance, s.clock.Now())
+	recorded := false
+	undo.add(func(ctx context.Context) error {
+		return s.reverseBalance(ctx, entry, change.Release, recorded)
+	})
+	_, err = getDBCollection("Ledger", s.client).InsertOne(ctx, entry)
 	if err != nil {
-		logger.Error("Unable to save baught share" + err.Error())
-		return err
+		logger.Error("Unable to record balance change in the ledger: " + err.Error())
+		return models.LedgerEntry{}, err
 	}
+	recorded = true
+	return entry, nil
+}
 
-	logger.Info("Balance has been updated successfully.")
-	return nil
+// availableCovers is an $expr that matches users whose available balance,
+// together with released held funds, is at least amount. Users written before
+// holds existed have no heldBalance.
+func availableCovers(amount int64, released int64) bson.M {
+	held := bson.M{"$ifNull": bson.A{"$heldBalance.amount", 0}}
+	available := bson.M{"$subtract": bson.A{"$balance.amount", held}}
+	return bson.M{"$gte": bson.A{bson.M{"$add": bson.A{available, released}}, amount}}
+}
+
+// balanceFailure explains why a guarded balance update matched no user.
+// blocked are the account statuses the update excluded, if any.
+func (s *MongoStore) balanceFailure(ctx context.Context, email string, amount models.Money, blocked []models.AccountStatus) error {
+	balance := struct {
+		Balance models.Money         `bson:"balance"`
+		Status  models.AccountStatus `bson:"accountStatus"`
+	}{}
+	opts := options.FindOne().SetProjection(bson.D{{Key: "balance", Value: 1}, {Key: "accountStatus", Value: 1}})
+	err := getDBCollection("Users", s.client).FindOne(ctx, bson.M{"email": bson.M{"$eq": email}}, opts).Decode(&balance)
+	if err != nil {
+		logger.Error("Unable to update balance " + err.Error())
+		return err
+	}
+	if balance.Status.In(blocked) {
+		logger.Error("Account of " + email + " is " + string(balance.Status))
+		return ErrAccountBlocked
+	}
+	if !balance.Balance.SameCurrency(amount) {
+		logger.Error("Balance is held in " + balance.Balance.Currency + ", not " + models.NewMoney(0, amount.Currency).Currency)
+		return ErrCurrencyMismatch
+	}
+	logger.Error("Insufficient balance to deduct the amount")
+	return ErrInsufficientFunds
 }
@@ -0,0 +1,275 @@
+package src
+
+import (
+	"context"
+	"dbutil/src/config"
+	"dbutil/src/models"
+	"dbutil/src/quotes"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// These tests run the MongoStore against the mock deployment of the driver,
+// which answers every command with the next queued response, so no mongod is
+// needed. The first response of every store answers the deployment check of
+// NewMongoStore.
+
+const usersNamespace = "CoinDB.Users"
+
+func newMockStore(mt *mtest.T, hello bson.D) *MongoStore {
+	mt.AddMockResponses(hello)
+	return NewMongoStore(mt.Client, config.Configuration{}, quotes.NewStaticProvider(nil))
+}
+
+func standalone() bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "ismaster", Value: true})
+}
+
+func TestMongoStoreDetectsDeployment(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("standalone", func(mt *mtest.T) {
+		if store := newMockStore(mt, standalone()); store.transactions {
+			t.Fatal("a standalone server was taken to support transactions")
+		}
+	})
+	mt.Run("replica set", func(mt *mtest.T) {
+		hello := mtest.CreateSuccessResponse(bson.E{Key: "ismaster", Value: true}, bson.E{Key: "setName", Value: "rs0"})
+		if store := newMockStore(mt, hello); !store.transactions {
+			t.Fatal("a replica set was taken to not support transactions")
+		}
+	})
+	mt.Run("unknown", func(mt *mtest.T) {
+		hello := mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Message: "unauthorized"})
+		if store := newMockStore(mt, hello); store.transactions {
+			t.Fatal("a deployment that could not be checked was taken to support transactions")
+		}
+	})
+}
+
+func TestMongoGetUserHash(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("found", func(mt *mtest.T) {
+		store := newMockStore(mt, standalone())
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch, bson.D{
+			{Key: "email", Value: "trader@example.com"},
+			{Key: "hash", Value: "$2a$10$hash"},
+		}))
+
+		hash, err := store.GetUserHash("trader@example.com")
+		if err != nil || hash != "$2a$10$hash" {
+			t.Fatalf("GetUserHash returned %q, %v; want the stored hash", hash, err)
+		}
+	})
+	mt.Run("missing", func(mt *mtest.T) {
+		store := newMockStore(mt, standalone())
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch))
+
+		_, err := store.GetUserHash("nobody@example.com")
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("GetUserHash returned %v, want ErrNoDocuments", err)
+		}
+	})
+}
+
+func TestMongoCheckIfEmailExists(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("taken", func(mt *mtest.T) {
+		store := newMockStore(mt, standalone())
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch, bson.D{{Key: "n", Value: int32(1)}}))
+
+		exists, err := store.CheckIfEmailExists("trader@example.com")
+		if err != nil || !exists {
+			t.Fatalf("CheckIfEmailExists returned %v, %v; want true", exists, err)
+		}
+	})
+	mt.Run("free", func(mt *mtest.T) {
+		store := newMockStore(mt, standalone())
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, usersNamespace, mtest.FirstBatch))
+
+		exists, err := store.CheckIfEmailExists("nobody@example.com")
+		if err != nil || exists {
+			t.Fatalf("CheckIfEmailExists returned %v, %v; want false", exists, err)
+		}
+	})
+}
+
+func TestMongoSaveNewUser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("inserts a pending user without a balance", func(mt *mtest.T) {
+		store := newMockStore(mt, standalone())
+		clock := models.NewManualClock(time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC))
+		store.SetClock(clock)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		mt.ClearEvents()
+
+		_, err := store.SaveNewUser(models.User{
+			Email:         "trader@example.com",
+			EmailConfimed: true,
+			AccountStatus: models.AccountStatusActive,
+			Balance:       models.NewMoney(100000, "USD"),
+		})
+		if err != nil {
+			t.Fatalf("SaveNewUser: %v", err)
+		}
+
+		inserted := insertedUser(mt)
+		if !inserted.Balance.IsZero() || inserted.Balance.Currency != "USD" || !inserted.HeldBalance.IsZero() {
+			t.Fatalf("inserted balance %s held %s, want an empty USD balance", inserted.Balance, inserted.HeldBalance)
+		}
+		if inserted.EmailConfimed || inserted.AccountStatus != models.AccountStatusPending {
+			t.Fatalf("inserted user is confirmed %v with status %s, want a pending user", inserted.EmailConfimed, inserted.AccountStatus)
+		}
+		if !inserted.CreatedDate.Equal(clock.Now()) {
+			t.Fatalf("user was created at %s, want the store clock %s", inserted.CreatedDate, clock.Now())
+		}
+	})
+	mt.Run("refuses a taken email", func(mt *mtest.T) {
+		store := newMockStore(mt, standalone())
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "E11000 duplicate key error"}))
+
+		_, err := store.SaveNewUser(models.User{Email: "trader@example.com"})
+		if !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("SaveNewUser returned %v, want ErrEmailTaken", err)
+		}
+	})
+}
+
+// insertedUser decodes the user the last insert sent to the mock deployment.
+func insertedUser(mt *mtest.T) models.User {
+	mt.Helper()
+	started := mt.GetStartedEvent()
+	if started == nil || started.CommandName != "insert" {
+		mt.Fatalf("last command was %+v, want an insert", started)
+	}
+	documents, err := started.Command.LookupErr("documents")
+	if err != nil {
+		mt.Fatalf("insert has no documents: %v", err)
+	}
+	values, err := documents.Array().Values()
+	if err != nil || len(values) != 1 {
+		mt.Fatalf("insert has documents %v, %v; want one", values, err)
+	}
+	user := models.User{}
+	err = bson.Unmarshal(values[0].Document(), &user)
+	if err != nil {
+		mt.Fatalf("inserted document is not a user: %v", err)
+	}
+	return user
+}
+
+func TestRunTransactionRollsBackWithoutTransactions(t *testing.T) {
+	store := &MongoStore{}
+	var undone []int
+	failure := errors.New("third step failed")
+
+	err := store.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		for step := 1; step <= 2; step++ {
+			step := step
+			undo.add(func(ctx context.Context) error {
+				undone = append(undone, step)
+				return nil
+			})
+		}
+		return failure
+	})
+
+	if !errors.Is(err, failure) {
+		t.Fatalf("runTransaction returned %v, want the error of the failed step", err)
+	}
+	if len(undone) != 2 || undone[0] != 2 || undone[1] != 1 {
+		t.Fatalf("undid steps %v, want 2 then 1", undone)
+	}
+}
+
+func TestRunTransactionReportsFailedRollback(t *testing.T) {
+	store := &MongoStore{}
+	undone := 0
+
+	err := store.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		undo.add(func(ctx context.Context) error {
+			undone++
+			return nil
+		})
+		undo.add(func(ctx context.Context) error {
+			return errors.New("connection lost")
+		})
+		return errors.New("second step failed")
+	})
+
+	if !errors.Is(err, ErrRollbackFailed) {
+		t.Fatalf("runTransaction returned %v, want ErrRollbackFailed", err)
+	}
+	if undone != 1 {
+		t.Fatalf("undid %d steps after the failed one, want the rollback to go on", undone)
+	}
+}
+
+func TestRunTransactionKeepsWritesThatSucceeded(t *testing.T) {
+	store := &MongoStore{}
+	undone := false
+
+	err := store.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		undo.add(func(ctx context.Context) error {
+			undone = true
+			return nil
+		})
+		return nil
+	})
+
+	if err != nil || undone {
+		t.Fatalf("runTransaction returned %v and undid the writes %v, want neither", err, undone)
+	}
+}
+
+func TestMongoReserveIdempotencyKey(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	const keysNamespace = "CoinDB.IdempotencyKeys"
+	now := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	record := models.IdempotencyRecord{Email: "trader@example.com", Key: "key-1", RequestHash: "hash", ExpiresAt: now.Add(time.Hour)}
+	taken := mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "E11000 duplicate key error"})
+	existing := func(expiresAt time.Time) bson.D {
+		return mtest.CreateCursorResponse(0, keysNamespace, mtest.FirstBatch, bson.D{
+			{Key: "email", Value: record.Email},
+			{Key: "key", Value: record.Key},
+			{Key: "requestHash", Value: "other"},
+			{Key: "expiresAt", Value: expiresAt},
+		})
+	}
+
+	mt.Run("returns the live record", func(mt *mtest.T) {
+		store := newMockStore(mt, standalone())
+		store.SetClock(models.NewManualClock(now))
+		mt.AddMockResponses(taken, existing(now.Add(time.Minute)))
+
+		found, reserved, err := store.ReserveIdempotencyKey(record)
+		if err != nil || reserved || found.RequestHash != "other" {
+			t.Fatalf("ReserveIdempotencyKey returned %+v, %v, %v; want the stored record", found, reserved, err)
+		}
+	})
+	mt.Run("replaces an expired record", func(mt *mtest.T) {
+		store := newMockStore(mt, standalone())
+		store.SetClock(models.NewManualClock(now))
+		deleted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		inserted := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})
+		mt.AddMockResponses(taken, existing(now), deleted, inserted)
+
+		_, reserved, err := store.ReserveIdempotencyKey(record)
+		if err != nil || !reserved {
+			t.Fatalf("ReserveIdempotencyKey returned %v, %v; want the key reserved", reserved, err)
+		}
+	})
+}
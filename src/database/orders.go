@@ -0,0 +1,571 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newOrder validates an order that is about to be placed and fills in its
+// fields. Prices the order type does not use are dropped.
+func (p policy) newOrder(email string, order models.Order, now time.Time) (models.Order, error) {
+	if order.Side != models.OrderBuy && order.Side != models.OrderSell {
+		return order, ErrInvalidOrderSide
+	}
+	if order.Symbol == "" {
+		return order, ErrMissingSymbol
+	}
+	if order.Quantity <= 0 || order.Quantity > maxQuantity {
+		return order, ErrInvalidQuantity
+	}
+
+	limit, stop := false, false
+	switch order.Type {
+	case models.OrderMarket:
+	case models.OrderLimit:
+		limit = true
+	case models.OrderStop:
+		stop = true
+	case models.OrderStopLimit:
+		limit, stop = true, true
+	default:
+		return order, ErrInvalidOrderType
+	}
+	if !limit {
+		order.LimitPrice = nil
+	} else if order.LimitPrice == nil || !order.LimitPrice.IsPositive() {
+		return order, ErrMissingLimitPrice
+	}
+	if !stop {
+		order.StopPrice = nil
+	} else if order.StopPrice == nil || !order.StopPrice.IsPositive() {
+		return order, ErrMissingStopPrice
+	}
+
+	switch order.TimeInForce {
+	case "":
+		order.TimeInForce = models.GoodTillCancelled
+		order.ExpiresAt = nil
+	case models.GoodTillCancelled:
+		order.ExpiresAt = nil
+	case models.GoodForDay:
+		year, month, day := now.UTC().Date()
+		endOfDay := time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+		order.ExpiresAt = &endOfDay
+	default:
+		return order, ErrInvalidTimeInForce
+	}
+
+	if order.Side == models.OrderSell {
+		if order.Matching == "" {
+			order.Matching = p.lotMatching
+		}
+		if !order.Matching.Valid() || order.Matching == models.MatchSpecificLot {
+			return order, ErrInvalidLotMatching
+		}
+	} else {
+		order.Matching = ""
+	}
+
+	order.ID = primitive.NewObjectID()
+	order.Email = email
+	order.Status = models.OrderOpen
+	order.Triggered = false
+	order.HoldID = ""
+	order.FillPrice = nil
+	order.ReferenceID = ""
+	order.Reason = ""
+	order.CreatedAt = now
+	order.ClosedAt = nil
+	return order, nil
+}
+
+// reservation is the hold an open buy order places: its quantity at the limit
+// price or, when it fills at the market, at the higher of the quote and the
+// stop price plus maxSlippageBps, and the fee before any volume discount. A
+// fill above that is still paid from the available balance, if it covers the
+// difference.
+func (p policy) reservation(order models.Order) (models.Money, error) {
+	if order.LimitPrice != nil {
+		return p.withFee(*order.LimitPrice, order.Quantity)
+	}
+	quote, err := p.quotes.Quote(order.Symbol)
+	if err != nil {
+		logger.Error("Unable to price " + order.Symbol + ": " + err.Error())
+		return models.Money{}, err
+	}
+	price := quote.Price
+	if order.StopPrice != nil {
+		if !price.SameCurrency(*order.StopPrice) {
+			return models.Money{}, ErrCurrencyMismatch
+		}
+		if price.LessThan(*order.StopPrice) {
+			price = *order.StopPrice
+		}
+	}
+	// The stop price comes from the client, so the slippage is worked out
+	// around bpsPerUnit to keep the multiply in range.
+	whole, rest := price.Amount/bpsPerUnit, price.Amount%bpsPerUnit
+	slippage := models.Money{Amount: whole*p.maxSlippageBps + (rest*p.maxSlippageBps+bpsPerUnit-1)/bpsPerUnit, Currency: price.Currency}
+	price, err = price.Add(slippage)
+	if err != nil {
+		return models.Money{}, err
+	}
+	return p.withFee(price, order.Quantity)
+}
+
+// withFee is the cost of quantity shares at price with the fee before any
+// volume discount.
+func (p policy) withFee(price models.Money, quantity int) (models.Money, error) {
+	notional, err := price.Mul(int64(quantity))
+	if err != nil {
+		return models.Money{}, err
+	}
+	return notional.Add(p.fees.Fee(notional, models.Money{}))
+}
+
+// orderHold is the hold that reserves the funds of a buy order.
+func orderHold(order models.Order, amount models.Money) models.Hold {
+	return models.Hold{Type: models.HoldOrder, Amount: amount, ReferenceID: order.ID.Hex()}
+}
+
+// orderSale is the sell a filled sell order makes.
+func orderSale(order models.Order) models.SellOrder {
+	return models.SellOrder{Symbol: order.Symbol, Quantity: order.Quantity, Matching: order.Matching}
+}
+
+// fillShare is the lot a filled buy order creates.
+func fillShare(order models.Order, price models.Money) models.Share {
+	return models.Share{Symbol: order.Symbol, Quantity: order.Quantity, PriceBaught: price}
+}
+
+// PlaceOrder saves an open order. Buy orders reserve their funds with a hold,
+// so a fill cannot fail for funds spent elsewhere in the meantime. Accounts
+// that may not trade can not place orders either.
+func (s *MongoStore) PlaceOrder(email string, order models.Order) (models.Order, error) {
+	order, err := s.newOrder(email, order, s.clock.Now())
+	if err != nil {
+		return models.Order{}, err
+	}
+	hold := models.Hold{}
+	if order.Side == models.OrderBuy {
+		amount, err := s.reservation(order)
+		if err != nil {
+			return models.Order{}, err
+		}
+		hold, err = newHold(email, orderHold(order, amount), s.clock.Now())
+		if err != nil {
+			return models.Order{}, err
+		}
+	}
+
+	err = s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		err := s.checkTrading(ctx, email)
+		if err != nil {
+			return err
+		}
+		userID, err := s.dbIDByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		order.UserID = userID
+		if order.Side == models.OrderBuy {
+			hold, err = s.placeHold(ctx, undo, email, hold)
+			if err != nil {
+				return err
+			}
+			order.HoldID = hold.ID.Hex()
+		}
+
+		_, err = getDBCollection("Orders", s.client).InsertOne(ctx, order)
+		if err != nil {
+			logger.Error("Unable to save order: " + err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	logger.Info("Placed order " + order.ID.Hex())
+	return order, nil
+}
+
+// CancelOrder closes an open order of a user and releases its funds.
+func (s *MongoStore) CancelOrder(email string, orderID string) (models.Order, error) {
+	return s.CloseOrder(email, orderID, models.OrderCancelled, "")
+}
+
+// CloseOrder moves an open order to status, which is cancelled, expired or
+// rejected, and releases the hold of a buy order.
+func (s *MongoStore) CloseOrder(email string, orderID string, status models.OrderStatus, reason string) (models.Order, error) {
+	order := models.Order{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		var err error
+		order, err = s.closeOrder(ctx, undo, email, orderID, status, reason)
+		return err
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	logger.Info("Order " + orderID + " is " + string(status))
+	return order, nil
+}
+
+func (s *MongoStore) closeOrder(ctx context.Context, undo *undoLog, email string, orderID string, status models.OrderStatus, reason string) (models.Order, error) {
+	update := bson.M{"status": status, "closedAt": s.clock.Now()}
+	if reason != "" {
+		update["reason"] = reason
+	}
+	order, err := s.resolveOrder(ctx, undo, email, orderID, update)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if order.HoldID == "" {
+		return order, nil
+	}
+	// A hold that is no longer pending reserves nothing, so there is nothing
+	// to release and the order still closes.
+	_, err = s.releaseHold(ctx, undo, email, order.HoldID, models.HoldOrder)
+	if err != nil && !errors.Is(err, ErrHoldNotPending) {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// TriggerOrder marks an open stop order whose stop price the market reached.
+func (s *MongoStore) TriggerOrder(email string, orderID string) (models.Order, error) {
+	order := models.Order{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		var err error
+		order, err = s.resolveOrder(ctx, undo, email, orderID, bson.M{"triggered": true})
+		return err
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+// FillOrder executes an open order at price through the same steps as an
+// immediate buy or sell. A buy settles the hold of the order, whose funds pay
+// for it. Only one fill can succeed, since the order must still be open.
+func (s *MongoStore) FillOrder(email string, orderID string, price models.Money) (models.Order, error) {
+	order := models.Order{}
+	err := s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		var err error
+		order, err = s.resolveOrder(ctx, undo, email, orderID, bson.M{"status": models.OrderFilled, "fillPrice": price, "closedAt": s.clock.Now()})
+		if err != nil {
+			return err
+		}
+
+		if order.Side == models.OrderBuy {
+			released := models.Money{}
+			if order.HoldID != "" {
+				hold, err := s.resolveHold(ctx, undo, email, order.HoldID, models.HoldOrder, models.HoldSettled)
+				if err != nil {
+					return err
+				}
+				released = hold.Amount
+			}
+			share, err := s.buyShare(ctx, undo, email, fillShare(order, price), released)
+			if err != nil {
+				return err
+			}
+			order.ReferenceID = share.ShareID
+		} else {
+			result, err := s.sellShares(ctx, undo, email, orderSale(order), func(string) (models.Money, error) {
+				return price, nil
+			})
+			if err != nil {
+				return err
+			}
+			order.ReferenceID = result.SaleID
+		}
+
+		update := bson.M{"$set": bson.M{"referenceID": order.ReferenceID}}
+		_, err = getDBCollection("Orders", s.client).UpdateOne(ctx, bson.M{"_id": order.ID}, update)
+		if err != nil {
+			logger.Error("Unable to save fill of order: " + err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Order{}, err
+	}
+	logger.Info("Filled order " + orderID + " at " + price.String())
+	return order, nil
+}
+
+// GetOrders returns the orders of a user with status, or all of them when
+// status is empty, oldest first.
+func (s *MongoStore) GetOrders(email string, status models.OrderStatus) ([]models.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"userID": userID}
+	if status != "" {
+		filter["status"] = status
+	}
+	return s.findOrders(ctx, filter)
+}
+
+// GetOpenOrders returns the open orders of every user, oldest first.
+func (s *MongoStore) GetOpenOrders() ([]models.Order, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.findOrders(ctx, bson.M{"status": models.OrderOpen})
+}
+
+func (s *MongoStore) findOrders(ctx context.Context, filter bson.M) ([]models.Order, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := getDBCollection("Orders", s.client).Find(ctx, filter, opts)
+	if err != nil {
+		logger.Error("Unable to get orders: " + err.Error())
+		return nil, err
+	}
+	orders := []models.Order{}
+	err = cursor.All(ctx, &orders)
+	if err != nil {
+		logger.Error("Unable to decode orders: " + err.Error())
+		return nil, err
+	}
+	return orders, nil
+}
+
+// resolveOrder applies update to an open order of a user and returns it
+// afterwards. Only one request can close an order, since the update matches
+// open orders only.
+func (s *MongoStore) resolveOrder(ctx context.Context, undo *undoLog, email string, orderID string, update bson.M) (models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return models.Order{}, mongo.ErrNoDocuments
+	}
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	collection := getDBCollection("Orders", s.client)
+	filter := bson.M{"_id": id, "userID": userID, "status": models.OrderOpen}
+	before := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	previous := models.Order{}
+	err = collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": update}, before).Decode(&previous)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, err := collection.CountDocuments(ctx, bson.M{"_id": id, "userID": userID})
+		if err != nil {
+			return models.Order{}, err
+		}
+		if count > 0 {
+			return models.Order{}, ErrOrderNotOpen
+		}
+		return models.Order{}, mongo.ErrNoDocuments
+	}
+	if err != nil {
+		logger.Error("Unable to update order: " + err.Error())
+		return models.Order{}, err
+	}
+	undo.add(func(ctx context.Context) error {
+		_, err := collection.ReplaceOne(ctx, bson.M{"_id": id}, previous)
+		return err
+	})
+
+	order := models.Order{}
+	err = collection.FindOne(ctx, bson.M{"_id": id}).Decode(&order)
+	if err != nil {
+		logger.Error("Unable to get order: " + err.Error())
+		return models.Order{}, err
+	}
+	return order, nil
+}
+
+func (s *MemoryStore) PlaceOrder(email string, order models.Order) (models.Order, error) {
+	order, err := s.newOrder(email, order, s.clock.Now())
+	if err != nil {
+		return models.Order{}, err
+	}
+	hold := models.Hold{}
+	if order.Side == models.OrderBuy {
+		amount, err := s.reservation(order)
+		if err != nil {
+			return models.Order{}, err
+		}
+		hold, err = newHold(email, orderHold(order, amount), s.clock.Now())
+		if err != nil {
+			return models.Order{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.Order{}, mongo.ErrNoDocuments
+	}
+	if entry.user.AccountStatus.Blocked() {
+		return models.Order{}, ErrAccountBlocked
+	}
+	order.UserID = entry.id.Hex()
+	if order.Side == models.OrderBuy {
+		hold, err = s.placeHold(entry, hold)
+		if err != nil {
+			return models.Order{}, err
+		}
+		order.HoldID = hold.ID.Hex()
+	}
+	s.orders = append(s.orders, &order)
+	return order, nil
+}
+
+func (s *MemoryStore) CancelOrder(email string, orderID string) (models.Order, error) {
+	return s.CloseOrder(email, orderID, models.OrderCancelled, "")
+}
+
+func (s *MemoryStore) CloseOrder(email string, orderID string, status models.OrderStatus, reason string) (models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeOrder(email, orderID, status, reason)
+}
+
+// closeOrder is CloseOrder for a caller that holds s.mu.
+func (s *MemoryStore) closeOrder(email string, orderID string, status models.OrderStatus, reason string) (models.Order, error) {
+	entry, order, err := s.openOrder(email, orderID)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if order.HoldID != "" {
+		_, hold, err := s.pendingHold(email, order.HoldID, models.HoldOrder)
+		if err == nil {
+			releaseMemoryHold(entry, hold, s.clock.Now())
+		} else if !errors.Is(err, ErrHoldNotPending) {
+			return models.Order{}, err
+		}
+	}
+	closeMemoryOrder(order, status, s.clock.Now())
+	order.Reason = reason
+	return *order, nil
+}
+
+func (s *MemoryStore) TriggerOrder(email string, orderID string) (models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, order, err := s.openOrder(email, orderID)
+	if err != nil {
+		return models.Order{}, err
+	}
+	order.Triggered = true
+	return *order, nil
+}
+
+func (s *MemoryStore) FillOrder(email string, orderID string, price models.Money) (models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, order, err := s.openOrder(email, orderID)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	if order.Side == models.OrderBuy {
+		var hold *models.Hold
+		released := models.Money{}
+		if order.HoldID != "" {
+			_, hold, err = s.pendingHold(email, order.HoldID, models.HoldOrder)
+			if err != nil {
+				return models.Order{}, err
+			}
+			released = hold.Amount
+		}
+		share, err := s.buyShare(entry, fillShare(*order, price), released)
+		if err != nil {
+			return models.Order{}, err
+		}
+		if hold != nil {
+			resolveMemoryHold(hold, models.HoldSettled, s.clock.Now())
+		}
+		order.ReferenceID = share.ShareID
+	} else {
+		result, err := s.sellShares(entry, orderSale(*order), func(string) (models.Money, error) {
+			return price, nil
+		})
+		if err != nil {
+			return models.Order{}, err
+		}
+		order.ReferenceID = result.SaleID
+	}
+
+	closeMemoryOrder(order, models.OrderFilled, s.clock.Now())
+	order.FillPrice = &price
+	return *order, nil
+}
+
+func (s *MemoryStore) GetOrders(email string, status models.OrderStatus) ([]models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	userID := entry.id.Hex()
+	orders := []models.Order{}
+	for _, order := range s.orders {
+		if order.UserID == userID && (status == "" || order.Status == status) {
+			orders = append(orders, *order)
+		}
+	}
+	return orders, nil
+}
+
+func (s *MemoryStore) GetOpenOrders() ([]models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := []models.Order{}
+	for _, order := range s.orders {
+		if order.Status == models.OrderOpen {
+			orders = append(orders, *order)
+		}
+	}
+	return orders, nil
+}
+
+// openOrder finds an open order of a user. The caller holds s.mu.
+func (s *MemoryStore) openOrder(email string, orderID string) (*memoryUser, *models.Order, error) {
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, nil, mongo.ErrNoDocuments
+	}
+	userID := entry.id.Hex()
+	for _, order := range s.orders {
+		if order.UserID != userID || order.ID.Hex() != orderID {
+			continue
+		}
+		if order.Status != models.OrderOpen {
+			return nil, nil, ErrOrderNotOpen
+		}
+		return entry, order, nil
+	}
+	return nil, nil, mongo.ErrNoDocuments
+}
+
+func closeMemoryOrder(order *models.Order, status models.OrderStatus, now time.Time) {
+	order.Status = status
+	order.ClosedAt = &now
+}
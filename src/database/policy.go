@@ -0,0 +1,147 @@
+package src
+
+import (
+	"dbutil/src/config"
+	"dbutil/src/fees"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/quotes"
+	"math/big"
+	"time"
+)
+
+// depositWindow is the rolling period the daily deposit limit covers.
+const depositWindow = 24 * time.Hour
+
+const bpsPerUnit = 10000
+
+// policy holds the business rules from config.json that both stores enforce,
+// and the quotes that buys and sells execute at.
+type policy struct {
+	quotes         quotes.Provider
+	fees           fees.Schedule
+	maxSlippageBps int64
+	lotMatching    models.LotMatching
+	// maxDeposit and dailyDepositLimit are in minor units; zero means no
+	// limit.
+	maxDeposit        int64
+	dailyDepositLimit int64
+	// maxWatchlists and maxWatchlistSymbols are zero for no limit.
+	maxWatchlists       int
+	maxWatchlistSymbols int
+	// clock dates everything the store writes, and places the windows of
+	// trading fees and deposit limits.
+	clock models.Clock
+}
+
+func newPolicy(appConfig config.Configuration, provider quotes.Provider) policy {
+	lotMatching := models.LotMatching(appConfig.LotMatching)
+	if !lotMatching.Valid() || lotMatching == models.MatchSpecificLot {
+		if lotMatching != "" {
+			logger.Error("Unsupported default lot matching " + appConfig.LotMatching + ", using FIFO")
+		}
+		lotMatching = models.MatchFIFO
+	}
+	maxSlippageBps := appConfig.Quotes.MaxSlippageBps
+	if maxSlippageBps < 0 {
+		logger.Error("Ignoring negative quotes.maxSlippageBps")
+		maxSlippageBps = 0
+	}
+	return policy{
+		quotes:              provider,
+		fees:                fees.NewSchedule(appConfig.Fees),
+		maxSlippageBps:      maxSlippageBps,
+		lotMatching:         lotMatching,
+		maxDeposit:          parseLimit("deposits.maxAmount", appConfig.Deposits.MaxAmount),
+		dailyDepositLimit:   parseLimit("deposits.dailyLimit", appConfig.Deposits.DailyLimit),
+		maxWatchlists:       parseCount("watchlists.maxLists", appConfig.Watchlists.MaxLists),
+		maxWatchlistSymbols: parseCount("watchlists.maxSymbols", appConfig.Watchlists.MaxSymbols),
+		clock:               models.SystemClock{},
+	}
+}
+
+// SetClock replaces the clock that dates what the store writes and places
+// the fee and deposit windows, which is the system clock by default.
+func (p *policy) SetClock(clock models.Clock) {
+	p.clock = clock
+}
+
+// parseLimit reads an amount limit from config.json. Invalid limits are
+// ignored rather than refusing to start.
+func parseLimit(name string, value string) int64 {
+	if value == "" {
+		return 0
+	}
+	limit, err := models.ParseMoney(value, "")
+	if err != nil || !limit.IsPositive() {
+		logger.Error("Ignoring invalid " + name + " " + value)
+		return 0
+	}
+	return limit.Amount
+}
+
+// parseCount reads a count limit from config.json. Negative limits are
+// ignored like invalid amounts.
+func parseCount(name string, value int) int {
+	if value < 0 {
+		logger.Error("Ignoring negative " + name)
+		return 0
+	}
+	return value
+}
+
+// validateDeposit checks a deposit of amount against the limits, given what
+// the user deposited over the last depositWindow.
+func (p policy) validateDeposit(amount models.Money, deposited models.Money) error {
+	if !amount.IsPositive() {
+		return ErrInvalidAmount
+	}
+	if p.maxDeposit > 0 && amount.Amount > p.maxDeposit {
+		return ErrDepositTooLarge
+	}
+	if p.dailyDepositLimit > 0 && amount.Amount > p.dailyDepositLimit-deposited.Amount {
+		return ErrDailyDepositLimit
+	}
+	return nil
+}
+
+// tradeFee is the fee for trading quantity shares at price by a user who
+// already traded volume this month.
+func (p policy) tradeFee(price models.Money, quantity int, volume models.Money) (models.Money, error) {
+	notional, err := price.Mul(int64(quantity))
+	if err != nil {
+		return models.Money{}, err
+	}
+	return p.fees.Fee(notional, volume), nil
+}
+
+// executionPrice is the market price of symbol, provided it has not moved
+// further against the client than maxSlippageBps from the price they
+// expected. Buys are hurt by higher prices and sells by lower ones. The
+// expected price comes from the client, so the bounds are worked out in
+// big.Int where no expected price can overflow them.
+func (p policy) executionPrice(symbol string, expected models.Money, buying bool) (models.Money, error) {
+	if !expected.IsPositive() {
+		return models.Money{}, ErrMissingExpectedPrice
+	}
+	quote, err := p.quotes.Quote(symbol)
+	if err != nil {
+		logger.Error("Unable to price " + symbol + ": " + err.Error())
+		return models.Money{}, err
+	}
+	if !quote.Price.SameCurrency(expected) {
+		return models.Money{}, ErrCurrencyMismatch
+	}
+
+	price := new(big.Int).Mul(big.NewInt(quote.Price.Amount), big.NewInt(bpsPerUnit))
+	bps := bpsPerUnit - p.maxSlippageBps
+	if buying {
+		bps = bpsPerUnit + p.maxSlippageBps
+	}
+	bound := new(big.Int).Mul(big.NewInt(expected.Amount), big.NewInt(bps))
+	if buying && price.Cmp(bound) > 0 || !buying && price.Cmp(bound) < 0 {
+		logger.Error(symbol + " trades at " + quote.Price.String() + ", expected " + expected.String())
+		return models.Money{}, ErrSlippageExceeded
+	}
+	return quote.Price, nil
+}
@@ -0,0 +1,133 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (s *MongoStore) SaveRefreshToken(token models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("RefreshTokens", s.client)
+	_, err := collection.InsertOne(ctx, token)
+	if err != nil {
+		logger.Error("Unable to save refresh token: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *MongoStore) GetRefreshToken(tokenHash string) (models.RefreshToken, error) {
+	token := models.RefreshToken{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("RefreshTokens", s.client)
+	filter := bson.M{"tokenHash": bson.M{"$eq": tokenHash}}
+	err := collection.FindOne(ctx, filter).Decode(&token)
+	if err != nil {
+		logger.Error("Unable to get refresh token: " + err.Error())
+		return token, err
+	}
+	return token, nil
+}
+
+// RevokeRefreshToken revokes a single token and reports whether it was still
+// active. Only one of several concurrent callers can see true.
+func (s *MongoStore) RevokeRefreshToken(tokenHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("RefreshTokens", s.client)
+	filter := bson.M{"tokenHash": bson.M{"$eq": tokenHash}, "revoked": false}
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logger.Error("Unable to revoke refresh token: " + err.Error())
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (s *MongoStore) RevokeRefreshTokenFamily(familyID string) error {
+	return s.revokeRefreshTokens(bson.M{"familyID": bson.M{"$eq": familyID}})
+}
+
+func (s *MongoStore) RevokeRefreshTokens(email string) error {
+	return s.revokeRefreshTokens(bson.M{"email": bson.M{"$eq": email}})
+}
+
+func (s *MongoStore) revokeRefreshTokens(filter bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getDBCollection("RefreshTokens", s.client)
+	update := bson.M{"$set": bson.M{"revoked": true}}
+	_, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		logger.Error("Unable to revoke refresh tokens: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func (s *MemoryStore) SaveRefreshToken(token models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshTokens[token.TokenHash] = &token
+	return nil
+}
+
+func (s *MemoryStore) GetRefreshToken(tokenHash string) (models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok {
+		return models.RefreshToken{}, mongo.ErrNoDocuments
+	}
+	return *token, nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(tokenHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok || token.Revoked {
+		return false, nil
+	}
+	token.Revoked = true
+	return true, nil
+}
+
+func (s *MemoryStore) RevokeRefreshTokenFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeRefreshTokens(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.Email == email {
+			token.Revoked = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package src
+
+import (
+	"dbutil/src/config"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/quotes"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserStore covers the user account operations of the service.
+type UserStore interface {
+	GetUserHash(email string) (string, error)
+	GetDbIdByEmail(email string) (string, error)
+	CheckIfEmailExists(email string) (bool, error)
+	SaveNewUser(user models.User) (*mongo.InsertOneResult, error)
+	GetUserData(email string) (models.User, error)
+	ListUserEmails() ([]string, error)
+	DeleteUserFromDB(email string) (*mongo.DeleteResult, error)
+	ChangeAccountStatus(email string, change models.StatusChange) (models.StatusChange, error)
+	GetStatusHistory(email string) ([]models.StatusChange, error)
+	ConfirmUserEmail(email string) (*mongo.UpdateResult, error)
+	GetBalance(email string) (models.AccountBalance, error)
+	UpdateBalance(email string, change models.BalanceChange) (models.LedgerEntry, error)
+}
+
+// ShareStore covers buying and selling shares on behalf of a user.
+type ShareStore interface {
+	SaveBaughtShare(email string, share models.Share) (*mongo.UpdateResult, error)
+	UpdateShareToSold(email string, share models.Share) (*mongo.UpdateResult, error)
+	SellShares(email string, order models.SellOrder) (models.SellResult, error)
+	GetSoldIndicator(email string, shareID string) (string, error)
+	GetLots(email string) ([]models.Share, error)
+	GetHoldings(email string) ([]models.Share, error)
+	GetHoldingsBySymbol(email string, symbol string) ([]models.Share, error)
+	SummarizeLots(email string) ([]models.LotSummary, error)
+}
+
+// HoldStore reserves funds until they are settled or released.
+type HoldStore interface {
+	PlaceHold(email string, hold models.Hold) (models.Hold, error)
+	SettleHold(email string, holdID string) (models.Hold, error)
+	CancelHold(email string, holdID string) (models.Hold, error)
+	GetHolds(email string, status models.HoldStatus) ([]models.Hold, error)
+}
+
+// OrderStore keeps the orders that wait for the market to reach their price.
+type OrderStore interface {
+	PlaceOrder(email string, order models.Order) (models.Order, error)
+	CancelOrder(email string, orderID string) (models.Order, error)
+	GetOrders(email string, status models.OrderStatus) ([]models.Order, error)
+	GetOpenOrders() ([]models.Order, error)
+	TriggerOrder(email string, orderID string) (models.Order, error)
+	FillOrder(email string, orderID string, price models.Money) (models.Order, error)
+	CloseOrder(email string, orderID string, status models.OrderStatus, reason string) (models.Order, error)
+}
+
+// CorporateActionStore applies splits and dividends to every holder of a
+// symbol.
+type CorporateActionStore interface {
+	ApplyCorporateAction(action models.CorporateAction) (models.CorporateAction, error)
+	GetCorporateActions(symbol string) ([]models.CorporateAction, error)
+}
+
+// WatchlistStore keeps the symbols users follow.
+type WatchlistStore interface {
+	CreateWatchlist(email string, watchlist models.Watchlist) (models.Watchlist, error)
+	GetWatchlists(email string) ([]models.Watchlist, error)
+	GetWatchlist(email string, watchlistID string) (models.Watchlist, error)
+	UpdateWatchlist(email string, watchlistID string, watchlist models.Watchlist) (models.Watchlist, error)
+	DeleteWatchlist(email string, watchlistID string) error
+}
+
+// AlertStore keeps the alerts the alert worker evaluates.
+type AlertStore interface {
+	CreateAlert(email string, alert models.Alert) (models.Alert, error)
+	GetAlerts(email string, status models.AlertStatus) ([]models.Alert, error)
+	CancelAlert(email string, alertID string) (models.Alert, error)
+	GetActiveAlerts() ([]models.Alert, error)
+	SetAlertBaseline(alertID string, baseline models.Money, at time.Time) error
+	// TriggerAlert marks an active alert triggered. Only one call succeeds
+	// for an alert; the others get ErrAlertNotActive.
+	TriggerAlert(alertID string, value models.Money) (models.Alert, error)
+}
+
+// LedgerStore reads the ledger that every balance change is recorded in.
+type LedgerStore interface {
+	GetLedger(email string, after string, limit int) (models.LedgerPage, error)
+	GetTransactions(email string, query models.TransactionQuery) (models.TransactionPage, error)
+	VerifyBalance(email string) (models.BalanceVerification, error)
+	// GetAccountSnapshot reads a user, their ledger and their pending holds
+	// together, so that a concurrent trade can not make them disagree.
+	GetAccountSnapshot(email string) (models.AccountSnapshot, error)
+}
+
+// IdempotencyStore keeps the responses to requests sent with an
+// Idempotency-Key.
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey saves record unless its key is already in use by
+	// the same user, in which case the existing record is returned with
+	// reserved set to false.
+	ReserveIdempotencyKey(record models.IdempotencyRecord) (existing models.IdempotencyRecord, reserved bool, err error)
+	CompleteIdempotencyKey(record models.IdempotencyRecord) error
+	ReleaseIdempotencyKey(email string, key string) error
+}
+
+// ConfirmationStore keeps the email confirmation tokens handed out at
+// registration.
+type ConfirmationStore interface {
+	SaveConfirmationToken(token models.ConfirmationToken) error
+	GetConfirmationToken(tokenHash string) (models.ConfirmationToken, error)
+	DeleteConfirmationTokens(email string) error
+	DeleteExpiredConfirmationTokens(now time.Time) (int64, error)
+}
+
+// RefreshTokenStore keeps the refresh tokens issued to signed in users.
+type RefreshTokenStore interface {
+	SaveRefreshToken(token models.RefreshToken) error
+	GetRefreshToken(tokenHash string) (models.RefreshToken, error)
+	RevokeRefreshToken(tokenHash string) (bool, error)
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeRefreshTokens(email string) error
+}
+
+// Store is everything the handlers need from the persistence layer.
+type Store interface {
+	UserStore
+	ShareStore
+	HoldStore
+	OrderStore
+	CorporateActionStore
+	WatchlistStore
+	AlertStore
+	LedgerStore
+	IdempotencyStore
+	ConfirmationStore
+	RefreshTokenStore
+}
+
+var (
+	_ Store = (*MongoStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// NewStore returns the Store implementation selected by the "store" setting
+// of config.json. An empty setting falls back to MongoDB. Buys and sells are
+// priced by provider.
+func NewStore(appConfig config.Configuration, provider quotes.Provider) (Store, error) {
+	switch appConfig.Store {
+	case "memory":
+		logger.Info("Using in-memory store")
+		return NewMemoryStore(appConfig, provider), nil
+	case "", "mongo":
+		client, err := ConnectToDB()
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Connected to mongodb...")
+		err = ensureIndexes(client)
+		if err != nil {
+			return nil, err
+		}
+		return NewMongoStore(client, appConfig, provider), nil
+	}
+	return nil, fmt.Errorf("Unknown store type %q", appConfig.Store)
+}
+
+func AuthenticateUserOnDB(email string, password string, store UserStore) error {
+	hashedPassword := []byte(password)
+	hash, err := store.GetUserHash(email)
+	if err != nil {
+		logger.Error("Unable to get user hash.")
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), hashedPassword)
+	if err != nil {
+		logger.Error("Unable to authenticate the user")
+		return err
+	}
+	logger.Info("User has been authenticated successfully.")
+	return nil
+}
@@ -0,0 +1,78 @@
+package src
+
+import (
+	"dbutil/src/models"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	timePointerType = reflect.TypeOf((*time.Time)(nil))
+)
+
+// timestampRegistry decodes dates like the default registry, and also the
+// strings older versions stored in the date fields of users and lots, so
+// those stay readable until the "timestamps" migration rewrote them. An empty
+// string is the zero time, or nil for a *time.Time.
+func timestampRegistry() *bsoncodec.Registry {
+	timeCodec := bsoncodec.NewTimeCodec()
+	decodeTime := func(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if vr.Type() != bsontype.String {
+			return timeCodec.DecodeValue(dc, vr, val)
+		}
+		parsed, err := readTimestamp(vr)
+		if err != nil {
+			return err
+		}
+		val.Set(reflect.ValueOf(parsed))
+		return nil
+	}
+	decodeTimePointer := func(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		switch vr.Type() {
+		case bsontype.Null:
+			val.Set(reflect.Zero(timePointerType))
+			return vr.ReadNull()
+		case bsontype.String:
+			parsed, err := readTimestamp(vr)
+			if err != nil {
+				return err
+			}
+			if parsed.IsZero() {
+				val.Set(reflect.Zero(timePointerType))
+			} else {
+				val.Set(reflect.ValueOf(&parsed))
+			}
+			return nil
+		}
+		elem := reflect.New(timeType)
+		err := timeCodec.DecodeValue(dc, vr, elem.Elem())
+		if err != nil {
+			return err
+		}
+		val.Set(elem)
+		return nil
+	}
+
+	return bson.NewRegistryBuilder().
+		RegisterTypeDecoder(timeType, bsoncodec.ValueDecoderFunc(decodeTime)).
+		RegisterTypeDecoder(timePointerType, bsoncodec.ValueDecoderFunc(decodeTimePointer)).
+		Build()
+}
+
+func readTimestamp(vr bsonrw.ValueReader) (time.Time, error) {
+	value, err := vr.ReadString()
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+	parsed, err := models.ParseTimestamp(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.UTC(), nil
+}
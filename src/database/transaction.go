@@ -0,0 +1,93 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// undoLog collects the compensating writes of an operation that runs without
+// a transaction, so they can be replayed if a later step fails.
+type undoLog struct {
+	steps []func(ctx context.Context) error
+}
+
+func (u *undoLog) add(step func(ctx context.Context) error) {
+	u.steps = append(u.steps, step)
+}
+
+// rollback replays the registered compensations in reverse order. It keeps
+// going after a failure so that as much as possible is restored.
+func (u *undoLog) rollback() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var firstErr error
+	for i := len(u.steps) - 1; i >= 0; i-- {
+		err := u.steps[i](ctx)
+		if err != nil {
+			logger.Error("Unable to roll back step: " + err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// supportsTransactions reports whether the deployment behind client is a
+// replica set or a sharded cluster. Standalone servers reject transactions.
+func supportsTransactions(client *mongo.Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	hello := struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}{}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		logger.Error("Unable to detect deployment type, assuming standalone: " + err.Error())
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// runTransaction runs fn inside a session transaction so that all of its
+// writes are committed or aborted together. On a standalone server fn runs
+// directly instead, and the compensations it registered on the undoLog are
+// replayed when it returns an error. Compensations are ignored when a real
+// transaction is available, because aborting already discards the writes.
+func (s *MongoStore) runTransaction(fn func(ctx context.Context, undo *undoLog) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !s.transactions {
+		undo := &undoLog{}
+		err := fn(ctx, undo)
+		if err != nil {
+			rollbackErr := undo.rollback()
+			if rollbackErr != nil {
+				logger.Error("Balance and holdings may be inconsistent: " + rollbackErr.Error())
+				return fmt.Errorf("%w: %v (rollback failed: %v)", ErrRollbackFailed, err, rollbackErr)
+			}
+		}
+		return err
+	}
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		logger.Error("Unable to start session " + err.Error())
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc, &undoLog{})
+	})
+	return err
+}
@@ -0,0 +1,181 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// transactionTypes are the ledger entries a query of type matches.
+func transactionTypes(entryType models.LedgerEntryType) ([]models.LedgerEntryType, error) {
+	switch entryType {
+	case "":
+		return []models.LedgerEntryType{models.LedgerBuy, models.LedgerSell}, nil
+	case models.LedgerBuy, models.LedgerSell:
+		return []models.LedgerEntryType{entryType}, nil
+	}
+	return nil, ErrInvalidTransactionType
+}
+
+// matchesTransaction reports whether a ledger entry is one of the
+// transactions query selects, leaving the cursor aside.
+func matchesTransaction(entry models.LedgerEntry, types []models.LedgerEntryType, query models.TransactionQuery) bool {
+	typeMatches := false
+	for _, entryType := range types {
+		typeMatches = typeMatches || entry.Type == entryType
+	}
+	if !typeMatches || query.Symbol != "" && entry.Symbol != query.Symbol {
+		return false
+	}
+	if query.From != nil && entry.CreatedAt.Before(*query.From) {
+		return false
+	}
+	return query.To == nil || entry.CreatedAt.Before(*query.To)
+}
+
+func newTransactionPage(entries []models.LedgerEntry, limit int) models.TransactionPage {
+	page := models.TransactionPage{Transactions: []models.Transaction{}}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		page.NextCursor = entries[limit-1].ID.Hex()
+	}
+	for _, entry := range entries {
+		page.Transactions = append(page.Transactions, models.NewTransaction(entry))
+	}
+	return page
+}
+
+// GetTransactions returns a page of the buys and sells of a user that query
+// selects, in ledger order. Trades that were reversed are left out.
+func (s *MongoStore) GetTransactions(email string, query models.TransactionQuery) (models.TransactionPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	types, err := transactionTypes(query.Type)
+	if err != nil {
+		return models.TransactionPage{}, err
+	}
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return models.TransactionPage{}, err
+	}
+
+	collection := getDBCollection("Ledger", s.client)
+	referenceIDs, err := collection.Distinct(ctx, "referenceID", bson.M{"userID": userID, "type": models.LedgerReversal})
+	if err != nil {
+		logger.Error("Unable to get reversals of user: " + err.Error())
+		return models.TransactionPage{}, err
+	}
+	reversed := []primitive.ObjectID{}
+	for _, referenceID := range referenceIDs {
+		hex, _ := referenceID.(string)
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err == nil {
+			reversed = append(reversed, id)
+		}
+	}
+
+	ids := bson.M{"$nin": reversed}
+	filter := bson.M{"userID": userID, "type": bson.M{"$in": types}, "_id": ids}
+	if query.Symbol != "" {
+		filter["symbol"] = query.Symbol
+	}
+	createdAt := bson.M{}
+	if query.From != nil {
+		createdAt["$gte"] = *query.From
+	}
+	if query.To != nil {
+		createdAt["$lt"] = *query.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+	if query.After != "" {
+		cursorID, err := primitive.ObjectIDFromHex(query.After)
+		if err != nil {
+			return models.TransactionPage{}, ErrInvalidCursor
+		}
+		if query.Descending {
+			ids["$lt"] = cursorID
+		} else {
+			ids["$gt"] = cursorID
+		}
+	}
+
+	limit := ledgerPageSize(query.Limit)
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: direction}}).SetLimit(int64(limit) + 1)
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		logger.Error("Unable to get transactions of user: " + err.Error())
+		return models.TransactionPage{}, err
+	}
+	entries := []models.LedgerEntry{}
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		logger.Error("Unable to decode transactions of user: " + err.Error())
+		return models.TransactionPage{}, err
+	}
+	return newTransactionPage(entries, limit), nil
+}
+
+func (s *MemoryStore) GetTransactions(email string, query models.TransactionQuery) (models.TransactionPage, error) {
+	types, err := transactionTypes(query.Type)
+	if err != nil {
+		return models.TransactionPage{}, err
+	}
+	cursorID := primitive.NilObjectID
+	if query.After != "" {
+		cursorID, err = primitive.ObjectIDFromHex(query.After)
+		if err != nil {
+			return models.TransactionPage{}, ErrInvalidCursor
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.TransactionPage{}, mongo.ErrNoDocuments
+	}
+	userID := entry.id.Hex()
+	reversed := make(map[string]bool)
+	for _, ledgerEntry := range s.ledger {
+		if ledgerEntry.UserID == userID && ledgerEntry.Type == models.LedgerReversal {
+			reversed[ledgerEntry.ReferenceID] = true
+		}
+	}
+
+	limit := ledgerPageSize(query.Limit)
+	entries := []models.LedgerEntry{}
+	for i := range s.ledger {
+		ledgerEntry := s.ledger[i]
+		if query.Descending {
+			ledgerEntry = s.ledger[len(s.ledger)-1-i]
+		}
+		if ledgerEntry.UserID != userID || reversed[ledgerEntry.ID.Hex()] || !matchesTransaction(ledgerEntry, types, query) {
+			continue
+		}
+		if query.After != "" {
+			id := ledgerEntry.ID.Hex()
+			if !query.Descending && id <= cursorID.Hex() || query.Descending && id >= cursorID.Hex() {
+				continue
+			}
+		}
+		entries = append(entries, ledgerEntry)
+		if len(entries) > limit {
+			break
+		}
+	}
+	return newTransactionPage(entries, limit), nil
+}
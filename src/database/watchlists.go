@@ -0,0 +1,310 @@
+package src
+
+import (
+	"context"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxWatchlistNameLength = 100
+
+// newWatchlist validates the name and items of a watchlist that is about to
+// be saved. Symbols are upper-cased, as quotes are looked up, and may only be
+// listed once.
+func (p policy) newWatchlist(watchlist models.Watchlist) (models.Watchlist, error) {
+	name := strings.TrimSpace(watchlist.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxWatchlistNameLength {
+		return models.Watchlist{}, ErrInvalidWatchlistName
+	}
+	items := make([]models.WatchlistItem, 0, len(watchlist.Items))
+	seen := make(map[string]bool, len(watchlist.Items))
+	for _, item := range watchlist.Items {
+		symbol := strings.ToUpper(strings.TrimSpace(item.Symbol))
+		if symbol == "" {
+			return models.Watchlist{}, ErrMissingSymbol
+		}
+		if seen[symbol] {
+			return models.Watchlist{}, ErrDuplicateSymbol
+		}
+		seen[symbol] = true
+		items = append(items, models.WatchlistItem{Symbol: symbol, Note: strings.TrimSpace(item.Note)})
+	}
+	if p.maxWatchlistSymbols > 0 && len(items) > p.maxWatchlistSymbols {
+		return models.Watchlist{}, ErrWatchlistTooLarge
+	}
+	return models.Watchlist{Name: name, Items: items}, nil
+}
+
+// CreateWatchlist saves a new watchlist of a user, unless they already have
+// as many as watchlists.maxLists allows or one of the same name.
+func (s *MongoStore) CreateWatchlist(email string, watchlist models.Watchlist) (models.Watchlist, error) {
+	watchlist, err := s.newWatchlist(watchlist)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+
+	err = s.runTransaction(func(ctx context.Context, undo *undoLog) error {
+		userID, err := s.dbIDByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+		collection := getDBCollection("Watchlists", s.client)
+		if s.maxWatchlists > 0 {
+			count, err := collection.CountDocuments(ctx, bson.M{"userID": userID})
+			if err != nil {
+				logger.Error("Unable to count watchlists of user: " + err.Error())
+				return err
+			}
+			if count >= int64(s.maxWatchlists) {
+				return ErrTooManyWatchlists
+			}
+		}
+
+		now := s.clock.Now()
+		watchlist.ID = primitive.NewObjectID()
+		watchlist.UserID = userID
+		watchlist.CreatedAt = now
+		watchlist.UpdatedAt = now
+		_, err = collection.InsertOne(ctx, watchlist)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrWatchlistExists
+		}
+		if err != nil {
+			logger.Error("Unable to save watchlist: " + err.Error())
+			return err
+		}
+		undo.add(func(ctx context.Context) error {
+			_, err := collection.DeleteOne(ctx, bson.M{"_id": watchlist.ID})
+			return err
+		})
+		return nil
+	})
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+	return watchlist, nil
+}
+
+// GetWatchlists returns the watchlists of a user, oldest first.
+func (s *MongoStore) GetWatchlists(email string) ([]models.Watchlist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := getDBCollection("Watchlists", s.client).Find(ctx, bson.M{"userID": userID}, opts)
+	if err != nil {
+		logger.Error("Unable to get watchlists of user: " + err.Error())
+		return nil, err
+	}
+	watchlists := []models.Watchlist{}
+	err = cursor.All(ctx, &watchlists)
+	if err != nil {
+		logger.Error("Unable to decode watchlists of user: " + err.Error())
+		return nil, err
+	}
+	return watchlists, nil
+}
+
+func (s *MongoStore) GetWatchlist(email string, watchlistID string) (models.Watchlist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := s.watchlistFilter(ctx, email, watchlistID)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+	watchlist := models.Watchlist{}
+	err = getDBCollection("Watchlists", s.client).FindOne(ctx, filter).Decode(&watchlist)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+	return watchlist, nil
+}
+
+// UpdateWatchlist replaces the name and items of a watchlist of a user.
+func (s *MongoStore) UpdateWatchlist(email string, watchlistID string, watchlist models.Watchlist) (models.Watchlist, error) {
+	watchlist, err := s.newWatchlist(watchlist)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := s.watchlistFilter(ctx, email, watchlistID)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+	update := bson.M{"$set": bson.M{"name": watchlist.Name, "items": watchlist.Items, "updatedAt": s.clock.Now()}}
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	updated := models.Watchlist{}
+	err = getDBCollection("Watchlists", s.client).FindOneAndUpdate(ctx, filter, update, after).Decode(&updated)
+	if mongo.IsDuplicateKeyError(err) {
+		return models.Watchlist{}, ErrWatchlistExists
+	}
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+	return updated, nil
+}
+
+func (s *MongoStore) DeleteWatchlist(email string, watchlistID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := s.watchlistFilter(ctx, email, watchlistID)
+	if err != nil {
+		return err
+	}
+	result, err := getDBCollection("Watchlists", s.client).DeleteOne(ctx, filter)
+	if err != nil {
+		logger.Error("Unable to delete watchlist: " + err.Error())
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// watchlistFilter matches one watchlist of a user. Ids that are not ours
+// match nothing.
+func (s *MongoStore) watchlistFilter(ctx context.Context, email string, watchlistID string) (bson.M, error) {
+	id, err := primitive.ObjectIDFromHex(watchlistID)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	userID, err := s.dbIDByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"_id": id, "userID": userID}, nil
+}
+
+func (s *MemoryStore) CreateWatchlist(email string, watchlist models.Watchlist) (models.Watchlist, error) {
+	watchlist, err := s.newWatchlist(watchlist)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return models.Watchlist{}, mongo.ErrNoDocuments
+	}
+	userID := entry.id.Hex()
+	count := 0
+	for _, existing := range s.watchlists {
+		if existing.UserID != userID {
+			continue
+		}
+		if existing.Name == watchlist.Name {
+			return models.Watchlist{}, ErrWatchlistExists
+		}
+		count++
+	}
+	if s.maxWatchlists > 0 && count >= s.maxWatchlists {
+		return models.Watchlist{}, ErrTooManyWatchlists
+	}
+
+	now := s.clock.Now()
+	watchlist.ID = primitive.NewObjectID()
+	watchlist.UserID = userID
+	watchlist.CreatedAt = now
+	watchlist.UpdatedAt = now
+	s.watchlists = append(s.watchlists, &watchlist)
+	return watchlist, nil
+}
+
+func (s *MemoryStore) GetWatchlists(email string) ([]models.Watchlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.users[email]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	watchlists := []models.Watchlist{}
+	for _, watchlist := range s.watchlists {
+		if watchlist.UserID == entry.id.Hex() {
+			watchlists = append(watchlists, *watchlist)
+		}
+	}
+	return watchlists, nil
+}
+
+func (s *MemoryStore) GetWatchlist(email string, watchlistID string) (models.Watchlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, watchlist, err := s.findWatchlist(email, watchlistID)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+	return *watchlist, nil
+}
+
+func (s *MemoryStore) UpdateWatchlist(email string, watchlistID string, watchlist models.Watchlist) (models.Watchlist, error) {
+	watchlist, err := s.newWatchlist(watchlist)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, existing, err := s.findWatchlist(email, watchlistID)
+	if err != nil {
+		return models.Watchlist{}, err
+	}
+	for j, other := range s.watchlists {
+		if j != i && other.UserID == existing.UserID && other.Name == watchlist.Name {
+			return models.Watchlist{}, ErrWatchlistExists
+		}
+	}
+	existing.Name = watchlist.Name
+	existing.Items = watchlist.Items
+	existing.UpdatedAt = s.clock.Now()
+	return *existing, nil
+}
+
+func (s *MemoryStore) DeleteWatchlist(email string, watchlistID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, _, err := s.findWatchlist(email, watchlistID)
+	if err != nil {
+		return err
+	}
+	s.watchlists = append(s.watchlists[:i], s.watchlists[i+1:]...)
+	return nil
+}
+
+// findWatchlist finds a watchlist of a user and its index in s.watchlists.
+// The caller holds s.mu.
+func (s *MemoryStore) findWatchlist(email string, watchlistID string) (int, *models.Watchlist, error) {
+	entry, ok := s.users[email]
+	if !ok {
+		return 0, nil, mongo.ErrNoDocuments
+	}
+	for i, watchlist := range s.watchlists {
+		if watchlist.UserID == entry.id.Hex() && watchlist.ID.Hex() == watchlistID {
+			return i, watchlist, nil
+		}
+	}
+	return 0, nil, mongo.ErrNoDocuments
+}
@@ -0,0 +1,112 @@
+package fees
+
+import (
+	"dbutil/src/config"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const bpsPerUnit = 10000
+
+// Tier is the percentage charged once a user's trading volume this month
+// reaches Volume, in minor units.
+type Tier struct {
+	Volume int64
+	Bps    int64
+}
+
+// Schedule prices trades: a flat fee plus a percentage of the notional value,
+// with the percentage lowered by volume tiers, and never less than Minimum.
+// Amounts are in minor units of the currency of the trade. The zero Schedule
+// charges nothing.
+type Schedule struct {
+	Flat    int64
+	Bps     int64
+	Minimum int64
+	// Tiers are sorted by Volume.
+	Tiers []Tier
+}
+
+// NewSchedule reads the "fees" section of config.json. Invalid entries are
+// ignored rather than refusing to start, like the deposit limits.
+func NewSchedule(feesConfig config.FeesConfig) Schedule {
+	schedule := Schedule{
+		Flat:    parseAmount("fees.flat", feesConfig.Flat),
+		Bps:     parseBps("fees.percentBps", feesConfig.PercentBps),
+		Minimum: parseAmount("fees.minimum", feesConfig.Minimum),
+	}
+	for _, tier := range feesConfig.Tiers {
+		volume := parseAmount("fees.tiers.monthlyVolume", tier.MonthlyVolume)
+		if volume == 0 {
+			continue
+		}
+		schedule.Tiers = append(schedule.Tiers, Tier{Volume: volume, Bps: parseBps("fees.tiers.percentBps", tier.PercentBps)})
+	}
+	sort.SliceStable(schedule.Tiers, func(i, j int) bool {
+		return schedule.Tiers[i].Volume < schedule.Tiers[j].Volume
+	})
+	return schedule
+}
+
+// Fee is what a trade worth notional costs a user who already traded volume
+// this month. It never exceeds notional, so a sale always credits something.
+func (s Schedule) Fee(notional models.Money, volume models.Money) models.Money {
+	if notional.Amount <= 0 {
+		return models.NewMoney(0, notional.Currency)
+	}
+	// The percentage is taken of whole units and of the rest separately, so
+	// that multiplying by the rate can not overflow.
+	rate := s.rate(volume.Amount)
+	whole, rest := notional.Amount/bpsPerUnit, notional.Amount%bpsPerUnit
+	fee := s.Flat + whole*rate + (rest*rate+bpsPerUnit/2)/bpsPerUnit
+	if fee < s.Minimum {
+		fee = s.Minimum
+	}
+	if fee > notional.Amount {
+		fee = notional.Amount
+	}
+	return models.NewMoney(fee, notional.Currency)
+}
+
+// rate is the percentage, in basis points, of the highest tier volume
+// reached.
+func (s Schedule) rate(volume int64) int64 {
+	bps := s.Bps
+	for _, tier := range s.Tiers {
+		if volume < tier.Volume {
+			break
+		}
+		bps = tier.Bps
+	}
+	return bps
+}
+
+// MonthStart is the start of the UTC calendar month of t, from which the
+// monthly volume counts.
+func MonthStart(t time.Time) time.Time {
+	year, month, _ := t.UTC().Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func parseAmount(name string, value string) int64 {
+	if value == "" {
+		return 0
+	}
+	amount, err := models.ParseMoney(value, "")
+	if err != nil || amount.IsNegative() {
+		logger.Error("Ignoring invalid " + name + " " + value)
+		return 0
+	}
+	return amount.Amount
+}
+
+func parseBps(name string, bps int64) int64 {
+	if bps < 0 || bps > bpsPerUnit {
+		logger.Error("Ignoring invalid " + name + " " + strconv.FormatInt(bps, 10))
+		return 0
+	}
+	return bps
+}
@@ -0,0 +1,82 @@
+package fees_test
+
+import (
+	"dbutil/src/config"
+	"dbutil/src/fees"
+	"dbutil/src/models"
+	"dbutil/src/testutil"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFeeFollowsTiers(t *testing.T) {
+	schedule := fees.NewSchedule(testutil.Fees)
+	tests := []struct {
+		notional string
+		volume   string
+		fee      string
+	}{
+		{"300.00", "0.00", "1.00"},
+		{"2000.00", "0.00", "2.00"},
+		{"2000.00", "999.99", "2.00"},
+		{"2000.00", "1000.00", "1.00"},
+		{"5000.00", "1000.00", "2.50"},
+		{"0.50", "0.00", "0.50"},
+		{"0.00", "0.00", "0.00"},
+	}
+	for _, test := range tests {
+		fee := schedule.Fee(testutil.USD(t, test.notional), testutil.USD(t, test.volume))
+		if fee != testutil.USD(t, test.fee) {
+			t.Errorf("fee of %s after %s is %s, want %s", test.notional, test.volume, fee, test.fee)
+		}
+	}
+}
+
+func TestFeeAddsFlatAndRoundsHalfUp(t *testing.T) {
+	schedule := fees.NewSchedule(config.FeesConfig{Flat: "0.50", PercentBps: 25})
+
+	// 0.25% of 10.10 is 0.02525, which rounds to 0.03.
+	fee := schedule.Fee(testutil.USD(t, "10.10"), testutil.USD(t, "0.00"))
+	if fee != testutil.USD(t, "0.53") {
+		t.Fatalf("fee is %s, want 0.53", fee)
+	}
+}
+
+func TestFeeOfLargestNotionalDoesNotOverflow(t *testing.T) {
+	schedule := fees.NewSchedule(config.FeesConfig{PercentBps: 10000})
+	notional := models.NewMoney(math.MaxInt64, "USD")
+
+	fee := schedule.Fee(notional, notional)
+	if fee != notional {
+		t.Fatalf("fee of 100%% of %s is %s, want all of it", notional, fee)
+	}
+}
+
+func TestNewScheduleIgnoresInvalidEntries(t *testing.T) {
+	schedule := fees.NewSchedule(config.FeesConfig{
+		Flat:       "-1.00",
+		PercentBps: 20000,
+		Minimum:    "abc",
+		Tiers: []config.FeeTierConfig{
+			{MonthlyVolume: "500.00", PercentBps: 5},
+			{MonthlyVolume: "0", PercentBps: 1},
+			{MonthlyVolume: "100.00", PercentBps: 8},
+		},
+	})
+
+	if schedule.Flat != 0 || schedule.Bps != 0 || schedule.Minimum != 0 {
+		t.Fatalf("schedule is %+v, want the invalid amounts ignored", schedule)
+	}
+	if len(schedule.Tiers) != 2 || schedule.Tiers[0].Volume != 10000 || schedule.Tiers[1].Volume != 50000 {
+		t.Fatalf("tiers are %+v, want the two valid tiers by volume", schedule.Tiers)
+	}
+}
+
+func TestMonthStart(t *testing.T) {
+	east := time.FixedZone("UTC+3", 3*60*60)
+	start := fees.MonthStart(time.Date(2026, time.April, 1, 1, 0, 0, 0, east))
+	if !start.Equal(time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("month starts %s, want the UTC month of March", start)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"dbutil/src/auth"
+	db "dbutil/src/database"
+	"dbutil/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type statusRequest struct {
+	Status models.AccountStatus `json:"status"`
+	Reason string               `json:"reason"`
+}
+
+// ChangeAccountStatus moves the account {user} to the status in the body, if
+// the transition table allows it. The caller is recorded as the actor.
+func ChangeAccountStatus(store db.UserStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		email := mux.Vars(r)["user"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		body := statusRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(rw, "Failed while parsing the status: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		actor, _ := auth.CallerEmail(r.Context())
+		change, err := store.ChangeAccountStatus(email, models.StatusChange{To: body.Status, Actor: actor, Reason: body.Reason})
+		if err != nil {
+			writeAccountStatusError(rw, err)
+			return
+		}
+		writeAccountStatus(rw, change)
+	}
+}
+
+// GetStatusHistory lists the status changes of the account {user}.
+func GetStatusHistory(store db.UserStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		email := mux.Vars(r)["user"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		history, err := store.GetStatusHistory(email)
+		if err != nil {
+			writeAccountStatusError(rw, err)
+			return
+		}
+		writeAccountStatus(rw, history)
+	}
+}
+
+// UpdateUserStatus lets users close their own account. Every other change of
+// status is made by an administrator through ChangeAccountStatus.
+func UpdateUserStatus(store db.UserStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		status := models.AccountStatus(params["status"])
+		email := params["email"]
+		if status == "" || email == "" {
+			http.Error(rw, "email or status is not present in the url", http.StatusBadRequest)
+			return
+		}
+		if status != models.AccountStatusClosed {
+			http.Error(rw, "Users may only close their account.", http.StatusForbidden)
+			return
+		}
+
+		change := models.StatusChange{To: status, Actor: email, Reason: "Closed by the account holder."}
+		change, err := store.ChangeAccountStatus(email, change)
+		if err != nil {
+			writeAccountStatusError(rw, err)
+			return
+		}
+		writeAccountStatus(rw, change)
+	}
+}
+
+func writeAccountStatus(rw http.ResponseWriter, body interface{}) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(rw).Encode(body)
+}
+
+func writeAccountStatusError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		http.Error(rw, "User does not exist.", http.StatusNotFound)
+	case errors.Is(err, db.ErrInvalidAccountStatus):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, db.ErrStatusTransition), errors.Is(err, db.ErrStatusChanged):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	default:
+		http.Error(rw, "Unable to update the status of the account.", http.StatusInternalServerError)
+	}
+}
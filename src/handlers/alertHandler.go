@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	db "dbutil/src/database"
+	"dbutil/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type alertRequest struct {
+	Kind     models.AlertKind `json:"kind"`
+	Symbol   string           `json:"symbol"`
+	Price    *models.Money    `json:"price"`
+	Bps      int64            `json:"bps"`
+	Currency string           `json:"currency"`
+}
+
+// CreateAlert registers an alert: "price_above" or "price_below" a price for a
+// symbol, or "portfolio_down" or "portfolio_up" by bps basis points in a day.
+func CreateAlert(store db.AlertStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		email := mux.Vars(r)["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		body := alertRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(rw, "Failed while parsing the alert: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		alert, err := store.CreateAlert(email, models.Alert{
+			Kind:     body.Kind,
+			Symbol:   body.Symbol,
+			Price:    body.Price,
+			Bps:      body.Bps,
+			Currency: body.Currency,
+		})
+		if err != nil {
+			writeAlertError(rw, err)
+			return
+		}
+		writeAlert(rw, http.StatusCreated, alert)
+	}
+}
+
+// GetAlerts lists the alerts of a user, optionally only those with ?status=.
+func GetAlerts(store db.AlertStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		email := mux.Vars(r)["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		list, err := store.GetAlerts(email, models.AlertStatus(r.URL.Query().Get("status")))
+		if err != nil {
+			writeAlertError(rw, err)
+			return
+		}
+		writeAlert(rw, http.StatusOK, list)
+	}
+}
+
+// CancelAlert stops an active alert from triggering.
+func CancelAlert(store db.AlertStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		alertID := params["alertID"]
+		if email == "" || alertID == "" {
+			http.Error(rw, "Email or alert id is missing.", http.StatusBadRequest)
+			return
+		}
+
+		alert, err := store.CancelAlert(email, alertID)
+		if err != nil {
+			writeAlertError(rw, err)
+			return
+		}
+		writeAlert(rw, http.StatusOK, alert)
+	}
+}
+
+func writeAlert(rw http.ResponseWriter, status int, body interface{}) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(body)
+}
+
+func writeAlertError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		http.Error(rw, "User or alert does not exist.", http.StatusNotFound)
+	case errors.Is(err, db.ErrAlertNotActive):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidAlertKind), errors.Is(err, db.ErrMissingAlertPrice),
+		errors.Is(err, db.ErrInvalidAlertBps):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(rw, "Unable to update alerts.", http.StatusInternalServerError)
+	}
+}
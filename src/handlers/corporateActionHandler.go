@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	db "dbutil/src/database"
+	"dbutil/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type corporateActionRequest struct {
+	ID     string                     `json:"id"`
+	Type   models.CorporateActionType `json:"type"`
+	Symbol string                     `json:"symbol"`
+	// Ratio is the split ratio, such as "2:1".
+	Ratio          string        `json:"ratio"`
+	AmountPerShare *models.Money `json:"amountPerShare"`
+	// RecordDate is the day, such as "2006-01-02", whose holders a dividend
+	// pays.
+	RecordDate string `json:"recordDate"`
+}
+
+// ApplyCorporateAction applies a split or pays a dividend to every holder of a
+// symbol. Sending an id that was already applied is refused with 409.
+func ApplyCorporateAction(store db.CorporateActionStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		body := corporateActionRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(rw, "Failed while parsing the corporate action: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		action := models.CorporateAction{
+			ID:             body.ID,
+			Type:           body.Type,
+			Symbol:         body.Symbol,
+			AmountPerShare: body.AmountPerShare,
+		}
+		if body.Type == models.CorporateSplit {
+			action.SplitTo, action.SplitFrom, err = models.ParseSplitRatio(body.Ratio)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if body.RecordDate != "" {
+			recordDate, err := models.ParseRecordDate(body.RecordDate)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			action.RecordDate = &recordDate
+		}
+
+		action, err = store.ApplyCorporateAction(action)
+		switch {
+		case errors.Is(err, db.ErrActionApplied), errors.Is(err, db.ErrCorporateActionRaced),
+			errors.Is(err, db.ErrRecordDateHoldings):
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, db.ErrMissingActionID), errors.Is(err, db.ErrInvalidActionType),
+			errors.Is(err, db.ErrMissingSymbol), errors.Is(err, models.ErrInvalidSplitRatio),
+			errors.Is(err, db.ErrFractionalSplit), errors.Is(err, db.ErrInvalidAmount),
+			errors.Is(err, db.ErrMissingRecordDate), errors.Is(err, db.ErrRecordDateNotPast),
+			errors.Is(err, models.ErrMoneyOverflow):
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(rw, "Unable to apply corporate action.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(rw).Encode(action)
+	}
+}
+
+// GetCorporateActions lists the applied corporate actions, optionally of one
+// ?symbol= only.
+func GetCorporateActions(store db.CorporateActionStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		actions, err := store.GetCorporateActions(r.URL.Query().Get("symbol"))
+		if err != nil {
+			http.Error(rw, "Unable to get corporate actions.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(actions)
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	logger "dbutil/src/logging"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// readCredentials takes the email and password of a request from, in order,
+// the legacy {email}/{password} path variables, an HTTP Basic Authorization
+// header, or a JSON body.
+func readCredentials(r *http.Request) credentials {
+	params := mux.Vars(r)
+	if params["email"] != "" || params["password"] != "" {
+		return credentials{Email: params["email"], Password: params["password"]}
+	}
+
+	if email, password, ok := r.BasicAuth(); ok {
+		return credentials{Email: email, Password: password}
+	}
+
+	creds := credentials{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&creds)
+	}
+	return creds
+}
+
+// Deprecated wraps a legacy route that carries credentials in its path. It
+// logs a warning and points clients at the successor route.
+func Deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		logger.Warn("Deprecated route with credentials in the path was called, use " + successor + " instead")
+		rw.Header().Set("Deprecation", "true")
+		rw.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		next(rw, r)
+	}
+}
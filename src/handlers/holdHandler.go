@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	db "dbutil/src/database"
+	"dbutil/src/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type withdrawalRequest struct {
+	Amount models.Money `json:"amount"`
+}
+
+// GetBalance reports the total, held and available balance of a user.
+func GetBalance(store db.Store) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		balance, err := store.GetBalance(email)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to get balance.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(balance)
+	}
+}
+
+// Withdraw places a hold on the amount to be paid out. The money leaves the
+// balance when the hold is settled.
+func Withdraw(store db.Store) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		body := withdrawalRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(rw, "Failed while parsing the amount: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hold, err := store.PlaceHold(email, models.Hold{Type: models.HoldWithdrawal, Amount: body.Amount})
+		if err != nil {
+			writeHoldError(rw, err)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(rw).Encode(hold)
+	}
+}
+
+// GetHolds lists the holds of a user, optionally only those with ?status=.
+func GetHolds(store db.Store) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		holds, err := store.GetHolds(email, models.HoldStatus(r.URL.Query().Get("status")))
+		if err != nil {
+			writeHoldError(rw, err)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(holds)
+	}
+}
+
+// SettleHold pays out the withdrawal hold {holdID} of the account {user}. It
+// is an administrative route, called once the payment was made, so users can
+// not confirm their own payouts.
+func SettleHold(store db.Store) http.HandlerFunc {
+	return resolveHold("user", store.SettleHold)
+}
+
+// CancelHold lets users withdraw a withdrawal request that was not paid out.
+func CancelHold(store db.Store) http.HandlerFunc {
+	return resolveHold("email", store.CancelHold)
+}
+
+// resolveHold serves a route that resolves a hold of the account named by the
+// route variable param.
+func resolveHold(param string, resolve func(email string, holdID string) (models.Hold, error)) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params[param]
+		holdID := params["holdID"]
+		if email == "" || holdID == "" {
+			http.Error(rw, "Email or hold id is missing.", http.StatusBadRequest)
+			return
+		}
+
+		hold, err := resolve(email, holdID)
+		if err != nil {
+			writeHoldError(rw, err)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(hold)
+	}
+}
+
+func writeHoldError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		http.Error(rw, "User or hold does not exist.", http.StatusNotFound)
+	case errors.Is(err, db.ErrHoldNotPending), errors.Is(err, db.ErrHoldType):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrAccountBlocked):
+		http.Error(rw, err.Error(), http.StatusForbidden)
+	case errors.Is(err, db.ErrInvalidAmount), errors.Is(err, db.ErrInsufficientFunds), errors.Is(err, db.ErrCurrencyMismatch),
+		errors.Is(err, models.ErrMoneyOverflow):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(rw, "Unable to update hold.", http.StatusInternalServerError)
+	}
+}
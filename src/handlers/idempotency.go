@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dbutil/src/auth"
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// IdempotencyKeyHeader is the header clients set to make a retry of a
+// money-moving request safe.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotent runs next at most once per Idempotency-Key and user. The first
+// response is stored for ttl and replayed verbatim for every retry with the
+// same key; reusing a key for a different request is a 409. Server errors and
+// conflicts are not stored, so the request can be retried. Requests without
+// the header run as before. clock dates the stored responses.
+func Idempotent(store db.IdempotencyStore, clock models.Clock, ttl time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(rw, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(rw, "Idempotency-Key is too long.", http.StatusBadRequest)
+			return
+		}
+
+		email, ok := auth.CallerEmail(r.Context())
+		if !ok {
+			email = mux.Vars(r)["email"]
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		now := clock.Now()
+		record := models.IdempotencyRecord{
+			Email:       email,
+			Key:         key,
+			RequestHash: requestHash(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		}
+		existing, reserved, err := store.ReserveIdempotencyKey(record)
+		if err != nil {
+			http.Error(rw, "Unable to check Idempotency-Key.", http.StatusInternalServerError)
+			return
+		}
+		if !reserved {
+			replay(rw, record, existing)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: rw}
+		next(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		if retryable(recorder.status) {
+			_ = store.ReleaseIdempotencyKey(email, key)
+			return
+		}
+		record.StatusCode = recorder.status
+		record.ContentType = recorder.contentType()
+		record.Body = recorder.body.Bytes()
+		err = store.CompleteIdempotencyKey(record)
+		if err != nil {
+			logger.Error("Response to Idempotency-Key " + key + " was not saved: " + err.Error())
+		}
+	}
+}
+
+// retryable reports whether a response with status may change when the same
+// request is sent again, so it must not be replayed.
+func retryable(status int) bool {
+	return status >= http.StatusInternalServerError || status == http.StatusConflict
+}
+
+// replay answers a retry with the response stored for its key.
+func replay(rw http.ResponseWriter, record models.IdempotencyRecord, existing models.IdempotencyRecord) {
+	if existing.RequestHash != record.RequestHash {
+		http.Error(rw, "Idempotency-Key was already used for a different request.", http.StatusConflict)
+		return
+	}
+	if !existing.Completed {
+		http.Error(rw, "A request with this Idempotency-Key is still in progress.", http.StatusConflict)
+		return
+	}
+
+	if existing.ContentType != "" {
+		rw.Header().Set("Content-Type", existing.ContentType)
+	}
+	rw.Header().Set("Idempotent-Replayed", "true")
+	rw.WriteHeader(existing.StatusCode)
+	_, _ = rw.Write(existing.Body)
+}
+
+// requestHash identifies a request by its method, url and body.
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + "\n" + r.URL.Path + "\n" + r.URL.RawQuery + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// contentType is the Content-Type the response was sent with. Like
+// net/http, it is sniffed from the body when the handler did not set one.
+func (r *responseRecorder) contentType() string {
+	contentType := r.Header().Get("Content-Type")
+	if contentType == "" && r.body.Len() > 0 {
+		contentType = http.DetectContentType(r.body.Bytes())
+	}
+	return contentType
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
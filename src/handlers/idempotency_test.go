@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"dbutil/src/config"
+	"dbutil/src/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// countingHandler answers with status and the number of times it ran.
+type countingHandler struct {
+	calls  int
+	status int
+}
+
+func (h *countingHandler) serve(rw http.ResponseWriter, r *http.Request) {
+	h.calls++
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(h.status)
+	_, _ = rw.Write([]byte(`{"call":` + strconv.Itoa(h.calls) + `}`))
+}
+
+// newIdempotentRouter routes buys through Idempotent with a one hour ttl, on
+// the store and clock of a new world.
+func newIdempotentRouter(t *testing.T) (*mux.Router, *countingHandler, *testutil.World) {
+	t.Helper()
+	w := testutil.NewWorld(t, config.Configuration{})
+	handler := &countingHandler{status: http.StatusCreated}
+	router := mux.NewRouter()
+	router.HandleFunc("/user/{email}/buy", Idempotent(w.Store, w.Clock, time.Hour, handler.serve))
+	return router, handler, w
+}
+
+func send(router *mux.Router, email string, key string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/user/"+email+"/buy", strings.NewReader(body))
+	if key != "" {
+		request.Header.Set(IdempotencyKeyHeader, key)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestIdempotentReplaysFirstResponse(t *testing.T) {
+	router, handler, _ := newIdempotentRouter(t)
+
+	first := send(router, testutil.Email, "key-1", `{"symbol":"AAPL"}`)
+	retry := send(router, testutil.Email, "key-1", `{"symbol":"AAPL"}`)
+
+	if handler.calls != 1 {
+		t.Fatalf("handler ran %d times, want once", handler.calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("retry got %d %s, want the first response %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("retry headers are %v, want a replayed json response", retry.Header())
+	}
+}
+
+func TestIdempotentRefusesKeyReusedForOtherRequest(t *testing.T) {
+	router, handler, _ := newIdempotentRouter(t)
+
+	send(router, testutil.Email, "key-1", `{"symbol":"AAPL"}`)
+	reused := send(router, testutil.Email, "key-1", `{"symbol":"MSFT"}`)
+
+	if reused.Code != http.StatusConflict || handler.calls != 1 {
+		t.Fatalf("reused key got %d after %d calls, want 409 after one", reused.Code, handler.calls)
+	}
+}
+
+func TestIdempotentDoesNotStoreServerErrors(t *testing.T) {
+	router, handler, _ := newIdempotentRouter(t)
+	handler.status = http.StatusInternalServerError
+
+	send(router, testutil.Email, "key-1", `{}`)
+	handler.status = http.StatusCreated
+	retry := send(router, testutil.Email, "key-1", `{}`)
+
+	if retry.Code != http.StatusCreated || handler.calls != 2 {
+		t.Fatalf("retry after a server error got %d after %d calls, want 201 after two", retry.Code, handler.calls)
+	}
+}
+
+func TestIdempotentKeyExpiresOnTheClock(t *testing.T) {
+	router, handler, w := newIdempotentRouter(t)
+
+	send(router, testutil.Email, "key-1", `{}`)
+	w.Clock.Advance(time.Hour)
+	retry := send(router, testutil.Email, "key-1", `{}`)
+
+	if handler.calls != 2 || retry.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("request after the ttl ran %d times, want it to run again", handler.calls)
+	}
+}
+
+func TestIdempotentKeysBelongToOneUser(t *testing.T) {
+	router, handler, _ := newIdempotentRouter(t)
+
+	send(router, testutil.Email, "key-1", `{}`)
+	send(router, "other@example.com", "key-1", `{}`)
+	send(router, testutil.Email, "", `{}`)
+	send(router, testutil.Email, "", `{}`)
+
+	if handler.calls != 4 {
+		t.Fatalf("handler ran %d times, want every request to run", handler.calls)
+	}
+	tooLong := send(router, testutil.Email, strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`)
+	if tooLong.Code != http.StatusBadRequest || handler.calls != 4 {
+		t.Fatalf("a key that is too long got %d, want 400 without running", tooLong.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	db "dbutil/src/database"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetLedger pages through the ledger of a user, oldest entry first. ?limit=
+// sets the page size and ?after= takes the nextCursor of the previous page.
+func GetLedger(store db.Store) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		limit := 0
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				http.Error(rw, "Limit must be a positive whole number.", http.StatusBadRequest)
+				return
+			}
+		}
+
+		page, err := store.GetLedger(email, r.URL.Query().Get("after"), limit)
+		if errors.Is(err, db.ErrInvalidCursor) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to get ledger.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(page)
+	}
+}
+
+// VerifyBalance reports whether the balance of a user matches the sum of
+// their ledger.
+func VerifyBalance(store db.Store) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		verification, err := store.VerifyBalance(email)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to verify balance.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(verification)
+	}
+}
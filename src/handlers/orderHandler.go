@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/orders"
+	"dbutil/src/quotes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type orderRequest struct {
+	Side        models.OrderSide   `json:"side"`
+	Type        models.OrderType   `json:"type"`
+	Symbol      string             `json:"symbol"`
+	Quantity    int                `json:"quantity"`
+	LimitPrice  *models.Money      `json:"limitPrice"`
+	StopPrice   *models.Money      `json:"stopPrice"`
+	TimeInForce models.TimeInForce `json:"timeInForce"`
+	Matching    models.LotMatching `json:"matching"`
+}
+
+// PlaceOrder opens an order and evaluates it right away, so market orders and
+// limit orders the market already satisfies fill before the response. The
+// rest wait for the matcher.
+func PlaceOrder(store db.Store, matcher *orders.Matcher) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		body := orderRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(rw, "Failed while parsing the order: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		order, err := store.PlaceOrder(email, models.Order{
+			Side:        body.Side,
+			Type:        body.Type,
+			Symbol:      body.Symbol,
+			Quantity:    body.Quantity,
+			LimitPrice:  body.LimitPrice,
+			StopPrice:   body.StopPrice,
+			TimeInForce: body.TimeInForce,
+			Matching:    body.Matching,
+		})
+		if err != nil {
+			writeOrderError(rw, err)
+			return
+		}
+		evaluated, err := matcher.Evaluate(order)
+		if err != nil {
+			logger.Error("Order " + order.ID.Hex() + " stays open: " + err.Error())
+		} else {
+			order = evaluated
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(rw).Encode(order)
+	}
+}
+
+// GetOrders lists the orders of a user, optionally only those with ?status=.
+func GetOrders(store db.Store) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		list, err := store.GetOrders(email, models.OrderStatus(r.URL.Query().Get("status")))
+		if err != nil {
+			writeOrderError(rw, err)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(list)
+	}
+}
+
+// CancelOrder cancels an open order and releases the funds it reserved.
+func CancelOrder(store db.Store) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		orderID := params["orderID"]
+		if email == "" || orderID == "" {
+			http.Error(rw, "Email or order id is missing.", http.StatusBadRequest)
+			return
+		}
+
+		order, err := store.CancelOrder(email, orderID)
+		if err != nil {
+			writeOrderError(rw, err)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(order)
+	}
+}
+
+func writeOrderError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		http.Error(rw, "User or order does not exist.", http.StatusNotFound)
+	case errors.Is(err, db.ErrOrderNotOpen):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrAccountBlocked):
+		http.Error(rw, err.Error(), http.StatusForbidden)
+	case errors.Is(err, db.ErrInvalidOrderSide), errors.Is(err, db.ErrInvalidOrderType),
+		errors.Is(err, db.ErrInvalidTimeInForce), errors.Is(err, db.ErrMissingLimitPrice),
+		errors.Is(err, db.ErrMissingStopPrice), errors.Is(err, db.ErrMissingSymbol),
+		errors.Is(err, db.ErrInvalidQuantity), errors.Is(err, db.ErrInvalidLotMatching),
+		errors.Is(err, db.ErrInvalidAmount), errors.Is(err, db.ErrInsufficientFunds),
+		errors.Is(err, db.ErrCurrencyMismatch), errors.Is(err, quotes.ErrUnknownSymbol),
+		errors.Is(err, models.ErrMoneyOverflow):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(rw, "Unable to update order.", http.StatusInternalServerError)
+	}
+}
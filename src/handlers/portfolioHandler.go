@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"dbutil/src/portfolio"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetPortfolio values the holdings of a user per symbol at the current quotes,
+// with the gains realized by what they sold.
+func GetPortfolio(valuer *portfolio.Valuer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		valuation, err := valuer.Value(email)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to value portfolio.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(valuation)
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"dbutil/src/quotes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// GetQuote returns the price buys and sells of a symbol currently execute at.
+func GetQuote(provider quotes.Provider) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		symbol := mux.Vars(r)["symbol"]
+		quote, err := provider.Quote(symbol)
+		if errors.Is(err, quotes.ErrUnknownSymbol) {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to get quote.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(quote)
+	}
+}
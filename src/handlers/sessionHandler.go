@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"dbutil/src/auth"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func RefreshSession(sessions *auth.Sessions) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		body := refreshRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil || body.RefreshToken == "" {
+			http.Error(rw, "Refresh token is missing.", http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := sessions.Refresh(body.RefreshToken)
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			http.Error(rw, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to refresh session.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(tokens)
+	}
+}
+
+func RevokeSession(sessions *auth.Sessions) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		body := refreshRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil || body.RefreshToken == "" {
+			http.Error(rw, "Refresh token is missing.", http.StatusBadRequest)
+			return
+		}
+
+		err = sessions.Revoke(body.RefreshToken)
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			http.Error(rw, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to revoke session.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode("Session has been revoked.")
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	logger "dbutil/src/logging"
+	"dbutil/src/tax"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetTaxReport lists what a user sold in ?year=, the current year by default,
+// with the gain of every lot. "Accept: text/csv" returns it as CSV.
+func GetTaxReport(reporter *tax.Reporter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		year := reporter.CurrentYear()
+		if value := r.URL.Query().Get("year"); value != "" {
+			var err error
+			year, err = strconv.Atoi(value)
+			if err != nil || year <= 0 {
+				http.Error(rw, "Year must be a positive whole number.", http.StatusBadRequest)
+				return
+			}
+		}
+
+		report, err := reporter.Report(email, year)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to build tax report.", http.StatusInternalServerError)
+			return
+		}
+
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			rw.Header().Set("content-type", "text/csv")
+			rw.Header().Set("content-disposition", `attachment; filename="tax-report-`+strconv.Itoa(year)+`.csv"`)
+			rw.WriteHeader(http.StatusOK)
+			err = report.WriteCSV(rw)
+			if err != nil {
+				logger.Error("Unable to write tax report of " + email + ": " + err.Error())
+			}
+			return
+		}
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(report)
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// dateLayout is the day-only form the date filters accept besides RFC 3339.
+const dateLayout = "2006-01-02"
+
+// transactionColumns is the header of the CSV export.
+var transactionColumns = []string{"date", "type", "symbol", "quantity", "price", "fee", "amount", "currency", "reference"}
+
+// GetTransactions lists the buys and sells of a user. ?symbol= and ?type=buy
+// or sell filter them, ?from= and ?to= bound their date, ?sort=date or
+// -date orders them, and ?limit= and ?after= page through them like the
+// ledger. With "Accept: text/csv" every page from ?after= on is streamed as
+// CSV instead.
+func GetTransactions(store db.LedgerStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		query, err := transactionQuery(r.URL.Query())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := store.GetTransactions(email, query)
+		if errors.Is(err, db.ErrInvalidCursor) || errors.Is(err, db.ErrInvalidTransactionType) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to get transactions.", http.StatusInternalServerError)
+			return
+		}
+
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			writeTransactionsCSV(rw, store, email, query, page)
+			return
+		}
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(page)
+	}
+}
+
+// writeTransactionsCSV writes page and every page after it as CSV, flushing
+// each page as it is read. An error after the first page can only be logged,
+// as the response has started.
+func writeTransactionsCSV(rw http.ResponseWriter, store db.LedgerStore, email string, query models.TransactionQuery, page models.TransactionPage) {
+	rw.Header().Set("content-type", "text/csv")
+	rw.Header().Set("content-disposition", `attachment; filename="transactions.csv"`)
+	rw.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(rw)
+	_ = writer.Write(transactionColumns)
+	query.Limit = db.MaxLedgerPageSize
+	for {
+		for _, transaction := range page.Transactions {
+			_ = writer.Write(transactionRecord(transaction))
+		}
+		writer.Flush()
+		if flusher, ok := rw.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if page.NextCursor == "" {
+			return
+		}
+
+		query.After = page.NextCursor
+		var err error
+		page, err = store.GetTransactions(email, query)
+		if err != nil {
+			logger.Error("Unable to export transactions of " + email + ": " + err.Error())
+			return
+		}
+	}
+}
+
+func transactionRecord(transaction models.Transaction) []string {
+	optional := func(money *models.Money) string {
+		if money == nil {
+			return ""
+		}
+		return money.String()
+	}
+	return []string{
+		transaction.Date.UTC().Format(time.RFC3339),
+		string(transaction.Type),
+		transaction.Symbol,
+		strconv.Itoa(transaction.Quantity),
+		optional(transaction.Price),
+		optional(transaction.Fee),
+		transaction.Amount.String(),
+		models.NewMoney(0, transaction.Amount.Currency).Currency,
+		transaction.ReferenceID,
+	}
+}
+
+// transactionQuery reads the query parameters of GetTransactions.
+func transactionQuery(values url.Values) (models.TransactionQuery, error) {
+	query := models.TransactionQuery{
+		Symbol: values.Get("symbol"),
+		Type:   models.LedgerEntryType(values.Get("type")),
+		After:  values.Get("after"),
+	}
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return query, errors.New("Limit must be a positive whole number.")
+		}
+		query.Limit = limit
+	}
+	switch values.Get("sort") {
+	case "", "date":
+	case "-date":
+		query.Descending = true
+	default:
+		return query, errors.New("Sort must be date or -date.")
+	}
+
+	var err error
+	query.From, err = dateParam(values.Get("from"), false)
+	if err != nil {
+		return query, errors.New("From must be a date such as 2006-01-02 or an RFC 3339 time.")
+	}
+	query.To, err = dateParam(values.Get("to"), true)
+	if err != nil {
+		return query, errors.New("To must be a date such as 2006-01-02 or an RFC 3339 time.")
+	}
+	return query, nil
+}
+
+// dateParam reads an RFC 3339 time or a UTC day. As the end of a range, a day
+// stands for the end of it, so that the day is included.
+func dateParam(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return &parsed, nil
+	}
+	parsed, err = time.Parse(dateLayout, value)
+	if err != nil {
+		return nil, err
+	}
+	if end {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return &parsed, nil
+}
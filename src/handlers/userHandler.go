@@ -1,19 +1,22 @@
 package handlers
 
 import (
+	"dbutil/src/auth"
 	db "dbutil/src/database"
 	logger "dbutil/src/logging"
 	"dbutil/src/models"
+	"dbutil/src/quotes"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
-	"strconv"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func Register(client *mongo.Client) http.HandlerFunc {
+func Register(store db.Store, confirmer *auth.Confirmer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger.Info("Adding new user")
 		user := models.User{}
@@ -23,8 +26,13 @@ func Register(client *mongo.Client) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		err = models.ValidateEmail(user.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		checkResult, err := db.CheckIfEmailExists(user.Email, client)
+		checkResult, err := store.CheckIfEmailExists(user.Email)
 
 		if err != nil {
 			http.Error(w, "Error while checking if email already exists. "+err.Error(), http.StatusInternalServerError)
@@ -46,18 +54,29 @@ func Register(client *mongo.Client) http.HandlerFunc {
 
 		user.Hash = string(hashedPassword)
 
-		result, err := db.SaveNewUser(user, client)
+		// A concurrent registration of the same email can get past the check
+		// above, so the store refuses it too.
+		result, err := store.SaveNewUser(user)
+		if errors.Is(err, db.ErrEmailTaken) {
+			http.Error(w, "Email already in use.", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error while saving the member to db.", http.StatusInternalServerError)
 			return
 		}
 
+		err = confirmer.SendConfirmation(user.Email)
+		if err != nil {
+			logger.Error("Unable to send confirmation email: " + err.Error())
+		}
+
 		w.WriteHeader(201)
 		_ = json.NewEncoder(w).Encode(result)
 	}
 }
 
-func GetUser(client *mongo.Client) http.HandlerFunc {
+func GetUser(store db.Store) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		email := params["email"]
@@ -66,7 +85,7 @@ func GetUser(client *mongo.Client) http.HandlerFunc {
 			return
 		}
 
-		user, err := db.GetUserData(email, client)
+		user, err := store.GetUserData(email)
 		if err != nil {
 			http.Error(rw, "User does not exist.", http.StatusNotFound)
 			return
@@ -77,85 +96,74 @@ func GetUser(client *mongo.Client) http.HandlerFunc {
 	}
 }
 
-func DeleteUser(client *mongo.Client) http.HandlerFunc {
+func DeleteUser(store db.Store) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		logger.Info("Attempting to delete user from db.")
-		params := mux.Vars(r)
-		email := params["email"]
-		password := params["password"]
+		creds := readCredentials(r)
+		email := creds.Email
+		password := creds.Password
 
 		if email == "" || password == "" {
 			http.Error(rw, "Email or password is missing.", http.StatusBadRequest)
 			return
 		}
-		err := db.AuthenticateUserOnDB(email, password, client)
+		err := db.AuthenticateUserOnDB(email, password, store)
 		if err != nil {
 			http.Error(rw, "Unable to authenticate: "+err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		result, err := db.DeleteUserFromDB(email, client)
-		rw.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(rw).Encode(result)
-	}
-}
-
-func UpdateUserStatus(client *mongo.Client) http.HandlerFunc {
-	return func(rw http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		status := params["status"]
-		email := params["email"]
-
-		if status == "" || email == "" {
-			http.Error(rw, "email or status is not present in the url", http.StatusBadRequest)
-			return
-		}
-
-		checkUser, err := db.CheckIfEmailExists(email, client)
+		result, err := store.DeleteUserFromDB(email)
 		if err != nil {
-			http.Error(rw, "Error while checking the user", http.StatusInternalServerError)
-			return
-		}
-		if !checkUser {
-			http.Error(rw, "Unable to update. User does not exists", http.StatusBadRequest)
+			http.Error(rw, "Unable to delete user.", http.StatusInternalServerError)
 			return
 		}
-
-		result, err := db.UpdateUserStatusOnDB(email, status, client)
-
+		err = store.RevokeRefreshTokens(email)
 		if err != nil {
-			http.Error(rw, "Unable to update active indicator of user. "+err.Error(), http.StatusInternalServerError)
-			return
+			logger.Error("Unable to revoke sessions of deleted user: " + err.Error())
 		}
-
 		rw.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(rw).Encode(result)
 	}
 }
 
-func AuthenticateUser(client *mongo.Client) http.HandlerFunc {
+// AuthenticateUser checks the credentials of a user and starts a session.
+func AuthenticateUser(store db.Store, sessions *auth.Sessions) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		email := params["email"]
-		password := params["password"]
+		creds := readCredentials(r)
+		email := creds.Email
+		password := creds.Password
 
 		if email == "" || password == "" {
 			http.Error(rw, "Email or password is missing.", http.StatusBadRequest)
 			return
 		}
 		logger.Info("Attempting to authenticate user.")
-		err := db.AuthenticateUserOnDB(email, password, client)
+		err := db.AuthenticateUserOnDB(email, password, store)
 		if err != nil {
 			http.Error(rw, "Unable to authenticate: "+err.Error(), http.StatusUnauthorized)
 			return
 		}
 
+		tokens, err := sessions.Issue(email)
+		if err != nil {
+			http.Error(rw, "Unable to start session.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
 		rw.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(rw).Encode("User has been authenticated successfully.")
+		_ = json.NewEncoder(rw).Encode(tokens)
 	}
 }
 
-func SaveShare(client *mongo.Client) http.HandlerFunc {
+// SaveShare buys or sells shares. A sell names either a single lot with
+// "shareID", or a "symbol" and "quantity" that are taken from as many lots as
+// needed according to "matching" (or ?matching=), which defaults to the
+// lotMatching setting of config.json. Trades execute at the market price;
+// "priceBaught" and "priceSold" are the prices the client expects, and are
+// required.
+func SaveShare(store db.Store) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		share := models.Share{}
 		params := mux.Vars(r)
@@ -166,23 +174,51 @@ func SaveShare(client *mongo.Client) http.HandlerFunc {
 			return
 		}
 
-		err := json.NewDecoder(r.Body).Decode(&share)
+		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(body, &share)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if transactionType == "sell" && share.ShareID == "" {
+			order := models.SellOrder{}
+			err = json.Unmarshal(body, &order)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if matching := r.URL.Query().Get("matching"); matching != "" {
+				order.Matching = models.LotMatching(matching)
+			}
+
+			sold, err := store.SellShares(email, order)
+			if err != nil {
+				writeShareError(rw, err)
+				return
+			}
+			rw.Header().Set("content-type", "application/json")
+			rw.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(rw).Encode(sold)
+			return
 		}
 
 		result := &mongo.UpdateResult{}
 		if transactionType == "buy" {
-			result, err = db.SaveBaughtShare(email, share, client)
+			result, err = store.SaveBaughtShare(email, share)
 			if err != nil {
-				http.Error(rw, err.Error(), http.StatusBadRequest)
+				writeShareError(rw, err)
 				return
 			}
 		}
 		if transactionType == "sell" {
-			result, err = db.UpdateShareToSold(email, share, client)
+			result, err = store.UpdateShareToSold(email, share)
 			if err != nil {
-				http.Error(rw, err.Error(), http.StatusBadRequest)
+				writeShareError(rw, err)
 				return
 			}
 		}
@@ -192,13 +228,130 @@ func SaveShare(client *mongo.Client) http.HandlerFunc {
 	}
 }
 
-func ConfirmEmail(client *mongo.Client) http.HandlerFunc {
+// writeShareError refuses trades of blocked accounts with a 403 and trades
+// the request itself rules out with a 400. A sell that raced another change of
+// the same lots is a 409 and may be retried; anything else is a 500.
+func writeShareError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		http.Error(rw, "User does not exist.", http.StatusNotFound)
+	case errors.Is(err, db.ErrAccountBlocked):
+		http.Error(rw, err.Error(), http.StatusForbidden)
+	case errors.Is(err, db.ErrHoldingsChanged):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInsufficientFunds), errors.Is(err, db.ErrCurrencyMismatch),
+		errors.Is(err, db.ErrShareNotOwned), errors.Is(err, db.ErrInsufficientShares),
+		errors.Is(err, db.ErrSlippageExceeded), errors.Is(err, db.ErrMixedSymbols),
+		errors.Is(err, db.ErrInvalidAmount), errors.Is(err, db.ErrInvalidQuantity),
+		errors.Is(err, db.ErrInvalidPrice), errors.Is(err, db.ErrMissingExpectedPrice),
+		errors.Is(err, db.ErrMissingSymbol),
+		errors.Is(err, db.ErrInvalidLotMatching), errors.Is(err, quotes.ErrUnknownSymbol),
+		errors.Is(err, models.ErrMoneyOverflow):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(rw, "Unable to complete the transaction.", http.StatusInternalServerError)
+	}
+}
+
+// GetHoldings lists the lots a user still owns, optionally narrowed down to
+// one symbol with ?symbol=.
+func GetHoldings(store db.Store) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
 
+		var holdings []models.Share
+		var err error
+		if symbol := r.URL.Query().Get("symbol"); symbol != "" {
+			holdings, err = store.GetHoldingsBySymbol(email, symbol)
+		} else {
+			holdings, err = store.GetHoldings(email)
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to get holdings.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(holdings)
 	}
 }
 
-func AddToBalance(client *mongo.Client) http.HandlerFunc {
+func ConfirmEmail(confirmer *auth.Confirmer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		token := r.URL.Query().Get("token")
+		if token == "" && r.Body != nil {
+			body := struct {
+				Token string `json:"token"`
+			}{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			token = body.Token
+		}
+		if email == "" || token == "" {
+			http.Error(rw, "Email or confirmation token is missing.", http.StatusBadRequest)
+			return
+		}
+
+		err := confirmer.Confirm(email, token)
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenExpired) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to confirm email.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode("Email has been confirmed successfully.")
+	}
+}
+
+func ResendConfirmation(confirmer *auth.Confirmer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		email := params["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		err := confirmer.ResendConfirmation(email)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, auth.ErrAlreadyConfirmed) {
+			http.Error(rw, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(rw, "Unable to send confirmation email.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode("Confirmation email has been sent.")
+	}
+}
+
+// AddToBalance deposits the amount in the url. An amount that is not a plain
+// decimal with at most two places, or an invalid ?currency=, is a 400; a
+// deposit that breaks a rule, such as not being positive or exceeding the
+// limits in config.json, is a 422. Suspended, frozen and closed accounts get
+// a 403.
+func AddToBalance(store db.Store) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
 		email := params["email"]
@@ -208,14 +361,27 @@ func AddToBalance(client *mongo.Client) http.HandlerFunc {
 			return
 		}
 
-		amount, err := strconv.ParseFloat(amountToAdd, 64)
+		amount, err := models.ParseMoney(amountToAdd, r.URL.Query().Get("currency"))
 		if err != nil {
-			http.Error(rw, "Failed while parsing the amount to float", http.StatusInternalServerError)
+			http.Error(rw, "Failed while parsing the amount: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		err = db.UpdateBalance(client, email, amount, true, false)
-		if err != nil {
+		deposit := models.BalanceChange{Type: models.LedgerDeposit, Amount: amount, Credit: true}
+		_, err = store.UpdateBalance(email, deposit)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			http.Error(rw, "User does not exist.", http.StatusNotFound)
+			return
+		case errors.Is(err, db.ErrAccountBlocked):
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, db.ErrInvalidAmount), errors.Is(err, db.ErrDepositTooLarge),
+			errors.Is(err, db.ErrDailyDepositLimit), errors.Is(err, db.ErrCurrencyMismatch),
+			errors.Is(err, models.ErrMoneyOverflow):
+			http.Error(rw, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
 			http.Error(rw, "Unable to add balance", http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/quotes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type watchlistRequest struct {
+	Name  string                 `json:"name"`
+	Items []models.WatchlistItem `json:"items"`
+}
+
+// watchlistItemView is a watchlist item with its current quote, when the
+// provider has one.
+type watchlistItemView struct {
+	Symbol string        `json:"symbol"`
+	Note   string        `json:"note,omitempty"`
+	Price  *models.Money `json:"price,omitempty"`
+	AsOf   *time.Time    `json:"asOf,omitempty"`
+}
+
+type watchlistView struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	Items     []watchlistItemView `json:"items"`
+	CreatedAt time.Time           `json:"createdAt"`
+	UpdatedAt time.Time           `json:"updatedAt"`
+}
+
+// viewOf adds the quotes of provider to a watchlist. Symbols it has no price
+// for are listed without one.
+func viewOf(watchlist models.Watchlist, provider quotes.Provider) watchlistView {
+	view := watchlistView{
+		ID:        watchlist.ID.Hex(),
+		Name:      watchlist.Name,
+		Items:     []watchlistItemView{},
+		CreatedAt: watchlist.CreatedAt,
+		UpdatedAt: watchlist.UpdatedAt,
+	}
+	for _, item := range watchlist.Items {
+		itemView := watchlistItemView{Symbol: item.Symbol, Note: item.Note}
+		quote, err := provider.Quote(item.Symbol)
+		if err == nil {
+			itemView.Price = &quote.Price
+			itemView.AsOf = &quote.AsOf
+		} else if !errors.Is(err, quotes.ErrUnknownSymbol) {
+			logger.Error("Unable to price " + item.Symbol + ": " + err.Error())
+		}
+		view.Items = append(view.Items, itemView)
+	}
+	return view
+}
+
+// CreateWatchlist saves a named list of symbols for a user.
+func CreateWatchlist(store db.WatchlistStore, provider quotes.Provider) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		email := mux.Vars(r)["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		body := watchlistRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(rw, "Failed while parsing the watchlist: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		watchlist, err := store.CreateWatchlist(email, models.Watchlist{Name: body.Name, Items: body.Items})
+		if err != nil {
+			writeWatchlistError(rw, err)
+			return
+		}
+		writeWatchlist(rw, http.StatusCreated, viewOf(watchlist, provider))
+	}
+}
+
+// GetWatchlists lists the watchlists of a user with current quotes.
+func GetWatchlists(store db.WatchlistStore, provider quotes.Provider) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		email := mux.Vars(r)["email"]
+		if email == "" {
+			http.Error(rw, "Email is missing.", http.StatusBadRequest)
+			return
+		}
+
+		watchlists, err := store.GetWatchlists(email)
+		if err != nil {
+			writeWatchlistError(rw, err)
+			return
+		}
+		views := []watchlistView{}
+		for _, watchlist := range watchlists {
+			views = append(views, viewOf(watchlist, provider))
+		}
+		writeWatchlist(rw, http.StatusOK, views)
+	}
+}
+
+func GetWatchlist(store db.WatchlistStore, provider quotes.Provider) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		watchlist, err := store.GetWatchlist(params["email"], params["watchlistID"])
+		if err != nil {
+			writeWatchlistError(rw, err)
+			return
+		}
+		writeWatchlist(rw, http.StatusOK, viewOf(watchlist, provider))
+	}
+}
+
+// UpdateWatchlist replaces the name and items of a watchlist.
+func UpdateWatchlist(store db.WatchlistStore, provider quotes.Provider) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		body := watchlistRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(rw, "Failed while parsing the watchlist: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		watchlist, err := store.UpdateWatchlist(params["email"], params["watchlistID"], models.Watchlist{Name: body.Name, Items: body.Items})
+		if err != nil {
+			writeWatchlistError(rw, err)
+			return
+		}
+		writeWatchlist(rw, http.StatusOK, viewOf(watchlist, provider))
+	}
+}
+
+func DeleteWatchlist(store db.WatchlistStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		err := store.DeleteWatchlist(params["email"], params["watchlistID"])
+		if err != nil {
+			writeWatchlistError(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeWatchlist(rw http.ResponseWriter, status int, body interface{}) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(body)
+}
+
+func writeWatchlistError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		http.Error(rw, "User or watchlist does not exist.", http.StatusNotFound)
+	case errors.Is(err, db.ErrWatchlistExists), errors.Is(err, db.ErrTooManyWatchlists):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case errors.Is(err, db.ErrInvalidWatchlistName), errors.Is(err, db.ErrMissingSymbol),
+		errors.Is(err, db.ErrDuplicateSymbol), errors.Is(err, db.ErrWatchlistTooLarge):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(rw, "Unable to update watchlists.", http.StatusInternalServerError)
+	}
+}
@@ -25,7 +25,16 @@ func Info(logMsg ...interface{}) {
 	Logger.WithFields(log.Fields{
 		"file": path.Base(fileName),
 		"line": lineNumber,
-	}).Info(logMsg)
+	}).Info(logMsg...)
+}
+
+func Warn(logMsg ...interface{}) {
+	_, fileName, lineNumber, _ := runtime.Caller(1)
+
+	Logger.WithFields(log.Fields{
+		"file": path.Base(fileName),
+		"line": lineNumber,
+	}).Warn(logMsg...)
 }
 
 func Error(logMsg ...interface{}) {
@@ -34,5 +43,5 @@ func Error(logMsg ...interface{}) {
 	Logger.WithFields(log.Fields{
 		"file": path.Base(fileName),
 		"line": lineNumber,
-	}).Error(logMsg)
+	}).Error(logMsg...)
 }
@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"crypto/sha256"
+	logger "dbutil/src/logging"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes every message as a JSON file into a directory instead of
+// delivering it. It is meant for local runs.
+type FileMailer struct {
+	directory string
+}
+
+func NewFileMailer(directory string) *FileMailer {
+	if directory == "" {
+		directory = "mail"
+	}
+	return &FileMailer{directory: directory}
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	err := os.MkdirAll(m.directory, 0755)
+	if err != nil {
+		logger.Error("Unable to create mail directory: " + err.Error())
+		return err
+	}
+
+	data, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The recipient is hashed rather than used in the file name, so no
+	// address can name a path outside the directory.
+	to := sha256.Sum256([]byte(msg.To))
+	name := fmt.Sprintf("%d-%s.json", time.Now().UnixNano(), hex.EncodeToString(to[:8]))
+	path := filepath.Join(m.directory, name)
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		logger.Error("Unable to write email to " + path + ": " + err.Error())
+		return err
+	}
+	logger.Info("Wrote email to " + path)
+	return nil
+}
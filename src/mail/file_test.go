@@ -0,0 +1,86 @@
+package mail
+
+import (
+	"dbutil/src/config"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// sendToFile sends msg with a FileMailer and returns the directory the
+// mailer writes to.
+func sendToFile(t *testing.T, msg Message) string {
+	t.Helper()
+	directory := filepath.Join(t.TempDir(), "mail")
+	err := NewFileMailer(directory).Send(msg)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	return directory
+}
+
+func TestFileMailerWritesMessage(t *testing.T) {
+	sent := Message{To: "new@example.com", Subject: "Confirm your email address", Body: "Hello"}
+	directory := sendToFile(t, sent)
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("mail directory holds %d files, want 1", len(files))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(directory, files[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	written := Message{}
+	err = json.Unmarshal(data, &written)
+	if err != nil {
+		t.Fatalf("message is not JSON: %v", err)
+	}
+	if written != sent {
+		t.Fatalf("wrote %+v, want %+v", written, sent)
+	}
+}
+
+func TestFileMailerKeepsRecipientOutOfPath(t *testing.T) {
+	// Used in the file name, the address would climb out of the directory
+	// into its parent.
+	directory := sendToFile(t, Message{To: "x/../../escaped@example.com", Subject: "Hi"})
+
+	matches, err := filepath.Glob(filepath.Join(directory, "..", "escaped*"))
+	if err != nil || len(matches) != 0 {
+		t.Fatalf("files outside the mail directory: %v, %v", matches, err)
+	}
+	files, err := ioutil.ReadDir(directory)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("mail directory holds %d files, %v; want 1", len(files), err)
+	}
+}
+
+func TestMemoryMailerKeepsCopies(t *testing.T) {
+	mailer := NewMemoryMailer()
+	err := mailer.Send(Message{To: "new@example.com", Subject: "Hi"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	messages := mailer.Messages()
+	messages[0].Subject = "Changed"
+	if again := mailer.Messages(); len(again) != 1 || again[0].Subject != "Hi" {
+		t.Fatalf("messages are %+v, want the message as sent", again)
+	}
+}
+
+func TestNewMailerRefusesUnknownType(t *testing.T) {
+	_, err := NewMailer(config.MailConfig{Type: "pigeon"})
+	if err == nil {
+		t.Fatal("NewMailer accepted an unknown type")
+	}
+	mailer, err := NewMailer(config.MailConfig{Type: "memory"})
+	if _, ok := mailer.(*MemoryMailer); err != nil || !ok {
+		t.Fatalf("NewMailer(memory) returned %T, %v", mailer, err)
+	}
+}
@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"dbutil/src/config"
+	"fmt"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Mailer delivers messages to users.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer returns the Mailer selected by the "mail" section of config.json.
+func NewMailer(mailConfig config.MailConfig) (Mailer, error) {
+	switch mailConfig.Type {
+	case "smtp":
+		return NewSMTPMailer(mailConfig), nil
+	case "", "file":
+		return NewFileMailer(mailConfig.Directory), nil
+	case "memory":
+		return NewMemoryMailer(), nil
+	}
+	return nil, fmt.Errorf("Unknown mail type %q", mailConfig.Type)
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"dbutil/src/config"
+	logger "dbutil/src/logging"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailer sends messages through an SMTP relay.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(mailConfig config.MailConfig) *SMTPMailer {
+	mailer := &SMTPMailer{
+		addr: fmt.Sprintf("%s:%d", mailConfig.Host, mailConfig.Port),
+		from: mailConfig.From,
+	}
+	if mailConfig.Username != "" {
+		mailer.auth = smtp.PlainAuth("", mailConfig.Username, mailConfig.Password, mailConfig.Host)
+	}
+	return mailer
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var body strings.Builder
+	body.WriteString("From: " + m.from + "\r\n")
+	body.WriteString("To: " + msg.To + "\r\n")
+	body.WriteString("Subject: " + msg.Subject + "\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(msg.Body)
+
+	err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(body.String()))
+	if err != nil {
+		logger.Error("Unable to send email to " + msg.To + ": " + err.Error())
+		return err
+	}
+	logger.Info("Sent email to " + msg.To)
+	return nil
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AccountStatus is where an account is in its life cycle. Accounts start
+// pending and become active once their email is confirmed.
+type AccountStatus string
+
+const (
+	AccountStatusPending   AccountStatus = "pending"
+	AccountStatusActive    AccountStatus = "active"
+	AccountStatusSuspended AccountStatus = "suspended"
+	// AccountStatusFrozen is also the status of an account that failed
+	// reconciliation.
+	AccountStatusFrozen AccountStatus = "frozen"
+	AccountStatusClosed AccountStatus = "closed"
+)
+
+// BlockedAccountStatuses are the statuses of accounts that may not trade or
+// deposit.
+var BlockedAccountStatuses = []AccountStatus{AccountStatusSuspended, AccountStatusFrozen, AccountStatusClosed}
+
+// WithdrawalBlockedStatuses are the statuses of accounts that may not
+// withdraw. Closed accounts may still pay out what is left of their balance.
+var WithdrawalBlockedStatuses = []AccountStatus{AccountStatusSuspended, AccountStatusFrozen}
+
+// accountTransitions lists the statuses each status may change to. Closed
+// accounts stay closed.
+var accountTransitions = map[AccountStatus][]AccountStatus{
+	AccountStatusPending:   {AccountStatusActive, AccountStatusSuspended, AccountStatusFrozen, AccountStatusClosed},
+	AccountStatusActive:    {AccountStatusSuspended, AccountStatusFrozen, AccountStatusClosed},
+	AccountStatusSuspended: {AccountStatusActive, AccountStatusFrozen, AccountStatusClosed},
+	AccountStatusFrozen:    {AccountStatusActive, AccountStatusSuspended, AccountStatusClosed},
+	AccountStatusClosed:    {},
+}
+
+// Valid reports whether s is one of the known statuses.
+func (s AccountStatus) Valid() bool {
+	_, ok := accountTransitions[s]
+	return ok
+}
+
+// Effective is s, except that the free-form statuses older versions accepted,
+// and no status at all, count as active.
+func (s AccountStatus) Effective() AccountStatus {
+	if !s.Valid() {
+		return AccountStatusActive
+	}
+	return s
+}
+
+// CanBecome reports whether an account with status s may change to status to.
+func (s AccountStatus) CanBecome(to AccountStatus) bool {
+	for _, allowed := range accountTransitions[s.Effective()] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocked reports whether accounts with status s may not trade or deposit.
+func (s AccountStatus) Blocked() bool {
+	return s.In(BlockedAccountStatuses)
+}
+
+// In reports whether s is one of statuses.
+func (s AccountStatus) In(statuses []AccountStatus) bool {
+	for _, status := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange records a change of the status of an account in the
+// StatusHistory collection. Actor is who made the change: the email of a
+// user, or the name of the job that made it.
+type StatusChange struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	UserID    string             `bson:"userID" json:"-"`
+	Email     string             `bson:"email" json:"email"`
+	From      AccountStatus      `bson:"from" json:"from"`
+	To        AccountStatus      `bson:"to" json:"to"`
+	Actor     string             `bson:"actor" json:"actor"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	ChangedAt time.Time          `bson:"changedAt" json:"changedAt"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AlertKind string
+
+const (
+	// AlertPriceAbove and AlertPriceBelow fire when the quote of Symbol
+	// reaches Price.
+	AlertPriceAbove AlertKind = "price_above"
+	AlertPriceBelow AlertKind = "price_below"
+	// AlertPortfolioDown and AlertPortfolioUp fire when the market value of
+	// the portfolio in Currency moved Bps basis points from the value it had
+	// when the day, UTC, started being watched.
+	AlertPortfolioDown AlertKind = "portfolio_down"
+	AlertPortfolioUp   AlertKind = "portfolio_up"
+)
+
+// Valid reports whether k is one of the known alert kinds.
+func (k AlertKind) Valid() bool {
+	switch k {
+	case AlertPriceAbove, AlertPriceBelow, AlertPortfolioDown, AlertPortfolioUp:
+		return true
+	}
+	return false
+}
+
+// WatchesPortfolio reports whether alerts of kind k watch the value of a
+// portfolio rather than a price.
+func (k AlertKind) WatchesPortfolio() bool {
+	return k == AlertPortfolioDown || k == AlertPortfolioUp
+}
+
+type AlertStatus string
+
+const (
+	AlertActive    AlertStatus = "active"
+	AlertTriggered AlertStatus = "triggered"
+	AlertCancelled AlertStatus = "cancelled"
+)
+
+// Alert notifies a user once, when a price or the value of their portfolio
+// reaches a threshold. Alerts live in the Alerts collection, keyed by the id
+// of the user.
+type Alert struct {
+	ID     primitive.ObjectID `bson:"_id" json:"id"`
+	UserID string             `bson:"userID" json:"-"`
+	Email  string             `bson:"email" json:"email"`
+	Kind   AlertKind          `bson:"kind" json:"kind"`
+	Symbol string             `bson:"symbol,omitempty" json:"symbol,omitempty"`
+	Price  *Money             `bson:"price,omitempty" json:"price,omitempty"`
+	Bps    int64              `bson:"bps,omitempty" json:"bps,omitempty"`
+	// Currency is the currency of the portfolio value a portfolio alert
+	// watches.
+	Currency string `bson:"currency,omitempty" json:"currency,omitempty"`
+	// Baseline is the portfolio value at BaselineAt, the first evaluation on
+	// the current UTC day.
+	Baseline   *Money      `bson:"baseline,omitempty" json:"baseline,omitempty"`
+	BaselineAt *time.Time  `bson:"baselineAt,omitempty" json:"baselineAt,omitempty"`
+	Status     AlertStatus `bson:"status" json:"status"`
+	// TriggeredValue is the price or portfolio value that fired the alert.
+	TriggeredValue *Money     `bson:"triggeredValue,omitempty" json:"triggeredValue,omitempty"`
+	CreatedAt      time.Time  `bson:"createdAt" json:"createdAt"`
+	TriggeredAt    *time.Time `bson:"triggeredAt,omitempty" json:"triggeredAt,omitempty"`
+	CancelledAt    *time.Time `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+}
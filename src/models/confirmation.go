@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// ConfirmationToken is the stored half of an email confirmation token. Only a
+// hash of the token is kept so a database leak cannot be used to confirm
+// accounts.
+type ConfirmationToken struct {
+	Email     string    `bson:"email" json:"email"`
+	TokenHash string    `bson:"tokenHash" json:"tokenHash"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
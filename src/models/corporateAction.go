@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSplitRatio = errors.New("Split ratio must be two different positive whole numbers such as 2:1.")
+	ErrInvalidRecordDate = errors.New("Record date must be a day such as 2006-01-02.")
+)
+
+// recordDateLayout is how record dates are written.
+const recordDateLayout = "2006-01-02"
+
+type CorporateActionType string
+
+const (
+	CorporateSplit    CorporateActionType = "split"
+	CorporateDividend CorporateActionType = "dividend"
+)
+
+// CorporateAction is a stock split or cash dividend applied to every holder
+// of a symbol. ID is chosen by whoever announces the action; an action whose
+// ID was already applied is refused, so none is ever applied twice.
+type CorporateAction struct {
+	ID     string              `bson:"_id" json:"id"`
+	Type   CorporateActionType `bson:"type" json:"type"`
+	Symbol string              `bson:"symbol" json:"symbol"`
+	// A split turns every SplitFrom shares into SplitTo shares, e.g. 2 and 1
+	// for a 2:1 split.
+	SplitTo   int `bson:"splitTo,omitempty" json:"splitTo,omitempty"`
+	SplitFrom int `bson:"splitFrom,omitempty" json:"splitFrom,omitempty"`
+	// A dividend pays AmountPerShare on every share held at the end of
+	// RecordDate, a UTC day.
+	AmountPerShare *Money     `bson:"amountPerShare,omitempty" json:"amountPerShare,omitempty"`
+	RecordDate     *time.Time `bson:"recordDate,omitempty" json:"recordDate,omitempty"`
+	AppliedAt      time.Time  `bson:"appliedAt" json:"appliedAt"`
+
+	// Lots is the number of lots a split adjusted and CancelledOrders the
+	// open orders it cancelled.
+	Lots            int `bson:"lots" json:"lots"`
+	CancelledOrders int `bson:"cancelledOrders" json:"cancelledOrders"`
+	// Holders is the number of users a dividend paid and Paid the total.
+	// Skipped lists holders whose balance is in another currency.
+	Holders int      `bson:"holders" json:"holders"`
+	Paid    *Money   `bson:"paid,omitempty" json:"paid,omitempty"`
+	Skipped []string `bson:"skipped,omitempty" json:"skipped,omitempty"`
+	// InProgress is set while a dividend is being paid in batches. Applying
+	// it again pays the holders that are left.
+	InProgress bool `bson:"inProgress,omitempty" json:"inProgress,omitempty"`
+}
+
+// ParseSplitRatio reads a ratio such as "2:1" into the number of shares after
+// and before the split.
+func ParseSplitRatio(ratio string) (to int, from int, err error) {
+	parts := strings.Split(ratio, ":")
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidSplitRatio
+	}
+	to, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || to <= 0 {
+		return 0, 0, ErrInvalidSplitRatio
+	}
+	from, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || from <= 0 || from == to {
+		return 0, 0, ErrInvalidSplitRatio
+	}
+	return to, from, nil
+}
+
+// ParseRecordDate reads a record date such as "2006-01-02" as the start of
+// that UTC day.
+func ParseRecordDate(value string) (time.Time, error) {
+	date, err := time.Parse(recordDateLayout, value)
+	if err != nil {
+		return time.Time{}, ErrInvalidRecordDate
+	}
+	return date, nil
+}
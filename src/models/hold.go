@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type HoldStatus string
+
+const (
+	HoldPending   HoldStatus = "pending"
+	HoldSettled   HoldStatus = "settled"
+	HoldCancelled HoldStatus = "cancelled"
+)
+
+type HoldType string
+
+const (
+	HoldWithdrawal HoldType = "withdrawal"
+	// HoldOrder reserves the funds of an open buy order. It is settled by
+	// the buy when the order fills.
+	HoldOrder HoldType = "order"
+)
+
+// Hold reserves part of a balance until it is settled, which debits it, or
+// cancelled, which makes it available again. While a hold is pending its
+// amount counts towards the user's HeldBalance.
+type Hold struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	UserID      string             `bson:"userID" json:"-"`
+	Email       string             `bson:"email" json:"email"`
+	Type        HoldType           `bson:"type" json:"type"`
+	Amount      Money              `bson:"amount" json:"amount"`
+	Status      HoldStatus         `bson:"status" json:"status"`
+	ReferenceID string             `bson:"referenceID,omitempty" json:"referenceID,omitempty"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	ResolvedAt  *time.Time         `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+}
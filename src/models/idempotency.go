@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// IdempotencyRecord remembers the response to a request sent with an
+// Idempotency-Key header, so that a retry of the request gets the same
+// response instead of running again. Keys are scoped to the user sending
+// them. Until the first request finishes, Completed is false.
+type IdempotencyRecord struct {
+	Email       string    `bson:"email" json:"email"`
+	Key         string    `bson:"key" json:"key"`
+	RequestHash string    `bson:"requestHash" json:"-"`
+	Completed   bool      `bson:"completed" json:"completed"`
+	StatusCode  int       `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	ContentType string    `bson:"contentType,omitempty" json:"contentType,omitempty"`
+	Body        []byte    `bson:"body,omitempty" json:"-"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+	ExpiresAt   time.Time `bson:"expiresAt" json:"expiresAt"`
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LedgerEntryType says why a balance moved.
+type LedgerEntryType string
+
+const (
+	// LedgerOpening is the balance a user had before the ledger existed.
+	LedgerOpening LedgerEntryType = "opening"
+	LedgerDeposit LedgerEntryType = "deposit"
+	LedgerBuy     LedgerEntryType = "buy"
+	LedgerSell    LedgerEntryType = "sell"
+	// LedgerWithdrawal is a settled withdrawal hold.
+	LedgerWithdrawal LedgerEntryType = "withdrawal"
+	// LedgerDividend is a cash dividend. Its ReferenceID is the corporate
+	// action that paid it.
+	LedgerDividend LedgerEntryType = "dividend"
+	// LedgerReversal undoes an earlier entry, named by its ReferenceID. It is
+	// written when a multi-step operation fails halfway on a deployment
+	// without transactions.
+	LedgerReversal LedgerEntryType = "reversal"
+)
+
+// BalanceChange describes one movement of money to be applied to a balance.
+type BalanceChange struct {
+	Type LedgerEntryType
+	// Amount is the size of the movement and is never negative; Credit says
+	// whether it is added to or taken off the balance.
+	Amount      Money
+	Credit      bool
+	ReferenceID string
+	Symbol      string
+	Quantity    int
+	Price       *Money
+	// Fee is the part of Amount that paid trading fees.
+	Fee *Money
+	// Release is taken off the held balance in the same update, when a debit
+	// settles a hold. The held amount counts towards what the debit may
+	// spend.
+	Release Money
+}
+
+// Delta is the signed amount the change adds to the balance.
+func (c BalanceChange) Delta() Money {
+	if c.Credit {
+		return c.Amount
+	}
+	return c.Amount.Neg()
+}
+
+// LedgerEntry is an immutable record of a balance movement. Entries are only
+// ever appended; the sum of a user's entries equals their balance. They are
+// keyed by the id of the user, like lots, and outlive the user.
+type LedgerEntry struct {
+	ID     primitive.ObjectID `bson:"_id" json:"id"`
+	UserID string             `bson:"userID" json:"-"`
+	Email  string             `bson:"email" json:"email"`
+	Type   LedgerEntryType    `bson:"type" json:"type"`
+	// Amount is signed: credits are positive and debits negative.
+	Amount       Money     `bson:"amount" json:"amount"`
+	BalanceAfter Money     `bson:"balanceAfter" json:"balanceAfter"`
+	ReferenceID  string    `bson:"referenceID,omitempty" json:"referenceID,omitempty"`
+	Symbol       string    `bson:"symbol,omitempty" json:"symbol,omitempty"`
+	Quantity     int       `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	Price        *Money    `bson:"price,omitempty" json:"price,omitempty"`
+	Fee          *Money    `bson:"fee,omitempty" json:"fee,omitempty"`
+	CreatedAt    time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// NewLedgerEntry records change on the balance of a user, which became
+// balanceAfter at createdAt.
+func NewLedgerEntry(userID string, email string, change BalanceChange, balanceAfter Money, createdAt time.Time) LedgerEntry {
+	return LedgerEntry{
+		ID:           primitive.NewObjectID(),
+		UserID:       userID,
+		Email:        email,
+		Type:         change.Type,
+		Amount:       change.Delta(),
+		BalanceAfter: balanceAfter,
+		ReferenceID:  change.ReferenceID,
+		Symbol:       change.Symbol,
+		Quantity:     change.Quantity,
+		Price:        change.Price,
+		Fee:          change.Fee,
+		CreatedAt:    createdAt,
+	}
+}
+
+// LedgerPage is one page of a ledger. NextCursor is empty on the last page.
+type LedgerPage struct {
+	Entries    []LedgerEntry `json:"entries"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// BalanceVerification compares a stored balance with the sum of the ledger.
+type BalanceVerification struct {
+	Email      string `json:"email"`
+	Stored     Money  `json:"stored"`
+	Derived    Money  `json:"derived"`
+	Entries    int64  `json:"entries"`
+	Consistent bool   `json:"consistent"`
+}
+
+// AccountSnapshot is a user with their lots, their whole ledger and their
+// pending holds, all read at the same point in time.
+type AccountSnapshot struct {
+	User   User
+	Ledger []LedgerEntry
+	Holds  []Hold
+}
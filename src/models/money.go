@@ -0,0 +1,251 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// DefaultCurrency is used for amounts that do not name a currency.
+const DefaultCurrency = "USD"
+
+// MinorUnitDigits is the number of decimal places a Money amount carries.
+const MinorUnitDigits = 2
+
+const minorUnitsPerMajor = 100
+
+var (
+	ErrInvalidMoney    = errors.New("Amount is not a valid decimal number.")
+	ErrMoneyTooPrecise = errors.New("Amount has more than 2 decimal places.")
+	ErrInvalidCurrency = errors.New("Currency must be a three letter code such as USD.")
+	// ErrMoneyOverflow is returned when the result of Add or Mul does not fit
+	// in an int64 of minor units.
+	ErrMoneyOverflow = errors.New("Amount is too large.")
+)
+
+// Money is an exact amount of a currency, held as an integer number of minor
+// units (cents for USD) so that arithmetic never rounds.
+//
+// In MongoDB it is stored as {amount: <int64 minor units>, currency: "USD"}.
+// In JSON the amount is written as a decimal string, e.g.
+// {"amount": "12.34", "currency": "USD"}; plain numbers and strings are also
+// accepted when decoding.
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{Amount: amount, Currency: currency}
+}
+
+// MoneyFromFloat converts a legacy float amount, rounding to the nearest minor
+// unit. It only exists to migrate old documents.
+func MoneyFromFloat(value float64, currency string) Money {
+	return NewMoney(int64(math.Round(value*minorUnitsPerMajor)), currency)
+}
+
+// ParseMoney parses a decimal string such as "12.34" or "-5" exactly. An
+// empty currency means DefaultCurrency.
+func ParseMoney(value string, currency string) (Money, error) {
+	if currency != "" && !ValidCurrency(currency) {
+		return Money{}, ErrInvalidCurrency
+	}
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	whole, fraction := value, ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		whole, fraction = value[:i], value[i+1:]
+	}
+	if whole == "" && fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, ErrInvalidMoney
+	}
+	if len(fraction) > MinorUnitDigits {
+		if strings.TrimRight(fraction[MinorUnitDigits:], "0") != "" {
+			return Money{}, ErrMoneyTooPrecise
+		}
+		fraction = fraction[:MinorUnitDigits]
+	}
+	fraction += strings.Repeat("0", MinorUnitDigits-len(fraction))
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidMoney
+	}
+	if negative {
+		amount = -amount
+	}
+	return NewMoney(amount, currency), nil
+}
+
+// ValidCurrency reports whether currency looks like an ISO 4217 code.
+func ValidCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, c := range currency {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount as a decimal without the currency, e.g. "-12.30".
+func (m Money) String() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	major := strconv.FormatInt(amount/minorUnitsPerMajor, 10)
+	minor := strconv.FormatInt(amount%minorUnitsPerMajor, 10)
+	return sign + major + "." + strings.Repeat("0", MinorUnitDigits-len(minor)) + minor
+}
+
+// SameCurrency reports whether m and other can be added together. An empty
+// currency stands for DefaultCurrency.
+func (m Money) SameCurrency(other Money) bool {
+	return NewMoney(0, m.Currency).Currency == NewMoney(0, other.Currency).Currency
+}
+
+// Add returns m plus other, or ErrMoneyOverflow instead of wrapping around.
+func (m Money) Add(other Money) (Money, error) {
+	sum := m.Amount + other.Amount
+	if (other.Amount > 0 && sum < m.Amount) || (other.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return NewMoney(sum, m.currencyWith(other)), nil
+}
+
+func (m Money) Sub(other Money) Money {
+	return NewMoney(m.Amount-other.Amount, m.currencyWith(other))
+}
+
+// Mul multiplies the amount by a whole number, e.g. a price by a quantity,
+// or returns ErrMoneyOverflow instead of wrapping around.
+func (m Money) Mul(n int64) (Money, error) {
+	product := m.Amount * n
+	if n != 0 && (product/n != m.Amount || (n == -1 && m.Amount == math.MinInt64)) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return NewMoney(product, m.Currency), nil
+}
+
+func (m Money) Neg() Money {
+	return NewMoney(-m.Amount, m.Currency)
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) LessThan(other Money) bool {
+	return m.Amount < other.Amount
+}
+
+func (m Money) currencyWith(other Money) string {
+	if m.Currency != "" {
+		return m.Currency
+	}
+	return other.Currency
+}
+
+type moneyJSON struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.String(), NewMoney(0, m.Currency).Currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	doc := moneyJSON{}
+	if len(data) > 0 && data[0] == '{' {
+		err := json.Unmarshal(data, &doc)
+		if err != nil {
+			return ErrInvalidMoney
+		}
+	} else {
+		err := json.Unmarshal(data, &doc.Amount)
+		if err != nil {
+			return ErrInvalidMoney
+		}
+	}
+
+	parsed, err := ParseMoney(doc.Amount.String(), doc.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// moneyDocument has the fields of Money without its methods, so it decodes
+// with the default struct codec.
+type moneyDocument Money
+
+// UnmarshalBSONValue decodes the {amount, currency} document written by this
+// service, and also the bare doubles older versions stored.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: t, Data: data}
+	switch t {
+	case bsontype.EmbeddedDocument:
+		doc := moneyDocument{}
+		err := bson.Unmarshal(data, &doc)
+		if err != nil {
+			return err
+		}
+		*m = NewMoney(doc.Amount, doc.Currency)
+	case bsontype.Double:
+		*m = MoneyFromFloat(value.Double(), "")
+	case bsontype.Int32:
+		*m = NewMoney(int64(value.Int32())*minorUnitsPerMajor, "")
+	case bsontype.Int64:
+		*m = NewMoney(value.Int64()*minorUnitsPerMajor, "")
+	case bsontype.Null, bsontype.Undefined:
+		*m = Money{}
+	default:
+		return errors.New("Cannot decode money from BSON " + t.String())
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value  string
+		amount int64
+		err    error
+	}{
+		{"12.34", 1234, nil},
+		{"-5", -500, nil},
+		{"+0.5", 50, nil},
+		{" 7.", 700, nil},
+		{".25", 25, nil},
+		{"1.230", 123, nil},
+		{"1.234", 0, ErrMoneyTooPrecise},
+		{"", 0, ErrInvalidMoney},
+		{".", 0, ErrInvalidMoney},
+		{"1e3", 0, ErrInvalidMoney},
+		{"1,000.00", 0, ErrInvalidMoney},
+		{"99999999999999999999", 0, ErrInvalidMoney},
+	}
+	for _, test := range tests {
+		money, err := ParseMoney(test.value, "USD")
+		if !errors.Is(err, test.err) {
+			t.Errorf("ParseMoney(%q) returned %v, want %v", test.value, err, test.err)
+			continue
+		}
+		if err == nil && (money.Amount != test.amount || money.Currency != "USD") {
+			t.Errorf("ParseMoney(%q) = %+v, want %d USD", test.value, money, test.amount)
+		}
+	}
+
+	if _, err := ParseMoney("1.00", "usd"); !errors.Is(err, ErrInvalidCurrency) {
+		t.Errorf("ParseMoney with currency usd returned %v, want ErrInvalidCurrency", err)
+	}
+	if money, err := ParseMoney("1.00", ""); err != nil || money.Currency != DefaultCurrency {
+		t.Errorf("ParseMoney without a currency returned %+v, %v; want %s", money, err, DefaultCurrency)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := map[int64]string{0: "0.00", 5: "0.05", 1230: "12.30", -1230: "-12.30"}
+	for amount, want := range tests {
+		if got := NewMoney(amount, "USD").String(); got != want {
+			t.Errorf("String of %d is %q, want %q", amount, got, want)
+		}
+	}
+}
+
+func TestMoneyArithmeticRefusesOverflow(t *testing.T) {
+	largest := NewMoney(math.MaxInt64, "USD")
+	smallest := NewMoney(math.MinInt64, "USD")
+	one := NewMoney(1, "USD")
+
+	if _, err := largest.Add(one); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("MaxInt64 + 1 returned %v, want ErrMoneyOverflow", err)
+	}
+	if _, err := smallest.Add(one.Neg()); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("MinInt64 - 1 returned %v, want ErrMoneyOverflow", err)
+	}
+	if _, err := largest.Mul(2); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("MaxInt64 * 2 returned %v, want ErrMoneyOverflow", err)
+	}
+	if _, err := smallest.Mul(-1); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("MinInt64 * -1 returned %v, want ErrMoneyOverflow", err)
+	}
+
+	sum, err := largest.Add(largest.Neg())
+	if err != nil || !sum.IsZero() {
+		t.Errorf("MaxInt64 - MaxInt64 returned %+v, %v; want zero", sum, err)
+	}
+	product, err := NewMoney(15000, "USD").Mul(3)
+	if err != nil || product.Amount != 45000 {
+		t.Errorf("150.00 * 3 returned %+v, %v; want 450.00", product, err)
+	}
+	product, err = largest.Mul(0)
+	if err != nil || !product.IsZero() {
+		t.Errorf("MaxInt64 * 0 returned %+v, %v; want zero", product, err)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	money := Money{}
+	for _, data := range []string{`"12.34"`, `12.34`, `{"amount": "12.34", "currency": "USD"}`} {
+		err := money.UnmarshalJSON([]byte(data))
+		if err != nil || money != NewMoney(1234, "USD") {
+			t.Errorf("UnmarshalJSON(%s) gave %+v, %v; want 12.34 USD", data, money, err)
+		}
+	}
+	if err := money.UnmarshalJSON([]byte(`12.345`)); !errors.Is(err, ErrMoneyTooPrecise) {
+		t.Errorf("UnmarshalJSON(12.345) returned %v, want ErrMoneyTooPrecise", err)
+	}
+
+	data, err := NewMoney(-1230, "").MarshalJSON()
+	if err != nil || string(data) != `{"amount":"-12.30","currency":"`+DefaultCurrency+`"}` {
+		t.Errorf("MarshalJSON gave %s, %v", data, err)
+	}
+}
+
+func TestMoneyDecodesLegacyBSON(t *testing.T) {
+	type account struct {
+		Balance Money `bson:"balance"`
+	}
+	tests := []struct {
+		name  string
+		value interface{}
+		want  Money
+	}{
+		{"document", NewMoney(1234, "EUR"), NewMoney(1234, "EUR")},
+		{"double", 12.345, NewMoney(1235, "")},
+		{"int32", int32(12), NewMoney(1200, "")},
+		{"int64", int64(-3), NewMoney(-300, "")},
+		{"null", nil, Money{}},
+	}
+	for _, test := range tests {
+		data, err := bson.Marshal(bson.D{{Key: "balance", Value: test.value}})
+		if err != nil {
+			t.Fatalf("%s: Marshal: %v", test.name, err)
+		}
+		decoded := account{}
+		err = bson.Unmarshal(data, &decoded)
+		if err != nil || decoded.Balance != test.want {
+			t.Errorf("%s: decoded %+v, %v; want %+v", test.name, decoded.Balance, err, test.want)
+		}
+	}
+
+	data, err := bson.Marshal(bson.D{{Key: "balance", Value: "12.34"}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := bson.Unmarshal(data, &account{}); err == nil {
+		t.Error("a string balance was decoded, want an error")
+	}
+}
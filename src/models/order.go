@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OrderSide string
+
+const (
+	OrderBuy  OrderSide = "buy"
+	OrderSell OrderSide = "sell"
+)
+
+// OrderType decides the price at which an order may fill.
+type OrderType string
+
+const (
+	// OrderMarket fills at the market price as soon as it is evaluated.
+	OrderMarket OrderType = "MARKET"
+	// OrderLimit fills once the market price is at or better than
+	// LimitPrice: no higher for buys, no lower for sells.
+	OrderLimit OrderType = "LIMIT"
+	// OrderStop becomes a market order once the market price reaches
+	// StopPrice: rises to it for buys, falls to it for sells.
+	OrderStop OrderType = "STOP"
+	// OrderStopLimit becomes a limit order once the market price reaches
+	// StopPrice.
+	OrderStopLimit OrderType = "STOP_LIMIT"
+)
+
+// TimeInForce decides how long an order stays open.
+type TimeInForce string
+
+const (
+	// GoodTillCancelled orders stay open until they fill or are cancelled.
+	GoodTillCancelled TimeInForce = "GTC"
+	// GoodForDay orders expire at the end of the UTC day they were placed.
+	GoodForDay TimeInForce = "DAY"
+)
+
+type OrderStatus string
+
+const (
+	OrderOpen      OrderStatus = "open"
+	OrderFilled    OrderStatus = "filled"
+	OrderCancelled OrderStatus = "cancelled"
+	OrderExpired   OrderStatus = "expired"
+	// OrderRejected is an order that could not fill when the market reached
+	// its price, e.g. for lack of funds or shares. Reason says why.
+	OrderRejected OrderStatus = "rejected"
+)
+
+// Order buys or sells shares once the market reaches its price. While a buy
+// order is open, the funds it may need are reserved by the hold HoldID.
+type Order struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	UserID      string             `bson:"userID" json:"-"`
+	Email       string             `bson:"email" json:"email"`
+	Side        OrderSide          `bson:"side" json:"side"`
+	Type        OrderType          `bson:"type" json:"type"`
+	Symbol      string             `bson:"symbol" json:"symbol"`
+	Quantity    int                `bson:"quantity" json:"quantity"`
+	LimitPrice  *Money             `bson:"limitPrice,omitempty" json:"limitPrice,omitempty"`
+	StopPrice   *Money             `bson:"stopPrice,omitempty" json:"stopPrice,omitempty"`
+	TimeInForce TimeInForce        `bson:"timeInForce" json:"timeInForce"`
+	// Matching is the order in which a sell order consumes lots.
+	Matching LotMatching `bson:"matching,omitempty" json:"matching,omitempty"`
+	Status   OrderStatus `bson:"status" json:"status"`
+	// Triggered is set once the market reached the stop price of a stop
+	// order.
+	Triggered bool   `bson:"triggered" json:"triggered"`
+	HoldID    string `bson:"holdID,omitempty" json:"holdID,omitempty"`
+	FillPrice *Money `bson:"fillPrice,omitempty" json:"fillPrice,omitempty"`
+	// ReferenceID is the lot a filled buy order created, or the sale a
+	// filled sell order made.
+	ReferenceID string     `bson:"referenceID,omitempty" json:"referenceID,omitempty"`
+	Reason      string     `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt   time.Time  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt   *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	ClosedAt    *time.Time `bson:"closedAt,omitempty" json:"closedAt,omitempty"`
+}
@@ -0,0 +1,17 @@
+package models
+
+// LotSummary totals the lots of a user in one symbol and currency that are
+// either all open or all sold.
+type LotSummary struct {
+	Symbol   string `bson:"symbol" json:"symbol"`
+	Currency string `bson:"currency" json:"currency"`
+	Open     bool   `bson:"open" json:"open"`
+	Lots     int    `bson:"lots" json:"lots"`
+	Quantity int    `bson:"quantity" json:"quantity"`
+	// Cost is the sum of the CostBasis of the lots and Proceeds that of
+	// their Proceeds, so both account for fees. Fees adds up the buy and
+	// sell fees.
+	Cost     Money `bson:"cost" json:"cost"`
+	Proceeds Money `bson:"proceeds" json:"proceeds"`
+	Fees     Money `bson:"fees" json:"fees"`
+}
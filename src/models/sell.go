@@ -0,0 +1,59 @@
+package models
+
+// LotMatching decides which lots a sell consumes first.
+type LotMatching string
+
+const (
+	// MatchFIFO sells the oldest lots first.
+	MatchFIFO LotMatching = "FIFO"
+	// MatchLIFO sells the newest lots first.
+	MatchLIFO LotMatching = "LIFO"
+	// MatchSpecificLot sells the lots listed in SellOrder.ShareIDs, in order.
+	MatchSpecificLot LotMatching = "SPECIFIC"
+	// MatchHighestCost sells the lots with the highest purchase price first.
+	MatchHighestCost LotMatching = "HIGHEST_COST"
+)
+
+// Valid reports whether m is one of the supported policies.
+func (m LotMatching) Valid() bool {
+	switch m {
+	case MatchFIFO, MatchLIFO, MatchSpecificLot, MatchHighestCost:
+		return true
+	}
+	return false
+}
+
+// SellOrder sells a quantity of a symbol across as many lots as needed.
+type SellOrder struct {
+	Symbol    string      `json:"symbol"`
+	Quantity  int         `json:"quantity"`
+	PriceSold Money       `json:"priceSold"`
+	Matching  LotMatching `json:"matching"`
+	ShareIDs  []string    `json:"shareIDs"`
+}
+
+// ConsumedLot is the part of one lot a sell used up.
+type ConsumedLot struct {
+	// ShareID is the sold lot. When only part of a lot was sold, the lot was
+	// split and this is the id of the new, sold part.
+	ShareID       string `json:"shareID"`
+	SourceShareID string `json:"sourceShareID"`
+	Quantity      int    `json:"quantity"`
+	PriceBaught   Money  `json:"priceBaught"`
+	PriceSold     Money  `json:"priceSold"`
+	// Fee is the part of the fee of the sale charged to this lot.
+	Fee          Money `json:"fee"`
+	RealizedGain Money `json:"realizedGain"`
+}
+
+type SellResult struct {
+	SaleID   string `json:"saleID"`
+	Symbol   string `json:"symbol"`
+	Quantity int    `json:"quantity"`
+	Price    Money  `json:"price"`
+	Fee      Money  `json:"fee"`
+	// Proceeds is what was credited: Price times Quantity, less Fee.
+	Proceeds     Money         `json:"proceeds"`
+	RealizedGain Money         `json:"realizedGain"`
+	Lots         []ConsumedLot `json:"lots"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// RefreshToken is the server side record of a refresh token. Tokens issued by
+// refreshing another one share its FamilyID, so a replayed token can revoke
+// every descendant at once.
+type RefreshToken struct {
+	TokenHash string    `bson:"tokenHash" json:"-"`
+	Email     string    `bson:"email" json:"email"`
+	FamilyID  string    `bson:"familyID" json:"familyID"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	Revoked   bool      `bson:"revoked" json:"revoked"`
+}
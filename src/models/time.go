@@ -0,0 +1,70 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Stores take one, so that tests can control the
+// timestamps they write.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock, in UTC.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// ManualClock stands still until it is set or advanced. It is meant for
+// tests.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// timeStringLayout is the layout of time.Time.String(), which older versions
+// used for the date fields of users and shares. The "timestamps" migration
+// converts them to dates.
+const timeStringLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// ParseTimestamp parses the timestamps stored by this service: RFC 3339, or
+// the output of time.Time.String() including its monotonic clock suffix.
+func ParseTimestamp(value string) (time.Time, error) {
+	if i := strings.Index(value, " m="); i >= 0 {
+		value = value[:i]
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return parsed, nil
+	}
+	return time.Parse(timeStringLayout, value)
+}
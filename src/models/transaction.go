@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Transaction is a buy or sell of a user, read from their ledger. Amount is
+// what it did to the balance, so buys are negative; Fee is part of it.
+type Transaction struct {
+	ID          string          `json:"id"`
+	Type        LedgerEntryType `json:"type"`
+	Symbol      string          `json:"symbol"`
+	Quantity    int             `json:"quantity"`
+	Price       *Money          `json:"price,omitempty"`
+	Fee         *Money          `json:"fee,omitempty"`
+	Amount      Money           `json:"amount"`
+	ReferenceID string          `json:"referenceID"`
+	Date        time.Time       `json:"date"`
+}
+
+// NewTransaction reads a buy or sell ledger entry as a transaction.
+func NewTransaction(entry LedgerEntry) Transaction {
+	return Transaction{
+		ID:          entry.ID.Hex(),
+		Type:        entry.Type,
+		Symbol:      entry.Symbol,
+		Quantity:    entry.Quantity,
+		Price:       entry.Price,
+		Fee:         entry.Fee,
+		Amount:      entry.Amount,
+		ReferenceID: entry.ReferenceID,
+		Date:        entry.CreatedAt,
+	}
+}
+
+// TransactionQuery selects the transactions of a user. Empty fields match
+// everything. From is inclusive and To exclusive.
+type TransactionQuery struct {
+	Symbol string
+	// Type is LedgerBuy or LedgerSell.
+	Type LedgerEntryType
+	From *time.Time
+	To   *time.Time
+	// Descending lists the newest transactions first.
+	Descending bool
+	// After is the NextCursor of the previous page.
+	After string
+	Limit int
+}
+
+// TransactionPage is one page of transactions. NextCursor is empty on the
+// last page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"nextCursor,omitempty"`
+}
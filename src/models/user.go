@@ -1,20 +1,48 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"errors"
+	"math/big"
+	"net/mail"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidEmail is returned for an email that is not a plain address such
+// as name@example.com.
+var ErrInvalidEmail = errors.New("Email must be an address such as name@example.com.")
+
+// maxEmailLength is the longest address SMTP can deliver to.
+const maxEmailLength = 254
 
 type User struct {
-	Username      string  `bson:"username" json:"username"`
-	Email         string  `bson:"email" json:"email"`
-	EmailConfimed bool    `bson:"emailConfirmed" json:"emailConfirmed"`
-	Phone         string  `bson:"phone" json:"phone"`
-	Hash          string  `bson:"hash" json:"hash"`
-	FirstName     string  `bson:"firstName" json:"firstName"`
-	MiddleName    string  `bson:"middleName" json:"middleName"`
-	LastName      string  `bson:"lastName" json:"lastName"`
-	AccountStatus string  `bson:"accountStatus" json:"accountStatus"`
-	Balance       float64 `bson:"balance" json:"balance"`
-	CreatedDate   string  `bson:"createdDate" json:"createdDate"`
-	Shares        []Share `bson:"shares" json:"shares"`
+	Username      string        `bson:"username" json:"username"`
+	Email         string        `bson:"email" json:"email"`
+	EmailConfimed bool          `bson:"emailConfirmed" json:"emailConfirmed"`
+	Phone         string        `bson:"phone" json:"phone"`
+	Hash          string        `bson:"hash" json:"hash"`
+	FirstName     string        `bson:"firstName" json:"firstName"`
+	MiddleName    string        `bson:"middleName" json:"middleName"`
+	LastName      string        `bson:"lastName" json:"lastName"`
+	AccountStatus AccountStatus `bson:"accountStatus" json:"accountStatus"`
+	Balance       Money         `bson:"balance" json:"balance"`
+	HeldBalance   Money         `bson:"heldBalance" json:"heldBalance"`
+	CreatedDate   time.Time     `bson:"createdDate" json:"createdDate"`
+	Shares        []Share       `bson:"shares,omitempty" json:"shares"`
+}
+
+// ValidateEmail accepts a bare address and refuses display names, comments
+// and anything else net/mail would parse around it.
+func ValidateEmail(email string) error {
+	if email == "" || len(email) > maxEmailLength {
+		return ErrInvalidEmail
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return ErrInvalidEmail
+	}
+	return nil
 }
 
 type UserID struct {
@@ -27,7 +55,22 @@ type UserCredentials struct {
 }
 
 type Balance struct {
-	Balance float64 `bson:"balance" json:"balance"`
+	Balance Money `bson:"balance" json:"balance"`
+	Held    Money `bson:"heldBalance" json:"heldBalance"`
+}
+
+// Summary splits the balance into what is held and what is available.
+func (b Balance) Summary() AccountBalance {
+	held := NewMoney(b.Held.Amount, b.Balance.Currency)
+	return AccountBalance{Total: b.Balance, Available: b.Balance.Sub(held), Held: held}
+}
+
+// AccountBalance is a balance together with the part of it that pending
+// holds reserve. Only Available can be spent.
+type AccountBalance struct {
+	Total     Money `json:"total"`
+	Available Money `json:"available"`
+	Held      Money `json:"held"`
 }
 
 type Shares struct {
@@ -35,14 +78,70 @@ type Shares struct {
 	Shares []Share            `bson:"shares" json:"shares"`
 }
 
+// Share is a lot: a quantity of one symbol bought together. Lots live in the
+// Lots collection, keyed by the id of the user who owns them, and are copied
+// into User.Shares when a user is read. Older versions embedded them in the
+// user document instead; the "lots" migration moves them out.
 type Share struct {
-	ShareID       string  `bson:"shareID" json:"shareID"`
-	Symbol        string  `bson:"symbol" json:"symbol"`
-	Company       string  `bson:"company" json:"company"`
-	Quantity      int     `bson:"quantity" json:"quantity"`
-	PriceBaught   float64 `bson:"priceBaught" json:"priceBaught"`
-	PriceSold     float64 `bson:"priceSold" json:"priceSold"`
-	SoldIndicator string  `bson:"soldIndicator" json:"soldIndicator"`
-	DateBaught    string  `bson:"dateBaught" json:"dateBaught"`
-	DateSold      string  `bson:"dateSold" json:"dateSold"`
+	UserID        string    `bson:"userID,omitempty" json:"-"`
+	ShareID       string    `bson:"shareID" json:"shareID"`
+	Symbol        string    `bson:"symbol" json:"symbol"`
+	Company       string    `bson:"company" json:"company"`
+	Quantity      int       `bson:"quantity" json:"quantity"`
+	PriceBaught   Money     `bson:"priceBaught" json:"priceBaught"`
+	PriceSold     Money     `bson:"priceSold" json:"priceSold"`
+	SoldIndicator string    `bson:"soldIndicator" json:"soldIndicator"`
+	DateBaught    time.Time `bson:"dateBaught" json:"dateBaught"`
+	// DateSold is set once the lot is sold.
+	DateSold *time.Time `bson:"dateSold,omitempty" json:"dateSold,omitempty"`
+	// ParentShareID is set on the sold part of a lot that was split by a
+	// partial sell, and names the lot it came from.
+	ParentShareID string `bson:"parentShareID,omitempty" json:"parentShareID,omitempty"`
+	// SaleID groups the lots consumed by one sell and is the reference of
+	// its ledger entry.
+	SaleID string `bson:"saleID,omitempty" json:"saleID,omitempty"`
+	// BuyFee is the fee paid to buy the lot and SellFee its part of the fee
+	// of the sale that sold it. Both count towards RealizedGain.
+	BuyFee  Money `bson:"buyFee" json:"buyFee"`
+	SellFee Money `bson:"sellFee" json:"sellFee"`
+	// SplitTo and SplitFrom multiply together the splits applied to the lot
+	// since it was bought, when it held Quantity*SplitFrom/SplitTo shares.
+	// Both are zero for lots that were never split.
+	SplitTo   int `bson:"splitTo,omitempty" json:"splitTo,omitempty"`
+	SplitFrom int `bson:"splitFrom,omitempty" json:"splitFrom,omitempty"`
+	// BasisAdjustment is the part of the cost that a split could not spread
+	// over PriceBaught in whole minor units.
+	BasisAdjustment Money `bson:"basisAdjustment" json:"basisAdjustment"`
+	RealizedGain    Money `bson:"realizedGain" json:"realizedGain"`
+}
+
+// CostBasis is what buying the lot cost, fee included.
+func (s Share) CostBasis() (Money, error) {
+	cost, err := s.PriceBaught.Mul(int64(s.Quantity))
+	if err != nil {
+		return Money{}, err
+	}
+	cost, err = cost.Add(s.BuyFee)
+	if err != nil {
+		return Money{}, err
+	}
+	return cost.Add(s.BasisAdjustment)
+}
+
+// UnsplitQuantity is Quantity counted in the shares the lot was bought in,
+// before any split.
+func (s Share) UnsplitQuantity() *big.Rat {
+	if s.SplitTo == 0 || s.SplitFrom == 0 {
+		return big.NewRat(int64(s.Quantity), 1)
+	}
+	return big.NewRat(int64(s.Quantity)*int64(s.SplitFrom), int64(s.SplitTo))
+}
+
+// Proceeds is what selling the lot credited, after its part of the fee.
+func (s Share) Proceeds() (Money, error) {
+	proceeds, err := s.PriceSold.Mul(int64(s.Quantity))
+	if err != nil {
+		return Money{}, err
+	}
+	return proceeds.Sub(s.SellFee), nil
 }
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateEmail(t *testing.T) {
+	valid := []string{"name@example.com", "first.last+tag@sub.example.org"}
+	for _, email := range valid {
+		if err := ValidateEmail(email); err != nil {
+			t.Errorf("ValidateEmail(%q) = %v, want it accepted", email, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"name",
+		"name@",
+		"Name <name@example.com>",
+		"name@example.com (comment)",
+		" name@example.com",
+		"../../etc/passwd@example.com/x",
+		strings.Repeat("a", 250) + "@example.com",
+	}
+	for _, email := range invalid {
+		if err := ValidateEmail(email); !errors.Is(err, ErrInvalidEmail) {
+			t.Errorf("ValidateEmail(%q) = %v, want ErrInvalidEmail", email, err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WatchlistItem is a symbol a user follows, with a note of their own.
+type WatchlistItem struct {
+	Symbol string `bson:"symbol" json:"symbol"`
+	Note   string `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// Watchlist is a named, ordered list of symbols a user follows whether or not
+// they hold them. Watchlists live in their own collection, keyed by the id of
+// the user, and names are unique per user.
+type Watchlist struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	UserID    string             `bson:"userID" json:"-"`
+	Name      string             `bson:"name" json:"name"`
+	Items     []WatchlistItem    `bson:"items" json:"items"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
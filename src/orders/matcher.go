@@ -0,0 +1,162 @@
+package orders
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/quotes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Matcher executes open orders once the market reaches their price. Fills go
+// through the store, which buys and sells exactly like an immediate trade.
+type Matcher struct {
+	store  db.OrderStore
+	quotes quotes.Provider
+	// clock decides when orders expire.
+	clock models.Clock
+}
+
+func NewMatcher(store db.OrderStore, provider quotes.Provider) *Matcher {
+	return &Matcher{store: store, quotes: provider, clock: models.SystemClock{}}
+}
+
+// SetClock replaces the clock, which is the system clock by default. It
+// should be the clock of the store, which sets the expiry of orders.
+func (m *Matcher) SetClock(clock models.Clock) {
+	m.clock = clock
+}
+
+// Run evaluates every open order once and returns how many of them filled.
+func (m *Matcher) Run() (int, error) {
+	open, err := m.store.GetOpenOrders()
+	if err != nil {
+		return 0, err
+	}
+	filled := 0
+	for _, order := range open {
+		result, err := m.Evaluate(order)
+		if err != nil {
+			if !errors.Is(err, db.ErrOrderNotOpen) {
+				logger.Error("Unable to evaluate order " + order.ID.Hex() + ": " + err.Error())
+			}
+			continue
+		}
+		if result.Status == models.OrderFilled {
+			filled++
+		}
+	}
+	return filled, nil
+}
+
+// RunEvery evaluates the open orders each interval. It never returns.
+func (m *Matcher) RunEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		filled, err := m.Run()
+		if err != nil {
+			logger.Error("Unable to match orders: " + err.Error())
+			continue
+		}
+		if filled > 0 {
+			logger.Info("Filled " + strconv.Itoa(filled) + " orders")
+		}
+	}
+}
+
+// Evaluate expires an open order whose time in force ended, triggers it when
+// the market reached its stop price and fills it when the market price
+// satisfies its limit. Orders that can not fill as placed are rejected. It
+// returns the order as it is afterwards.
+func (m *Matcher) Evaluate(order models.Order) (models.Order, error) {
+	if order.Status != models.OrderOpen {
+		return order, nil
+	}
+	orderID := order.ID.Hex()
+	if order.ExpiresAt != nil && !m.clock.Now().Before(*order.ExpiresAt) {
+		return m.store.CloseOrder(order.Email, orderID, models.OrderExpired, "Time in force ended.")
+	}
+
+	quote, err := m.quotes.Quote(order.Symbol)
+	if err != nil {
+		return m.reject(order, err)
+	}
+	price := quote.Price
+	if !pricedIn(order.LimitPrice, price) || !pricedIn(order.StopPrice, price) {
+		return m.reject(order, db.ErrCurrencyMismatch)
+	}
+
+	if order.StopPrice != nil && !order.Triggered {
+		if !StopReached(order, price) {
+			return order, nil
+		}
+		triggered, err := m.store.TriggerOrder(order.Email, orderID)
+		if err != nil {
+			return order, err
+		}
+		order = triggered
+		logger.Info("Order " + orderID + " triggered at " + price.String())
+	}
+	if !LimitAllows(order, price) {
+		return order, nil
+	}
+
+	filled, err := m.store.FillOrder(order.Email, orderID, price)
+	if err != nil {
+		return m.reject(order, err)
+	}
+	return filled, nil
+}
+
+// StopReached reports whether price reached the stop price of order: a buy
+// stop triggers when the price rises to it, a sell stop when it falls to it.
+func StopReached(order models.Order, price models.Money) bool {
+	if order.StopPrice == nil {
+		return true
+	}
+	if order.Side == models.OrderBuy {
+		return price.Amount >= order.StopPrice.Amount
+	}
+	return price.Amount <= order.StopPrice.Amount
+}
+
+// LimitAllows reports whether order may fill at price: buys no higher and
+// sells no lower than the limit price. Orders without one fill at any price.
+func LimitAllows(order models.Order, price models.Money) bool {
+	if order.LimitPrice == nil {
+		return true
+	}
+	if order.Side == models.OrderBuy {
+		return price.Amount <= order.LimitPrice.Amount
+	}
+	return price.Amount >= order.LimitPrice.Amount
+}
+
+// reject closes order when err means it can not fill as placed, and returns
+// err unchanged otherwise so the order is evaluated again later.
+func (m *Matcher) reject(order models.Order, err error) (models.Order, error) {
+	if !rejects(err) {
+		return order, err
+	}
+	logger.Error("Rejecting order " + order.ID.Hex() + ": " + err.Error())
+	return m.store.CloseOrder(order.Email, order.ID.Hex(), models.OrderRejected, err.Error())
+}
+
+func rejects(err error) bool {
+	return errors.Is(err, quotes.ErrUnknownSymbol) ||
+		errors.Is(err, db.ErrInsufficientFunds) ||
+		errors.Is(err, db.ErrInsufficientShares) ||
+		errors.Is(err, db.ErrCurrencyMismatch) ||
+		errors.Is(err, db.ErrHoldNotPending) ||
+		errors.Is(err, db.ErrAccountBlocked)
+}
+
+// pricedIn reports whether an optional order price is in the currency of the
+// market price.
+func pricedIn(orderPrice *models.Money, price models.Money) bool {
+	return orderPrice == nil || orderPrice.SameCurrency(price)
+}
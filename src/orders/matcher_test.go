@@ -0,0 +1,168 @@
+package orders
+
+import (
+	"dbutil/src/config"
+	db "dbutil/src/database"
+	"dbutil/src/models"
+	"dbutil/src/testutil"
+	"errors"
+	"testing"
+	"time"
+)
+
+// matcherWorld is a world without fees in which the test user has 1000.00,
+// with a matcher on its clock.
+type matcherWorld struct {
+	*testutil.World
+	matcher *Matcher
+}
+
+func newMatcherWorld(t *testing.T) *matcherWorld {
+	t.Helper()
+	w := testutil.NewWorld(t, config.Configuration{})
+	w.Register(t, testutil.Email, "1000.00")
+	matcher := NewMatcher(w.Store, w.Quotes)
+	matcher.SetClock(w.Clock)
+	return &matcherWorld{World: w, matcher: matcher}
+}
+
+func (w *matcherWorld) place(t *testing.T, order models.Order) models.Order {
+	t.Helper()
+	placed, err := w.Store.PlaceOrder(testutil.Email, order)
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	return placed
+}
+
+func (w *matcherWorld) order(t *testing.T, orderID string) models.Order {
+	t.Helper()
+	orders, err := w.Store.GetOrders(testutil.Email, "")
+	if err != nil {
+		t.Fatalf("GetOrders: %v", err)
+	}
+	for _, order := range orders {
+		if order.ID.Hex() == orderID {
+			return order
+		}
+	}
+	t.Fatalf("order %s not found", orderID)
+	return models.Order{}
+}
+
+func (w *matcherWorld) run(t *testing.T, want int) {
+	t.Helper()
+	filled, err := w.matcher.Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if filled != want {
+		t.Fatalf("Run filled %d orders, want %d", filled, want)
+	}
+}
+
+func limitAt(t *testing.T, price string) *models.Money {
+	t.Helper()
+	limit := testutil.USD(t, price)
+	return &limit
+}
+
+func TestLimitBuyFillsAtMarketPrice(t *testing.T) {
+	w := newMatcherWorld(t)
+	order := w.place(t, models.Order{Side: models.OrderBuy, Type: models.OrderLimit, Symbol: "AAPL", Quantity: 2, LimitPrice: limitAt(t, "145.00")})
+
+	w.run(t, 0)
+	w.SetPrice(t, "AAPL", "144.00")
+	w.run(t, 1)
+
+	filled := w.order(t, order.ID.Hex())
+	if filled.Status != models.OrderFilled || filled.FillPrice == nil || *filled.FillPrice != testutil.USD(t, "144.00") {
+		t.Fatalf("order is %+v, want it filled at 144.00", filled)
+	}
+	w.AssertBalance(t, testutil.Email, "712.00", "712.00")
+}
+
+func TestStopSellTriggersWhenPriceFalls(t *testing.T) {
+	w := newMatcherWorld(t)
+	w.Buy(t, testutil.Email, "AAPL", 3)
+	order := w.place(t, models.Order{Side: models.OrderSell, Type: models.OrderStop, Symbol: "AAPL", Quantity: 3, StopPrice: limitAt(t, "140.00")})
+
+	w.run(t, 0)
+	w.SetPrice(t, "AAPL", "139.00")
+	w.run(t, 1)
+
+	filled := w.order(t, order.ID.Hex())
+	if !filled.Triggered || filled.Status != models.OrderFilled {
+		t.Fatalf("order is %+v, want it triggered and filled", filled)
+	}
+	if lots := w.Holdings(t, testutil.Email); len(lots) != 0 {
+		t.Fatalf("holdings are %+v, want none", lots)
+	}
+	// 1000.00 - 450.00 to buy, + 417.00 to sell.
+	w.AssertBalance(t, testutil.Email, "967.00", "967.00")
+}
+
+func TestDayOrderExpiresAtEndOfDay(t *testing.T) {
+	w := newMatcherWorld(t)
+	order := w.place(t, models.Order{Side: models.OrderBuy, Type: models.OrderLimit, Symbol: "AAPL", Quantity: 1, LimitPrice: limitAt(t, "100.00"), TimeInForce: models.GoodForDay})
+
+	w.Clock.Set(time.Date(2026, time.March, 10, 23, 59, 59, 0, time.UTC))
+	w.run(t, 0)
+	if open := w.order(t, order.ID.Hex()); open.Status != models.OrderOpen {
+		t.Fatalf("order is %s before the day ended, want open", open.Status)
+	}
+
+	w.Clock.Advance(time.Second)
+	w.run(t, 0)
+	expired := w.order(t, order.ID.Hex())
+	if expired.Status != models.OrderExpired || expired.ClosedAt == nil || !expired.ClosedAt.Equal(w.Clock.Now()) {
+		t.Fatalf("order is %+v, want it expired at %s", expired, w.Clock.Now())
+	}
+	w.AssertBalance(t, testutil.Email, "1000.00", "1000.00")
+}
+
+func TestSellWithoutSharesIsRejected(t *testing.T) {
+	w := newMatcherWorld(t)
+	order := w.place(t, models.Order{Side: models.OrderSell, Type: models.OrderMarket, Symbol: "AAPL", Quantity: 1})
+
+	w.run(t, 0)
+
+	rejected := w.order(t, order.ID.Hex())
+	if rejected.Status != models.OrderRejected || rejected.Reason != db.ErrInsufficientShares.Error() {
+		t.Fatalf("order is %+v, want it rejected for lack of shares", rejected)
+	}
+}
+
+func TestOrderOfBlockedAccountIsRejected(t *testing.T) {
+	w := newMatcherWorld(t)
+	order := w.place(t, models.Order{Side: models.OrderBuy, Type: models.OrderLimit, Symbol: "AAPL", Quantity: 1, LimitPrice: limitAt(t, "150.00")})
+	w.SetStatus(t, testutil.Email, models.AccountStatusSuspended)
+
+	w.run(t, 0)
+
+	rejected := w.order(t, order.ID.Hex())
+	if rejected.Status != models.OrderRejected || rejected.Reason != db.ErrAccountBlocked.Error() {
+		t.Fatalf("order is %+v, want it rejected for the blocked account", rejected)
+	}
+	w.AssertBalance(t, testutil.Email, "1000.00", "1000.00")
+}
+
+func TestOrderClosedByASplitIsNotFilled(t *testing.T) {
+	w := newMatcherWorld(t)
+	order := w.place(t, models.Order{Side: models.OrderBuy, Type: models.OrderLimit, Symbol: "AAPL", Quantity: 1, LimitPrice: limitAt(t, "150.00")})
+	// The split cancels the order and releases its hold after the matcher
+	// read it.
+	_, err := w.Store.ApplyCorporateAction(models.CorporateAction{ID: "split", Type: models.CorporateSplit, Symbol: "AAPL", SplitTo: 2, SplitFrom: 1})
+	if err != nil {
+		t.Fatalf("ApplyCorporateAction: %v", err)
+	}
+
+	_, err = w.matcher.Evaluate(order)
+	if !errors.Is(err, db.ErrOrderNotOpen) {
+		t.Fatalf("Evaluate returned %v, want ErrOrderNotOpen", err)
+	}
+	if closed := w.order(t, order.ID.Hex()); closed.Status != models.OrderCancelled {
+		t.Fatalf("order is %s, want it left cancelled by the split", closed.Status)
+	}
+	w.AssertBalance(t, testutil.Email, "1000.00", "1000.00")
+}
@@ -0,0 +1,186 @@
+package portfolio
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"dbutil/src/quotes"
+	"time"
+)
+
+// Position is what a user still holds of one symbol. The market fields are
+// missing when the symbol has no quote in the currency it was bought in.
+type Position struct {
+	Symbol         string        `json:"symbol"`
+	Quantity       int           `json:"quantity"`
+	Lots           int           `json:"lots"`
+	CostBasis      models.Money  `json:"costBasis"`
+	AverageCost    models.Money  `json:"averageCost"`
+	Fees           models.Money  `json:"fees"`
+	MarketPrice    *models.Money `json:"marketPrice,omitempty"`
+	MarketValue    *models.Money `json:"marketValue,omitempty"`
+	UnrealizedGain *models.Money `json:"unrealizedGain,omitempty"`
+}
+
+// ClosedPosition is what a user sold of one symbol.
+type ClosedPosition struct {
+	Symbol       string       `json:"symbol"`
+	Quantity     int          `json:"quantity"`
+	Lots         int          `json:"lots"`
+	CostBasis    models.Money `json:"costBasis"`
+	Proceeds     models.Money `json:"proceeds"`
+	Fees         models.Money `json:"fees"`
+	RealizedGain models.Money `json:"realizedGain"`
+}
+
+// Totals adds up the positions in one currency. MarketValue and
+// UnrealizedGain only cover the priced positions; Unpriced counts the rest.
+type Totals struct {
+	Currency       string       `json:"currency"`
+	CostBasis      models.Money `json:"costBasis"`
+	MarketValue    models.Money `json:"marketValue"`
+	UnrealizedGain models.Money `json:"unrealizedGain"`
+	RealizedGain   models.Money `json:"realizedGain"`
+	Unpriced       int          `json:"unpriced"`
+}
+
+// Portfolio is the valuation of everything a user bought.
+type Portfolio struct {
+	Email     string           `json:"email"`
+	Positions []Position       `json:"positions"`
+	Closed    []ClosedPosition `json:"closed"`
+	Totals    []Totals         `json:"totals"`
+	AsOf      time.Time        `json:"asOf"`
+}
+
+// Valuer values portfolios at the current quotes.
+type Valuer struct {
+	store  db.ShareStore
+	quotes quotes.Provider
+	// clock dates the valuations.
+	clock models.Clock
+}
+
+func NewValuer(store db.ShareStore, provider quotes.Provider) *Valuer {
+	return &Valuer{store: store, quotes: provider, clock: models.SystemClock{}}
+}
+
+// SetClock replaces the clock, which is the system clock by default.
+func (v *Valuer) SetClock(clock models.Clock) {
+	v.clock = clock
+}
+
+// Value aggregates the lots of a user per symbol: open lots into positions
+// valued at the market price, sold lots into closed positions with the gain
+// realized between PriceBaught and PriceSold. Cost bases include the buy
+// fees and proceeds are net of the sell fees.
+func (v *Valuer) Value(email string) (Portfolio, error) {
+	summaries, err := v.store.SummarizeLots(email)
+	if err != nil {
+		return Portfolio{}, err
+	}
+
+	portfolio := Portfolio{
+		Email:     email,
+		Positions: []Position{},
+		Closed:    []ClosedPosition{},
+		Totals:    []Totals{},
+		AsOf:      v.clock.Now(),
+	}
+	totals := make(map[string]*Totals)
+	var currencies []string
+	totalsOf := func(currency string) *Totals {
+		t, ok := totals[currency]
+		if !ok {
+			zero := models.NewMoney(0, currency)
+			t = &Totals{Currency: currency, CostBasis: zero, MarketValue: zero, UnrealizedGain: zero, RealizedGain: zero}
+			totals[currency] = t
+			currencies = append(currencies, currency)
+		}
+		return t
+	}
+
+	for _, summary := range summaries {
+		t := totalsOf(summary.Currency)
+		if !summary.Open {
+			closed := ClosedPosition{
+				Symbol:       summary.Symbol,
+				Quantity:     summary.Quantity,
+				Lots:         summary.Lots,
+				CostBasis:    summary.Cost,
+				Proceeds:     summary.Proceeds,
+				Fees:         summary.Fees,
+				RealizedGain: summary.Proceeds.Sub(summary.Cost),
+			}
+			if t.RealizedGain, err = t.RealizedGain.Add(closed.RealizedGain); err != nil {
+				return Portfolio{}, err
+			}
+			portfolio.Closed = append(portfolio.Closed, closed)
+			continue
+		}
+
+		position := Position{
+			Symbol:      summary.Symbol,
+			Quantity:    summary.Quantity,
+			Lots:        summary.Lots,
+			CostBasis:   summary.Cost,
+			AverageCost: averageOf(summary.Cost, summary.Quantity),
+			Fees:        summary.Fees,
+		}
+		if t.CostBasis, err = t.CostBasis.Add(position.CostBasis); err != nil {
+			return Portfolio{}, err
+		}
+		price, ok := v.marketPrice(summary.Symbol, summary.Currency)
+		if ok {
+			value, err := price.Mul(int64(summary.Quantity))
+			if err != nil {
+				return Portfolio{}, err
+			}
+			gain := value.Sub(summary.Cost)
+			position.MarketPrice = &price
+			position.MarketValue = &value
+			position.UnrealizedGain = &gain
+			if t.MarketValue, err = t.MarketValue.Add(value); err != nil {
+				return Portfolio{}, err
+			}
+			if t.UnrealizedGain, err = t.UnrealizedGain.Add(gain); err != nil {
+				return Portfolio{}, err
+			}
+		} else {
+			t.Unpriced++
+		}
+		portfolio.Positions = append(portfolio.Positions, position)
+	}
+	for _, currency := range currencies {
+		portfolio.Totals = append(portfolio.Totals, *totals[currency])
+	}
+	return portfolio, nil
+}
+
+// marketPrice is the quote of symbol, if there is one in currency.
+func (v *Valuer) marketPrice(symbol string, currency string) (models.Money, bool) {
+	quote, err := v.quotes.Quote(symbol)
+	if err != nil {
+		logger.Error("Unable to price " + symbol + ": " + err.Error())
+		return models.Money{}, false
+	}
+	if !quote.Price.SameCurrency(models.NewMoney(0, currency)) {
+		logger.Error(symbol + " is quoted in " + quote.Price.Currency + ", not " + currency)
+		return models.Money{}, false
+	}
+	return quote.Price, true
+}
+
+// averageOf divides cost by quantity, rounding half away from zero to the
+// nearest minor unit.
+func averageOf(cost models.Money, quantity int) models.Money {
+	if quantity == 0 {
+		return models.NewMoney(0, cost.Currency)
+	}
+	q := int64(quantity)
+	amount := cost.Amount
+	if amount < 0 {
+		return models.NewMoney(-((-amount*2 + q) / (2 * q)), cost.Currency)
+	}
+	return models.NewMoney((amount*2+q)/(2*q), cost.Currency)
+}
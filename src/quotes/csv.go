@@ -0,0 +1,96 @@
+package quotes
+
+import (
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CSVProvider serves the prices in a CSV file with the columns symbol, price
+// and an optional currency. A header row is skipped. The file is read again
+// whenever it changes, so prices can be moved by editing it.
+type CSVProvider struct {
+	path     string
+	currency string
+
+	mu      sync.Mutex
+	modTime time.Time
+	prices  *StaticProvider
+}
+
+func NewCSVProvider(path string, currency string) (*CSVProvider, error) {
+	if path == "" {
+		return nil, fmt.Errorf("No quotes file configured")
+	}
+	p := &CSVProvider{path: path, currency: currency}
+	err := p.reload()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *CSVProvider) Quote(symbol string) (Quote, error) {
+	p.mu.Lock()
+	err := p.reload()
+	prices := p.prices
+	p.mu.Unlock()
+	if err != nil {
+		logger.Error("Unable to reload quotes, using the previous prices: " + err.Error())
+	}
+	return prices.Quote(symbol)
+}
+
+// reload reads the file if it changed since it was last read. The caller
+// holds p.mu, except in NewCSVProvider.
+func (p *CSVProvider) reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	if p.prices != nil && info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	prices := make(map[string]models.Money, len(records))
+	for i, record := range records {
+		if len(record) < 2 {
+			return fmt.Errorf("%s line %d: expected symbol and price", p.path, i+1)
+		}
+		if i == 0 && strings.EqualFold(record[0], "symbol") {
+			continue
+		}
+		currency := p.currency
+		if len(record) > 2 && record[2] != "" {
+			currency = record[2]
+		}
+		price, err := models.ParseMoney(record[1], currency)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", p.path, i+1, err)
+		}
+		prices[record[0]] = price
+	}
+
+	p.prices = NewStaticProvider(prices)
+	p.modTime = info.ModTime()
+	return nil
+}
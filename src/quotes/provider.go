@@ -0,0 +1,51 @@
+package quotes
+
+import (
+	"dbutil/src/config"
+	"dbutil/src/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUnknownSymbol is returned for symbols a provider has no price for.
+var ErrUnknownSymbol = errors.New("No market price for symbol.")
+
+// Quote is the price a symbol trades at.
+type Quote struct {
+	Symbol string       `json:"symbol"`
+	Price  models.Money `json:"price"`
+	AsOf   time.Time    `json:"asOf"`
+}
+
+// Provider prices buys and sells. Clients only say what price they expect;
+// executions always happen at the provider's price.
+type Provider interface {
+	Quote(symbol string) (Quote, error)
+}
+
+// NewProvider returns the Provider selected by the "quotes" section of
+// config.json.
+func NewProvider(quotesConfig config.QuotesConfig) (Provider, error) {
+	switch quotesConfig.Type {
+	case "", "static":
+		prices := make(map[string]models.Money, len(quotesConfig.Prices))
+		for symbol, value := range quotesConfig.Prices {
+			price, err := models.ParseMoney(value, quotesConfig.Currency)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid price %q for %s: %w", value, symbol, err)
+			}
+			prices[symbol] = price
+		}
+		return NewStaticProvider(prices), nil
+	case "csv":
+		return NewCSVProvider(quotesConfig.File, quotesConfig.Currency)
+	}
+	return nil, fmt.Errorf("Unknown quotes type %q", quotesConfig.Type)
+}
+
+// normalizeSymbol makes lookups case insensitive.
+func normalizeSymbol(symbol string) string {
+	return strings.ToUpper(strings.TrimSpace(symbol))
+}
@@ -0,0 +1,42 @@
+package quotes
+
+import (
+	"dbutil/src/models"
+	"sync"
+	"time"
+)
+
+// StaticProvider serves fixed prices. It is meant for tests and local runs;
+// Set changes a price while the server is running.
+type StaticProvider struct {
+	mu     sync.RWMutex
+	prices map[string]models.Money
+	asOf   time.Time
+}
+
+func NewStaticProvider(prices map[string]models.Money) *StaticProvider {
+	p := &StaticProvider{prices: make(map[string]models.Money, len(prices)), asOf: time.Now()}
+	for symbol, price := range prices {
+		p.prices[normalizeSymbol(symbol)] = price
+	}
+	return p
+}
+
+func (p *StaticProvider) Quote(symbol string) (Quote, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	price, ok := p.prices[normalizeSymbol(symbol)]
+	if !ok {
+		return Quote{}, ErrUnknownSymbol
+	}
+	return Quote{Symbol: normalizeSymbol(symbol), Price: price, AsOf: p.asOf}, nil
+}
+
+func (p *StaticProvider) Set(symbol string, price models.Money) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prices[normalizeSymbol(symbol)] = price
+	p.asOf = time.Now()
+}
@@ -0,0 +1,317 @@
+package reconcile
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Kinds of discrepancy a reconciliation can find.
+const (
+	// BalanceMismatch means the stored balance differs from the sum of the
+	// ledger.
+	BalanceMismatch = "balance_mismatch"
+	// MissingLot means a buy in the ledger has no lot.
+	MissingLot = "missing_lot"
+	// BuyMismatch means the quantity or cost of a lot differs from its buy.
+	BuyMismatch = "buy_mismatch"
+	// SellMismatch means the lots of a sale do not add up to its ledger entry.
+	SellMismatch = "sell_mismatch"
+	// UnrecordedSale means lots were sold without crediting the proceeds.
+	UnrecordedSale = "unrecorded_sale"
+	// HeldMismatch means the held balance differs from the pending holds.
+	HeldMismatch = "held_mismatch"
+)
+
+// Discrepancy is one way in which an account disagrees with its history.
+type Discrepancy struct {
+	Kind        string        `json:"kind"`
+	ReferenceID string        `json:"referenceID,omitempty"`
+	Expected    *models.Money `json:"expected,omitempty"`
+	Actual      *models.Money `json:"actual,omitempty"`
+	Detail      string        `json:"detail"`
+}
+
+// Account is the outcome of reconciling one user.
+type Account struct {
+	Email           string        `json:"email"`
+	StoredBalance   models.Money  `json:"storedBalance"`
+	ExpectedBalance models.Money  `json:"expectedBalance"`
+	LedgerEntries   int           `json:"ledgerEntries"`
+	Discrepancies   []Discrepancy `json:"discrepancies"`
+	Frozen          bool          `json:"frozen"`
+}
+
+// OK reports whether the account reconciled cleanly.
+func (a Account) OK() bool {
+	return len(a.Discrepancies) == 0
+}
+
+// AccountError is an account that could not be reconciled at all.
+type AccountError struct {
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
+
+// Report is the outcome of reconciling every user. Accounts only lists the
+// ones that failed.
+type Report struct {
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	Checked    int            `json:"checked"`
+	Failed     int            `json:"failed"`
+	Frozen     int            `json:"frozen"`
+	Accounts   []Account      `json:"accounts"`
+	Errors     []AccountError `json:"errors,omitempty"`
+}
+
+// OK reports whether every account was reconciled and none failed.
+func (r Report) OK() bool {
+	return r.Failed == 0 && len(r.Errors) == 0
+}
+
+// Reconciler recomputes balances and holdings from the ledger and compares
+// them with what is stored.
+type Reconciler struct {
+	store  db.Store
+	freeze bool
+	// clock dates the start and end of every run.
+	clock models.Clock
+}
+
+// NewReconciler returns a Reconciler. With freeze set, accounts that fail are
+// frozen.
+func NewReconciler(store db.Store, freeze bool) *Reconciler {
+	return &Reconciler{store: store, freeze: freeze, clock: models.SystemClock{}}
+}
+
+// SetClock replaces the clock, which is the system clock by default.
+func (r *Reconciler) SetClock(clock models.Clock) {
+	r.clock = clock
+}
+
+// Run reconciles every user.
+func (r *Reconciler) Run() (Report, error) {
+	report := Report{StartedAt: r.clock.Now(), Accounts: []Account{}}
+	emails, err := r.store.ListUserEmails()
+	if err != nil {
+		return report, err
+	}
+
+	for _, email := range emails {
+		account, err := r.ReconcileAccount(email)
+		stable := true
+		if err == nil && !account.OK() {
+			// On a deployment without transactions the snapshot is not
+			// one, and a trade that lands while it is read looks like a
+			// discrepancy, so check again before reporting it. Only a
+			// discrepancy both reads agree on freezes the account.
+			first := account
+			account, err = r.ReconcileAccount(email)
+			stable = sameOutcome(first, account)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, AccountError{Email: email, Error: err.Error()})
+			continue
+		}
+		report.Checked++
+		if account.OK() {
+			continue
+		}
+
+		report.Failed++
+		if r.freeze && !stable {
+			logger.Error("Account " + email + " changed while it was reconciled, not freezing it")
+		} else if r.freeze {
+			freeze := models.StatusChange{To: models.AccountStatusFrozen, Actor: "reconciliation", Reason: "Balance does not match the ledger."}
+			_, err = r.store.ChangeAccountStatus(email, freeze)
+			if err != nil {
+				logger.Error("Unable to freeze account " + email + ": " + err.Error())
+			} else {
+				account.Frozen = true
+				report.Frozen++
+			}
+		}
+		report.Accounts = append(report.Accounts, account)
+	}
+	report.FinishedAt = r.clock.Now()
+	return report, nil
+}
+
+// ReconcileAccount checks one user: their balance against the sum of their
+// ledger, their held balance against their pending holds, every buy against
+// the lot it created, and every sale against the lots it consumed. All of
+// them are read from one snapshot of the account.
+func (r *Reconciler) ReconcileAccount(email string) (Account, error) {
+	snapshot, err := r.store.GetAccountSnapshot(email)
+	if err != nil {
+		return Account{}, err
+	}
+	user, entries, holds := snapshot.User, snapshot.Ledger, snapshot.Holds
+
+	account := Account{
+		Email:           email,
+		StoredBalance:   user.Balance,
+		ExpectedBalance: models.NewMoney(0, user.Balance.Currency),
+		LedgerEntries:   len(entries),
+		Discrepancies:   []Discrepancy{},
+	}
+	reversed := make(map[string]bool)
+	for _, entry := range entries {
+		account.ExpectedBalance.Amount += entry.Amount.Amount
+		if entry.Type == models.LedgerReversal {
+			reversed[entry.ReferenceID] = true
+		}
+	}
+	if !equal(account.StoredBalance, account.ExpectedBalance) {
+		account.add(BalanceMismatch, "", account.ExpectedBalance, account.StoredBalance, "Stored balance differs from the ledger")
+	}
+
+	held := models.NewMoney(0, user.Balance.Currency)
+	for _, hold := range holds {
+		if held, err = held.Add(hold.Amount); err != nil {
+			return Account{}, err
+		}
+	}
+	if held.Amount != user.HeldBalance.Amount {
+		actual := models.NewMoney(user.HeldBalance.Amount, user.Balance.Currency)
+		account.add(HeldMismatch, "", held, actual, "Held balance differs from the pending holds")
+	}
+
+	lots := make(map[string]models.Share)
+	splits := make(map[string][]models.Share)
+	sales := make(map[string][]models.Share)
+	for _, lot := range user.Shares {
+		lots[lot.ShareID] = lot
+		if lot.ParentShareID != "" {
+			splits[lot.ParentShareID] = append(splits[lot.ParentShareID], lot)
+		}
+		if lot.SaleID != "" {
+			sales[lot.SaleID] = append(sales[lot.SaleID], lot)
+		}
+	}
+
+	recordedSales := make(map[string]bool)
+	for _, entry := range entries {
+		if reversed[entry.ID.Hex()] {
+			continue
+		}
+		switch entry.Type {
+		case models.LedgerBuy:
+			account.checkBuy(entry, lots, splits)
+		case models.LedgerSell:
+			recordedSales[entry.ReferenceID] = true
+			account.checkSale(entry, sales[entry.ReferenceID])
+		}
+	}
+	for saleID, sold := range sales {
+		if !recordedSales[saleID] {
+			account.add(UnrecordedSale, saleID, models.Money{}, models.Money{}, strconv.Itoa(len(sold))+" lots were sold without a ledger entry")
+		}
+	}
+	return account, nil
+}
+
+// RunEvery reconciles every account each interval and logs the report when
+// something failed. It never returns.
+func (r *Reconciler) RunEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report, err := r.Run()
+		if err != nil {
+			logger.Error("Unable to reconcile accounts: " + err.Error())
+			continue
+		}
+		if report.OK() {
+			logger.Info("Reconciled " + strconv.Itoa(report.Checked) + " accounts")
+			continue
+		}
+		encoded, _ := json.Marshal(report)
+		logger.Error("Reconciliation found discrepancies: " + string(encoded))
+	}
+}
+
+func (a *Account) checkBuy(entry models.LedgerEntry, lots map[string]models.Share, splits map[string][]models.Share) {
+	lot, ok := lots[entry.ReferenceID]
+	if !ok {
+		a.add(MissingLot, entry.ReferenceID, entry.Amount.Neg(), models.Money{}, "Buy has no lot")
+		return
+	}
+	// Partial sells split the sold shares, and their part of the buy fee,
+	// off into lots of their own. Stock splits change the quantity but not
+	// the cost, so quantities are compared in the shares that were bought.
+	quantity := lot.UnsplitQuantity()
+	cost, err := lot.CostBasis()
+	for _, split := range splits[lot.ShareID] {
+		quantity.Add(quantity, split.UnsplitQuantity())
+		if err == nil {
+			cost, err = addCost(cost, split)
+		}
+	}
+	if err != nil {
+		a.add(BuyMismatch, entry.ReferenceID, entry.Amount.Neg(), models.Money{}, "Lot cost is too large to add up")
+		return
+	}
+	if quantity.Cmp(big.NewRat(int64(entry.Quantity), 1)) != 0 || !equal(cost, entry.Amount.Neg()) {
+		detail := "Lot holds " + quantity.RatString() + " shares as bought, the buy was for " + strconv.Itoa(entry.Quantity)
+		a.add(BuyMismatch, entry.ReferenceID, entry.Amount.Neg(), cost, detail)
+	}
+}
+
+func (a *Account) checkSale(entry models.LedgerEntry, sold []models.Share) {
+	proceeds := models.NewMoney(0, entry.Amount.Currency)
+	quantity := 0
+	for _, lot := range sold {
+		lotProceeds, err := lot.Proceeds()
+		if err == nil {
+			proceeds, err = proceeds.Add(lotProceeds)
+		}
+		if err != nil {
+			a.add(SellMismatch, entry.ReferenceID, entry.Amount, models.Money{}, "Sale proceeds are too large to add up")
+			return
+		}
+		quantity += lot.Quantity
+	}
+	if quantity != entry.Quantity || !equal(proceeds, entry.Amount) {
+		detail := "Sold lots hold " + strconv.Itoa(quantity) + " shares, the sale was for " + strconv.Itoa(entry.Quantity)
+		a.add(SellMismatch, entry.ReferenceID, entry.Amount, proceeds, detail)
+	}
+}
+
+// addCost adds the cost basis of a split-off lot to cost.
+func addCost(cost models.Money, split models.Share) (models.Money, error) {
+	splitCost, err := split.CostBasis()
+	if err != nil {
+		return models.Money{}, err
+	}
+	return cost.Add(splitCost)
+}
+
+func (a *Account) add(kind string, referenceID string, expected models.Money, actual models.Money, detail string) {
+	discrepancy := Discrepancy{Kind: kind, ReferenceID: referenceID, Detail: detail}
+	if expected.Currency != "" {
+		discrepancy.Expected = &expected
+	}
+	if actual.Currency != "" {
+		discrepancy.Actual = &actual
+	}
+	a.Discrepancies = append(a.Discrepancies, discrepancy)
+}
+
+// sameOutcome reports whether two reconciliations of an account read the
+// same balance and ledger and found the same discrepancies.
+func sameOutcome(a Account, b Account) bool {
+	return equal(a.StoredBalance, b.StoredBalance) && a.LedgerEntries == b.LedgerEntries &&
+		reflect.DeepEqual(a.Discrepancies, b.Discrepancies)
+}
+
+func equal(a models.Money, b models.Money) bool {
+	return a.SameCurrency(b) && a.Amount == b.Amount
+}
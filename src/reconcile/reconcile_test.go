@@ -0,0 +1,205 @@
+package reconcile
+
+import (
+	"dbutil/src/config"
+	db "dbutil/src/database"
+	"dbutil/src/models"
+	"dbutil/src/testutil"
+	"math"
+	"testing"
+	"time"
+)
+
+// tamperedStore changes the snapshots a memory store reads, the way a broken
+// write or a concurrent trade would. tamper gets the number of the read,
+// starting at 1.
+type tamperedStore struct {
+	db.Store
+	reads  int
+	tamper func(read int, snapshot *models.AccountSnapshot)
+}
+
+func (s *tamperedStore) GetAccountSnapshot(email string) (models.AccountSnapshot, error) {
+	snapshot, err := s.Store.GetAccountSnapshot(email)
+	if err != nil {
+		return snapshot, err
+	}
+	s.reads++
+	if s.tamper != nil {
+		s.tamper(s.reads, &snapshot)
+	}
+	return snapshot, nil
+}
+
+// newTamperedStore returns a store in which the test user deposited, bought,
+// sold, was paid a dividend and has a pending withdrawal and buy order.
+func newTamperedStore(t *testing.T) *tamperedStore {
+	t.Helper()
+	w := testutil.NewWorld(t, config.Configuration{Fees: testutil.Fees})
+	w.Register(t, testutil.Email, "2000.00")
+	w.Buy(t, testutil.Email, "AAPL", 5)
+	w.Sell(t, testutil.Email, "AAPL", 2)
+	w.Clock.Advance(48 * time.Hour)
+
+	amount := testutil.USD(t, "0.25")
+	recordDate := testutil.Start
+	_, err := w.Store.ApplyCorporateAction(models.CorporateAction{
+		ID: "aapl-dividend", Type: models.CorporateDividend, Symbol: "AAPL", AmountPerShare: &amount, RecordDate: &recordDate,
+	})
+	if err != nil {
+		t.Fatalf("ApplyCorporateAction: %v", err)
+	}
+	_, err = w.Store.PlaceHold(testutil.Email, models.Hold{Type: models.HoldWithdrawal, Amount: testutil.USD(t, "100.00")})
+	if err != nil {
+		t.Fatalf("PlaceHold: %v", err)
+	}
+	limit := testutil.USD(t, "140.00")
+	_, err = w.Store.PlaceOrder(testutil.Email, models.Order{Side: models.OrderBuy, Type: models.OrderLimit, Symbol: "AAPL", Quantity: 1, LimitPrice: &limit})
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	return &tamperedStore{Store: w.Store}
+}
+
+func status(t *testing.T, store db.Store) models.AccountStatus {
+	t.Helper()
+	user, err := store.GetUserData(testutil.Email)
+	if err != nil {
+		t.Fatalf("GetUserData: %v", err)
+	}
+	return user.AccountStatus
+}
+
+// reconcile checks the test user once, without freezing.
+func reconcile(t *testing.T, store db.Store) Account {
+	t.Helper()
+	account, err := NewReconciler(store, false).ReconcileAccount(testutil.Email)
+	if err != nil {
+		t.Fatalf("ReconcileAccount: %v", err)
+	}
+	return account
+}
+
+func TestHistoryReconciles(t *testing.T) {
+	store := newTamperedStore(t)
+
+	report, err := NewReconciler(store, true).Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !report.OK() || report.Checked != 1 {
+		t.Fatalf("report is %+v, want one clean account", report)
+	}
+	if status(t, store) == models.AccountStatusFrozen {
+		t.Fatal("a clean account was frozen")
+	}
+}
+
+func TestStableMismatchFreezesAccount(t *testing.T) {
+	store := newTamperedStore(t)
+	store.tamper = func(read int, snapshot *models.AccountSnapshot) {
+		snapshot.User.Balance.Amount += 100
+	}
+
+	report, err := NewReconciler(store, true).Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Failed != 1 || report.Frozen != 1 || len(report.Accounts) != 1 {
+		t.Fatalf("report is %+v, want one frozen account", report)
+	}
+	discrepancies := report.Accounts[0].Discrepancies
+	if len(discrepancies) != 1 || discrepancies[0].Kind != BalanceMismatch {
+		t.Fatalf("discrepancies are %+v, want a balance mismatch", discrepancies)
+	}
+	if status(t, store) != models.AccountStatusFrozen {
+		t.Fatal("account was not frozen")
+	}
+}
+
+func TestMismatchSeenOnceIsNotReported(t *testing.T) {
+	store := newTamperedStore(t)
+	store.tamper = func(read int, snapshot *models.AccountSnapshot) {
+		if read == 1 {
+			snapshot.Holds = nil
+		}
+	}
+
+	report, err := NewReconciler(store, true).Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !report.OK() || store.reads != 2 {
+		t.Fatalf("report is %+v after %d reads, want a clean account after 2", report, store.reads)
+	}
+	if status(t, store) == models.AccountStatusFrozen {
+		t.Fatal("account was frozen")
+	}
+}
+
+func TestChangingMismatchDoesNotFreeze(t *testing.T) {
+	store := newTamperedStore(t)
+	store.tamper = func(read int, snapshot *models.AccountSnapshot) {
+		snapshot.User.Balance.Amount += int64(read) * 100
+	}
+
+	report, err := NewReconciler(store, true).Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Failed != 1 || report.Frozen != 0 {
+		t.Fatalf("report is %+v, want one failed account that is not frozen", report)
+	}
+	if status(t, store) == models.AccountStatusFrozen {
+		t.Fatal("account was frozen")
+	}
+}
+
+func TestHeldBalanceMustMatchPendingHolds(t *testing.T) {
+	store := newTamperedStore(t)
+	store.tamper = func(read int, snapshot *models.AccountSnapshot) {
+		snapshot.Holds = snapshot.Holds[:1]
+	}
+
+	account := reconcile(t, store)
+	if len(account.Discrepancies) != 1 || account.Discrepancies[0].Kind != HeldMismatch {
+		t.Fatalf("discrepancies are %+v, want a held balance mismatch", account.Discrepancies)
+	}
+}
+
+func TestLotsMustMatchTheirTrades(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		tamper func(lot *models.Share)
+	}{
+		{"bought quantity", BuyMismatch, func(lot *models.Share) {
+			if lot.SoldIndicator == "N" {
+				lot.Quantity++
+			}
+		}},
+		{"sale proceeds", SellMismatch, func(lot *models.Share) {
+			if lot.SoldIndicator == "Y" {
+				lot.SellFee.Amount++
+			}
+		}},
+		{"cost too large to add up", BuyMismatch, func(lot *models.Share) {
+			if lot.SoldIndicator == "N" {
+				lot.PriceBaught.Amount = math.MaxInt64
+			}
+		}},
+	}
+	for _, test := range tests {
+		store := newTamperedStore(t)
+		store.tamper = func(read int, snapshot *models.AccountSnapshot) {
+			for i := range snapshot.User.Shares {
+				test.tamper(&snapshot.User.Shares[i])
+			}
+		}
+
+		account := reconcile(t, store)
+		if len(account.Discrepancies) != 1 || account.Discrepancies[0].Kind != test.kind {
+			t.Errorf("%s: discrepancies are %+v, want one %s", test.name, account.Discrepancies, test.kind)
+		}
+	}
+}
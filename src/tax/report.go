@@ -0,0 +1,282 @@
+package tax
+
+import (
+	db "dbutil/src/database"
+	logger "dbutil/src/logging"
+	"dbutil/src/models"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Terms of a gain. A gain is long-term when the shares were held for more
+// than a year.
+const (
+	ShortTerm = "short"
+	LongTerm  = "long"
+)
+
+// WashSaleWindow is how far before or after a sale at a loss a purchase of the
+// same symbol makes it a wash sale.
+const WashSaleWindow = 30 * 24 * time.Hour
+
+// dateLayout is how the CSV report writes dates.
+const dateLayout = "2006-01-02"
+
+// Disposal is one sold lot. WashSale is set on losses with a purchase of the
+// same symbol within WashSaleWindow of the sale, other than the lot itself,
+// that the sale did not dispose of entirely.
+type Disposal struct {
+	ShareID   string       `json:"shareID"`
+	SaleID    string       `json:"saleID"`
+	Symbol    string       `json:"symbol"`
+	Quantity  int          `json:"quantity"`
+	Acquired  time.Time    `json:"acquired"`
+	Sold      time.Time    `json:"sold"`
+	Proceeds  models.Money `json:"proceeds"`
+	CostBasis models.Money `json:"costBasis"`
+	Gain      models.Money `json:"gain"`
+	Term      string       `json:"term"`
+	WashSale  bool         `json:"washSale"`
+}
+
+// Totals adds up the disposals in one currency.
+type Totals struct {
+	Currency      string       `json:"currency"`
+	Proceeds      models.Money `json:"proceeds"`
+	CostBasis     models.Money `json:"costBasis"`
+	ShortTermGain models.Money `json:"shortTermGain"`
+	LongTermGain  models.Money `json:"longTermGain"`
+	WashSales     int          `json:"washSales"`
+}
+
+// Report is what a user disposed of in one calendar year, UTC.
+type Report struct {
+	Email     string     `json:"email"`
+	Year      int        `json:"year"`
+	Disposals []Disposal `json:"disposals"`
+	Totals    []Totals   `json:"totals"`
+}
+
+// Reporter builds tax reports from the lots of a user.
+type Reporter struct {
+	store db.ShareStore
+	// clock decides which year is the current one.
+	clock models.Clock
+}
+
+func NewReporter(store db.ShareStore) *Reporter {
+	return &Reporter{store: store, clock: models.SystemClock{}}
+}
+
+// SetClock replaces the clock, which is the system clock by default.
+func (r *Reporter) SetClock(clock models.Clock) {
+	r.clock = clock
+}
+
+// CurrentYear is the calendar year it is now, UTC.
+func (r *Reporter) CurrentYear() int {
+	return r.clock.Now().UTC().Year()
+}
+
+// purchase is when a lot was bought. The parts a partial sell splits off a lot
+// share its purchase. held says whether part of it is still held, and saleIDs
+// lists the sales of the parts that are not.
+type purchase struct {
+	shareID string
+	symbol  string
+	bought  time.Time
+	held    bool
+	saleIDs []string
+}
+
+// keptAfter reports whether some shares of p were not disposed of in the sale
+// saleID. Lots sold before sales had ids can not be told apart, so they are
+// assumed to be kept.
+func (p purchase) keptAfter(saleID string) bool {
+	if p.held || saleID == "" {
+		return true
+	}
+	for _, partSale := range p.saleIDs {
+		if partSale != saleID {
+			return true
+		}
+	}
+	return false
+}
+
+// Report lists the lots of a user sold in year, oldest sale first. Proceeds
+// are net of the sell fee and cost bases include the buy fee, like the
+// portfolio. Sold lots without a sale date are logged and left out.
+func (r *Reporter) Report(email string, year int) (Report, error) {
+	lots, err := r.store.GetLots(email)
+	if err != nil {
+		return Report{}, err
+	}
+
+	purchases := []purchase{}
+	index := make(map[string]int)
+	for _, lot := range lots {
+		if lot.ParentShareID != "" {
+			continue
+		}
+		index[lot.ShareID] = len(purchases)
+		purchases = append(purchases, purchase{shareID: lot.ShareID, symbol: lot.Symbol, bought: lot.DateBaught})
+	}
+	for _, lot := range lots {
+		origin := lot.ShareID
+		if lot.ParentShareID != "" {
+			origin = lot.ParentShareID
+		}
+		i, ok := index[origin]
+		if !ok {
+			continue
+		}
+		if lot.SoldIndicator == "Y" {
+			purchases[i].saleIDs = append(purchases[i].saleIDs, lot.SaleID)
+		} else {
+			purchases[i].held = true
+		}
+	}
+
+	report := Report{Email: email, Year: year, Disposals: []Disposal{}, Totals: []Totals{}}
+	for _, lot := range lots {
+		if lot.SoldIndicator != "Y" {
+			continue
+		}
+		if lot.DateSold == nil {
+			logger.Error("Lot " + lot.ShareID + " is sold but has no sale date")
+			continue
+		}
+		disposal, err := disposalOf(lot)
+		if err != nil {
+			return Report{}, err
+		}
+		if disposal.Sold.UTC().Year() != year {
+			continue
+		}
+		disposal.WashSale = disposal.Gain.IsNegative() && repurchased(purchases, lot, disposal.Sold)
+		report.Disposals = append(report.Disposals, disposal)
+	}
+	sort.SliceStable(report.Disposals, func(i, j int) bool {
+		a, b := report.Disposals[i], report.Disposals[j]
+		if !a.Sold.Equal(b.Sold) {
+			return a.Sold.Before(b.Sold)
+		}
+		return a.Symbol < b.Symbol
+	})
+	if report.Totals, err = totalsOf(report.Disposals); err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+// disposalOf reports a sold lot. The caller checks that it has a sale date.
+func disposalOf(lot models.Share) (Disposal, error) {
+	acquired := lot.DateBaught
+	sold := *lot.DateSold
+	proceeds, err := lot.Proceeds()
+	if err != nil {
+		return Disposal{}, err
+	}
+	cost, err := lot.CostBasis()
+	if err != nil {
+		return Disposal{}, err
+	}
+	disposal := Disposal{
+		ShareID:   lot.ShareID,
+		SaleID:    lot.SaleID,
+		Symbol:    lot.Symbol,
+		Quantity:  lot.Quantity,
+		Acquired:  acquired,
+		Sold:      sold,
+		Proceeds:  proceeds,
+		CostBasis: cost,
+		Term:      ShortTerm,
+	}
+	disposal.Gain = disposal.Proceeds.Sub(disposal.CostBasis)
+	if sold.After(acquired.AddDate(1, 0, 0)) {
+		disposal.Term = LongTerm
+	}
+	return disposal, nil
+}
+
+// repurchased reports whether the symbol of a sold lot was bought within
+// WashSaleWindow of its sale, other than by the purchase the lot came from.
+// Purchases disposed of entirely in the same sale left no replacement shares
+// and do not count.
+func repurchased(purchases []purchase, lot models.Share, sold time.Time) bool {
+	origin := lot.ShareID
+	if lot.ParentShareID != "" {
+		origin = lot.ParentShareID
+	}
+	for _, p := range purchases {
+		if p.symbol != lot.Symbol || p.shareID == origin || !p.keptAfter(lot.SaleID) {
+			continue
+		}
+		gap := p.bought.Sub(sold)
+		if gap >= -WashSaleWindow && gap <= WashSaleWindow {
+			return true
+		}
+	}
+	return false
+}
+
+func totalsOf(disposals []Disposal) ([]Totals, error) {
+	totals := []Totals{}
+	index := make(map[string]int)
+	for _, disposal := range disposals {
+		currency := models.NewMoney(0, disposal.Proceeds.Currency).Currency
+		i, ok := index[currency]
+		if !ok {
+			zero := models.NewMoney(0, currency)
+			totals = append(totals, Totals{Currency: currency, Proceeds: zero, CostBasis: zero, ShortTermGain: zero, LongTermGain: zero})
+			i = len(totals) - 1
+			index[currency] = i
+		}
+		t := &totals[i]
+		var err error
+		if t.Proceeds, err = t.Proceeds.Add(disposal.Proceeds); err != nil {
+			return nil, err
+		}
+		if t.CostBasis, err = t.CostBasis.Add(disposal.CostBasis); err != nil {
+			return nil, err
+		}
+		gain := &t.ShortTermGain
+		if disposal.Term == LongTerm {
+			gain = &t.LongTermGain
+		}
+		if *gain, err = gain.Add(disposal.Gain); err != nil {
+			return nil, err
+		}
+		if disposal.WashSale {
+			t.WashSales++
+		}
+	}
+	return totals, nil
+}
+
+// WriteCSV writes the disposals of the report, one per row.
+func (r Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"symbol", "quantity", "acquired", "sold", "proceeds", "costBasis", "gain", "term", "washSale", "currency", "shareID"})
+	for _, disposal := range r.Disposals {
+		_ = writer.Write([]string{
+			disposal.Symbol,
+			strconv.Itoa(disposal.Quantity),
+			disposal.Acquired.UTC().Format(dateLayout),
+			disposal.Sold.UTC().Format(dateLayout),
+			disposal.Proceeds.String(),
+			disposal.CostBasis.String(),
+			disposal.Gain.String(),
+			disposal.Term,
+			strconv.FormatBool(disposal.WashSale),
+			models.NewMoney(0, disposal.Proceeds.Currency).Currency,
+			disposal.ShareID,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,177 @@
+package tax
+
+import (
+	"bytes"
+	"dbutil/src/config"
+	"dbutil/src/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testYear = testutil.Start.Year()
+
+// newTaxWorld returns a world without fees in which the test user has
+// 10000.00.
+func newTaxWorld(t *testing.T) *testutil.World {
+	t.Helper()
+	w := testutil.NewWorld(t, config.Configuration{})
+	w.Register(t, testutil.Email, "10000.00")
+	return w
+}
+
+func report(t *testing.T, w *testutil.World) Report {
+	t.Helper()
+	report, err := NewReporter(w.Store).Report(testutil.Email, testYear)
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	return report
+}
+
+func washSales(report Report) []bool {
+	flags := []bool{}
+	for _, disposal := range report.Disposals {
+		flags = append(flags, disposal.WashSale)
+	}
+	return flags
+}
+
+func TestLossWithRepurchaseIsWashSale(t *testing.T) {
+	w := newTaxWorld(t)
+	w.Buy(t, testutil.Email, "AAPL", 10)
+	w.Clock.Advance(5 * 24 * time.Hour)
+	w.SetPrice(t, "AAPL", "140.00")
+	w.Sell(t, testutil.Email, "AAPL", 10)
+	w.Clock.Advance(10 * 24 * time.Hour)
+	w.Buy(t, testutil.Email, "AAPL", 5)
+
+	report := report(t, w)
+	if len(report.Disposals) != 1 || !report.Disposals[0].WashSale {
+		t.Fatalf("disposals are %+v, want one wash sale", report.Disposals)
+	}
+	if report.Disposals[0].Gain != testutil.USD(t, "-100.00") {
+		t.Fatalf("loss is %s, want -100.00", report.Disposals[0].Gain)
+	}
+	if len(report.Totals) != 1 || report.Totals[0].WashSales != 1 {
+		t.Fatalf("totals are %+v, want one wash sale", report.Totals)
+	}
+}
+
+func TestRepurchaseOutsideWindowIsNotWashSale(t *testing.T) {
+	w := newTaxWorld(t)
+	w.Buy(t, testutil.Email, "AAPL", 10)
+	w.SetPrice(t, "AAPL", "140.00")
+	w.Sell(t, testutil.Email, "AAPL", 10)
+	w.Clock.Advance(WashSaleWindow + time.Hour)
+	w.Buy(t, testutil.Email, "AAPL", 5)
+
+	if flags := washSales(report(t, w)); len(flags) != 1 || flags[0] {
+		t.Fatalf("wash sale flags are %v, want one sale that is not", flags)
+	}
+}
+
+func TestPurchasesSoldInSameSaleAreNotReplacements(t *testing.T) {
+	w := newTaxWorld(t)
+	w.Buy(t, testutil.Email, "AAPL", 10)
+	w.Clock.Advance(24 * time.Hour)
+	w.Buy(t, testutil.Email, "AAPL", 10)
+	w.Clock.Advance(24 * time.Hour)
+	w.SetPrice(t, "AAPL", "140.00")
+	w.Sell(t, testutil.Email, "AAPL", 20)
+
+	if flags := washSales(report(t, w)); len(flags) != 2 || flags[0] || flags[1] {
+		t.Fatalf("wash sale flags are %v, want two sales that are not", flags)
+	}
+}
+
+func TestKeptPartOfPurchaseIsReplacement(t *testing.T) {
+	w := newTaxWorld(t)
+	w.Buy(t, testutil.Email, "AAPL", 10)
+	w.Clock.Advance(24 * time.Hour)
+	w.Buy(t, testutil.Email, "AAPL", 10)
+	w.Clock.Advance(24 * time.Hour)
+	w.SetPrice(t, "AAPL", "140.00")
+	// All of the first purchase and half of the second, which keeps 5.
+	w.Sell(t, testutil.Email, "AAPL", 15)
+
+	report := report(t, w)
+	if len(report.Disposals) != 2 {
+		t.Fatalf("disposals are %+v, want two", report.Disposals)
+	}
+	for _, disposal := range report.Disposals {
+		// Only the first purchase has replacement shares: the rest of the
+		// second. The second is not its own replacement.
+		want := disposal.Quantity == 10
+		if disposal.WashSale != want {
+			t.Errorf("disposal of %d shares is a wash sale: %v, want %v", disposal.Quantity, disposal.WashSale, want)
+		}
+	}
+}
+
+func TestGainIsNeverWashSaleAndTermFollowsHoldingPeriod(t *testing.T) {
+	w := newTaxWorld(t)
+	w.Clock.Set(time.Date(testYear-1, time.January, 5, 12, 0, 0, 0, time.UTC))
+	w.Buy(t, testutil.Email, "AAPL", 4)
+	w.Clock.Set(testutil.Start)
+	w.Buy(t, testutil.Email, "AAPL", 4)
+	w.SetPrice(t, "AAPL", "160.00")
+	w.Clock.Advance(24 * time.Hour)
+	w.Sell(t, testutil.Email, "AAPL", 8)
+
+	report := report(t, w)
+	if len(report.Disposals) != 2 {
+		t.Fatalf("disposals are %+v, want two", report.Disposals)
+	}
+	terms := []string{report.Disposals[0].Term, report.Disposals[1].Term}
+	if terms[0] == terms[1] {
+		t.Fatalf("terms are %v, want one long and one short", terms)
+	}
+	for _, disposal := range report.Disposals {
+		if disposal.WashSale || !disposal.Gain.IsPositive() {
+			t.Errorf("disposal %+v, want a gain that is not a wash sale", disposal)
+		}
+	}
+	totals := report.Totals
+	if len(totals) != 1 || totals[0].LongTermGain != testutil.USD(t, "40.00") || totals[0].ShortTermGain != testutil.USD(t, "40.00") {
+		t.Fatalf("totals are %+v, want 40.00 long and 40.00 short", totals)
+	}
+}
+
+func TestSalesOfOtherYearsAreLeftOut(t *testing.T) {
+	w := newTaxWorld(t)
+	w.Buy(t, testutil.Email, "AAPL", 2)
+	w.Sell(t, testutil.Email, "AAPL", 1)
+	w.Clock.Set(time.Date(testYear+1, time.January, 1, 0, 0, 0, 0, time.UTC))
+	w.Sell(t, testutil.Email, "AAPL", 1)
+
+	if disposals := report(t, w).Disposals; len(disposals) != 1 || disposals[0].Sold.Year() != testYear {
+		t.Fatalf("disposals are %+v, want the sale of %d", disposals, testYear)
+	}
+	reporter := NewReporter(w.Store)
+	reporter.SetClock(w.Clock)
+	if year := reporter.CurrentYear(); year != testYear+1 {
+		t.Fatalf("current year is %d, want the year of the clock %d", year, testYear+1)
+	}
+}
+
+func TestReportWritesCSV(t *testing.T) {
+	w := newTaxWorld(t)
+	w.Buy(t, testutil.Email, "AAPL", 2)
+	w.SetPrice(t, "AAPL", "155.00")
+	w.Sell(t, testutil.Email, "AAPL", 2)
+
+	var out bytes.Buffer
+	err := report(t, w).WriteCSV(&out)
+	if err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(rows) != 2 || !strings.HasPrefix(rows[0], "symbol,quantity,") {
+		t.Fatalf("csv is %q, want a header and one disposal", out.String())
+	}
+	day := testutil.Start.Format(dateLayout)
+	if !strings.HasPrefix(rows[1], "AAPL,2,"+day+","+day+",310.00,300.00,10.00,") {
+		t.Fatalf("disposal row is %q", rows[1])
+	}
+}
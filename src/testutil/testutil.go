@@ -0,0 +1,152 @@
+// Package testutil holds the fixtures that the tests of the other packages
+// share: a memory store over static quotes with a stopped clock, and the
+// helpers to put a user and their trades in it.
+package testutil
+
+import (
+	"dbutil/src/config"
+	db "dbutil/src/database"
+	"dbutil/src/models"
+	"dbutil/src/quotes"
+	"testing"
+	"time"
+)
+
+// Email is the user the tests trade as.
+const Email = "trader@example.com"
+
+// Start is where the clock of a World starts. It is a Tuesday in the middle
+// of a month, so a few days either way stay in the same month.
+var Start = time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+// Fees charges 0.10%, at least 1.00, and 0.05% once a user traded 1000.00 in
+// the month.
+var Fees = config.FeesConfig{
+	PercentBps: 10,
+	Minimum:    "1.00",
+	Tiers:      []config.FeeTierConfig{{MonthlyVolume: "1000.00", PercentBps: 5}},
+}
+
+// USD parses a decimal amount of dollars such as "150.00".
+func USD(t testing.TB, value string) models.Money {
+	t.Helper()
+	amount, err := models.ParseMoney(value, "USD")
+	if err != nil {
+		t.Fatalf("ParseMoney(%q): %v", value, err)
+	}
+	return amount
+}
+
+// World is a memory store together with the quotes and the clock it runs
+// on, which tests move to make things happen.
+type World struct {
+	Store  *db.MemoryStore
+	Quotes *quotes.StaticProvider
+	Clock  *models.ManualClock
+}
+
+// NewWorld returns a World configured by appConfig in which AAPL trades at
+// 150.00 and MSFT at 300.00, with the clock stopped at Start.
+func NewWorld(t testing.TB, appConfig config.Configuration) *World {
+	t.Helper()
+	provider := quotes.NewStaticProvider(map[string]models.Money{
+		"AAPL": USD(t, "150.00"),
+		"MSFT": USD(t, "300.00"),
+	})
+	store := db.NewMemoryStore(appConfig, provider)
+	clock := models.NewManualClock(Start)
+	store.SetClock(clock)
+	return &World{Store: store, Quotes: provider, Clock: clock}
+}
+
+// SetPrice changes the quote of symbol.
+func (w *World) SetPrice(t testing.TB, symbol string, price string) {
+	t.Helper()
+	w.Quotes.Set(symbol, USD(t, price))
+}
+
+// Register signs email up and deposits balance, unless it is zero.
+func (w *World) Register(t testing.TB, email string, balance string) {
+	t.Helper()
+	_, err := w.Store.SaveNewUser(models.User{Email: email, Balance: models.NewMoney(0, "USD")})
+	if err != nil {
+		t.Fatalf("SaveNewUser: %v", err)
+	}
+	deposit := USD(t, balance)
+	if deposit.IsZero() {
+		return
+	}
+	_, err = w.Store.UpdateBalance(email, models.BalanceChange{Type: models.LedgerDeposit, Amount: deposit, Credit: true})
+	if err != nil {
+		t.Fatalf("UpdateBalance: %v", err)
+	}
+}
+
+// Buy buys quantity shares of symbol for email at the quoted price.
+func (w *World) Buy(t testing.TB, email string, symbol string, quantity int) {
+	t.Helper()
+	_, err := w.Store.SaveBaughtShare(email, models.Share{Symbol: symbol, Quantity: quantity, PriceBaught: w.price(t, symbol)})
+	if err != nil {
+		t.Fatalf("SaveBaughtShare: %v", err)
+	}
+}
+
+// Sell sells quantity shares of symbol for email at the quoted price, from
+// the oldest lots first.
+func (w *World) Sell(t testing.TB, email string, symbol string, quantity int) models.SellResult {
+	t.Helper()
+	order := models.SellOrder{Symbol: symbol, Quantity: quantity, PriceSold: w.price(t, symbol), Matching: models.MatchFIFO}
+	result, err := w.Store.SellShares(email, order)
+	if err != nil {
+		t.Fatalf("SellShares: %v", err)
+	}
+	return result
+}
+
+func (w *World) price(t testing.TB, symbol string) models.Money {
+	t.Helper()
+	quote, err := w.Quotes.Quote(symbol)
+	if err != nil {
+		t.Fatalf("Quote(%s): %v", symbol, err)
+	}
+	return quote.Price
+}
+
+// SetStatus moves the account of email to status.
+func (w *World) SetStatus(t testing.TB, email string, status models.AccountStatus) {
+	t.Helper()
+	_, err := w.Store.ChangeAccountStatus(email, models.StatusChange{To: status, Actor: "test"})
+	if err != nil {
+		t.Fatalf("ChangeAccountStatus: %v", err)
+	}
+}
+
+// Holdings are the open lots of email.
+func (w *World) Holdings(t testing.TB, email string) []models.Share {
+	t.Helper()
+	lots, err := w.Store.GetHoldings(email)
+	if err != nil {
+		t.Fatalf("GetHoldings: %v", err)
+	}
+	return lots
+}
+
+// AssertBalance checks the balance of email and that the ledger adds up to
+// it.
+func (w *World) AssertBalance(t testing.TB, email string, total string, available string) {
+	t.Helper()
+	balance, err := w.Store.GetBalance(email)
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	if balance.Total != USD(t, total) || balance.Available != USD(t, available) {
+		t.Fatalf("balance is %s, %s available; want %s, %s available", balance.Total, balance.Available, total, available)
+	}
+	verification, err := w.Store.VerifyBalance(email)
+	if err != nil {
+		t.Fatalf("VerifyBalance: %v", err)
+	}
+	if !verification.Consistent {
+		t.Fatalf("ledger derives %s but %s is stored", verification.Derived, verification.Stored)
+	}
+}